@@ -0,0 +1,227 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrSaturated is returned when a request could not be admitted, because the
+// queue was full or the wait took too long.
+var ErrSaturated = errors.New(`Server is busy, try again later.`)
+
+const (
+	// HoldLocal holds a func() func() for handlers that keep working after
+	// they returned, like streamed responses. Calling it hands the release of
+	// the admission over to the handler.
+	HoldLocal = `admission.hold`
+
+	// Decoded images take 4 bytes per pixel. Transforms keep the source and
+	// the result, which can be bigger than the source when rotating, and the
+	// encoder buffers its output, so a request peaks at a few times that.
+	bytesPerPixel = 4
+	peakFactor    = 4
+	// Base cost of a request, whatever its images.
+	requestOverhead = 1 << 20
+)
+
+type Config struct {
+	// MemoryBudget in bytes shared by every admitted request.
+	MemoryBudget int64
+	// Slots is how many requests run at once, usually the CPU count.
+	Slots int
+	// MaxQueue requests wait for admission at most, others are rejected.
+	MaxQueue int
+	MaxWait  time.Duration
+}
+
+// Controller admits requests while their estimated memory fits the budget
+// and a slot is free. Waiting requests are admitted in arrival order, so a
+// big request is not starved by smaller ones.
+type Controller struct {
+	mu        sync.Mutex
+	config    Config
+	usedBytes int64
+	usedSlots int
+	queue     []*waiter
+	// Average time a request held its admission, to suggest a Retry-After.
+	averageHold time.Duration
+}
+
+type waiter struct {
+	memory int64
+	ready  chan struct{}
+}
+
+func NewController(config Config) *Controller {
+	return &Controller{config: config}
+}
+
+// EstimateMemory is the peak memory of processing an image of width x height.
+func EstimateMemory(width int, height int) int64 {
+	return int64(width) * int64(height) * bytesPerPixel * peakFactor
+}
+
+// Admission is the memory and slot a request was admitted with.
+type Admission struct {
+	controller *Controller
+	admittedAt time.Time
+	// memory is guarded by the mutex of the controller.
+	memory   int64
+	released bool
+}
+
+// Acquire waits until memory bytes and a slot are available. Release gives
+// them back.
+func (c *Controller) Acquire(ctx context.Context, memory int64) (*Admission, error) {
+
+	c.mu.Lock()
+	// A request bigger than the whole budget can still run on its own.
+	memory = min(max(memory, 0)+requestOverhead, c.config.MemoryBudget)
+	if len(c.queue) == 0 && c.fits(memory) {
+		c.admit(memory)
+		c.mu.Unlock()
+		return c.admission(memory), nil
+	}
+	if len(c.queue) >= c.config.MaxQueue {
+		c.mu.Unlock()
+		return nil, ErrSaturated
+	}
+
+	w := &waiter{memory: memory, ready: make(chan struct{})}
+	c.queue = append(c.queue, w)
+	maxWait := c.config.MaxWait
+	c.mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		// Update may have lowered what the waiter was admitted with.
+		return c.admission(w.memory), nil
+	case <-timer.C:
+		err = ErrSaturated
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dequeue(w) {
+		// Admitted while giving up, hand the admission back.
+		c.usedBytes -= w.memory
+		c.usedSlots--
+	}
+	// Whoever waited behind may fit now.
+	c.grant()
+
+	return nil, err
+}
+
+// Update replaces the budget, slots and queue limits. Requests already
+// admitted keep their admission, so usage can stay over a lowered budget until
+// they finish, while waiters are admitted at once if a raised budget fits them.
+func (c *Controller) Update(config Config) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config = config
+	for _, w := range c.queue {
+		w.memory = min(w.memory, config.MemoryBudget)
+	}
+	c.grant()
+}
+
+// RetryAfter suggests when a rejected request should come back, from how long
+// requests hold their admission and how many are waiting.
+func (c *Controller) RetryAfter() time.Duration {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wait := c.averageHold * time.Duration(len(c.queue)+1) / time.Duration(max(c.config.Slots, 1))
+
+	return max(time.Second, time.Duration(math.Ceil(wait.Seconds()))*time.Second)
+}
+
+func (c *Controller) admission(memory int64) *Admission {
+	return &Admission{controller: c, admittedAt: time.Now(), memory: memory}
+}
+
+// Release gives the memory and slot back, it may be called more than once.
+func (a *Admission) Release() {
+
+	c := a.controller
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if a.released {
+		return
+	}
+	a.released = true
+
+	c.usedBytes -= a.memory
+	c.usedSlots--
+	c.averageHold += (time.Since(a.admittedAt) - c.averageHold) / 8
+	c.grant()
+}
+
+// Lower gives back what the admission holds beyond memory bytes, once the
+// request is known to need less than it was admitted with. It never raises
+// what is held, so it never waits.
+func (a *Admission) Lower(memory int64) {
+
+	c := a.controller
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	memory = max(memory, 0) + requestOverhead
+	if a.released || memory >= a.memory {
+		return
+	}
+
+	c.usedBytes -= a.memory - memory
+	a.memory = memory
+	c.grant()
+}
+
+// fits must be called with mu held.
+func (c *Controller) fits(memory int64) bool {
+	return c.usedSlots < c.config.Slots && c.usedBytes+memory <= c.config.MemoryBudget
+}
+
+// admit must be called with mu held.
+func (c *Controller) admit(memory int64) {
+	c.usedBytes += memory
+	c.usedSlots++
+}
+
+// grant admits waiters in order for as long as they fit. Must be called with
+// mu held.
+func (c *Controller) grant() {
+	for len(c.queue) > 0 && c.fits(c.queue[0].memory) {
+		w := c.queue[0]
+		c.queue = c.queue[1:]
+		c.admit(w.memory)
+		close(w.ready)
+	}
+}
+
+// dequeue removes a waiter that was not admitted yet, it returns false when
+// it already was. Must be called with mu held.
+func (c *Controller) dequeue(w *waiter) bool {
+	for i, queued := range c.queue {
+		if queued == w {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
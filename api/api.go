@@ -0,0 +1,14 @@
+// Package api holds what the server and its clients share: the paths and
+// headers of the API and the types of request and response bodies. It only
+// depends on the standard library, so clients can import it without the
+// dependencies of the server.
+package api
+
+// Versions of the API are mounted under their prefix. The unversioned paths
+// of the routes from before versioning answer like v1.
+const (
+	APIv1 = `/v1`
+	APIv2 = `/v2`
+)
+
+const APIKeyHeader = `X-API-Key`
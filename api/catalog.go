@@ -0,0 +1,124 @@
+package api
+
+import "net/http"
+
+// Code is the machine-readable kind of a problem. Codes are stable, clients
+// switch on them, while titles and details may be reworded.
+type Code string
+
+const (
+	// Requests the server could not make sense of.
+	InvalidRequest       Code = `invalid_request`
+	InvalidBody          Code = `invalid_body`
+	InvalidMetadata      Code = `invalid_metadata`
+	RequestTooLarge      Code = `request_too_large`
+	UnsupportedMediaType Code = `unsupported_media_type`
+	NotFound             Code = `not_found`
+	MethodNotAllowed     Code = `method_not_allowed`
+
+	// Uploads and the parameters of their processing.
+	MissingImage      Code = `missing_image`
+	FileTooLarge      Code = `file_too_large`
+	UnsupportedFormat Code = `unsupported_format`
+	InvalidImage      Code = `invalid_image`
+	ImageTooLarge     Code = `image_too_large`
+	UnknownOperation  Code = `unknown_operation`
+	InvalidParameters Code = `invalid_parameters`
+	InvalidBounds     Code = `invalid_bounds`
+	InvalidArchive    Code = `invalid_archive`
+	ArchiveTooLarge   Code = `archive_too_large`
+	TooManyImages     Code = `too_many_images`
+
+	// Presets, keys and stored sets.
+	InvalidPreset    Code = `invalid_preset`
+	UnknownPreset    Code = `unknown_preset`
+	PresetNotFound   Code = `preset_not_found`
+	APIKeyNotFound   Code = `api_key_not_found`
+	StorageDisabled  Code = `storage_disabled`
+	AdminAPIDisabled Code = `admin_api_disabled`
+
+	// Who the caller is and what it may do.
+	Unauthenticated    Code = `unauthenticated`
+	InvalidCredentials Code = `invalid_credentials`
+	Forbidden          Code = `forbidden`
+	ClientBlocked      Code = `client_blocked`
+
+	// Limits, the response tells when to come back in Retry-After.
+	RateLimited         Code = `rate_limited`
+	QuotaExceeded       Code = `quota_exceeded`
+	CostBudgetExhausted Code = `cost_budget_exhausted`
+	ServerBusy          Code = `server_busy`
+
+	// Failures on the server's side.
+	ProcessingTimeout Code = `processing_timeout`
+	InternalError     Code = `internal_error`
+)
+
+// Definition is the catalog entry of a code.
+type Definition struct {
+	Code   Code   `json:"code"`
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+	// Description tells clients when the code is returned and what to do.
+	Description string `json:"description"`
+}
+
+var catalog = []Definition{
+	{InvalidRequest, http.StatusBadRequest, `Invalid request`, `The request is malformed in a way no other code describes.`},
+	{InvalidBody, http.StatusBadRequest, `Invalid body`, `The JSON body could not be read, fix its syntax or types.`},
+	{InvalidMetadata, http.StatusBadRequest, `Invalid metadata`, `The metadata form field is missing or is not valid JSON for the route.`},
+	{RequestTooLarge, http.StatusRequestEntityTooLarge, `Request too large`, `The request body is larger than the route accepts.`},
+	{UnsupportedMediaType, http.StatusUnsupportedMediaType, `Unsupported media type`, `The request body is not of a type the route accepts.`},
+	{NotFound, http.StatusNotFound, `Not found`, `No route or resource exists at this path.`},
+	{MethodNotAllowed, http.StatusMethodNotAllowed, `Method not allowed`, `The route exists but not for this method.`},
+
+	{MissingImage, http.StatusBadRequest, `Missing image`, `The image form field is missing.`},
+	{FileTooLarge, http.StatusRequestEntityTooLarge, `File too large`, `An uploaded file is larger than the server accepts.`},
+	{UnsupportedFormat, http.StatusUnsupportedMediaType, `Unsupported format`, `An upload or requested output is not a PNG or JPEG image.`},
+	{InvalidImage, http.StatusUnprocessableEntity, `Invalid image`, `An upload could not be decoded as an image of its format.`},
+	{ImageTooLarge, http.StatusUnprocessableEntity, `Image too large`, `An image is wider or higher than the server accepts.`},
+	{UnknownOperation, http.StatusBadRequest, `Unknown operation`, `An operation chain names an operation that does not exist, or is empty.`},
+	{InvalidParameters, http.StatusBadRequest, `Invalid parameters`, `The parameters of an operation are missing or out of range.`},
+	{InvalidBounds, http.StatusBadRequest, `Invalid bounds`, `Crop bounds are missing, inverted or outside of the image.`},
+	{InvalidArchive, http.StatusBadRequest, `Invalid archive`, `The uploaded archive is not a valid ZIP file or holds no images.`},
+	{ArchiveTooLarge, http.StatusRequestEntityTooLarge, `Archive too large`, `The uploaded archive, or what it unpacks to, is larger than the server accepts.`},
+	{TooManyImages, http.StatusBadRequest, `Too many images`, `A batch or responsive set holds more images or widths than the server accepts.`},
+
+	{InvalidPreset, http.StatusBadRequest, `Invalid preset`, `A preset definition or reference is invalid.`},
+	{UnknownPreset, http.StatusBadRequest, `Unknown preset`, `A request references a preset, or preset version, that does not exist.`},
+	{PresetNotFound, http.StatusNotFound, `Preset not found`, `The preset does not exist.`},
+	{APIKeyNotFound, http.StatusNotFound, `API key not found`, `The API key does not exist.`},
+	{StorageDisabled, http.StatusBadRequest, `Storage disabled`, `Storing responsive sets is not enabled on this server.`},
+	{AdminAPIDisabled, http.StatusForbidden, `Admin API disabled`, `The admin API is not enabled on this server.`},
+
+	{Unauthenticated, http.StatusUnauthorized, `Unauthenticated`, `The request carries no API key or bearer token.`},
+	{InvalidCredentials, http.StatusUnauthorized, `Invalid credentials`, `The API key, bearer token or admin token is not valid.`},
+	{Forbidden, http.StatusForbidden, `Forbidden`, `The credentials are valid but their scopes do not allow the request.`},
+	{ClientBlocked, http.StatusForbidden, `Client blocked`, `The client address is blocked.`},
+
+	{RateLimited, http.StatusTooManyRequests, `Rate limited`, `The client sent too many requests, retry after the Retry-After header.`},
+	{QuotaExceeded, http.StatusTooManyRequests, `Quota exceeded`, `The caller used up its quota, retry after the Retry-After header.`},
+	{CostBudgetExhausted, http.StatusTooManyRequests, `Cost budget exhausted`, `The caller used up its pixel processing budget, retry after the Retry-After header.`},
+	{ServerBusy, http.StatusServiceUnavailable, `Server busy`, `The server has no room for the request, retry after the Retry-After header.`},
+
+	{ProcessingTimeout, http.StatusServiceUnavailable, `Processing timeout`, `Processing the images took longer than the server allows.`},
+	{InternalError, http.StatusInternalServerError, `Internal error`, `Something went wrong on the server, the request ID helps finding out what.`},
+}
+
+var definitions = map[Code]Definition{}
+
+func init() {
+	for _, definition := range catalog {
+		definitions[definition.Code] = definition
+	}
+}
+
+// Catalog lists every code, grouped like the constants.
+func Catalog() []Definition {
+	return append([]Definition(nil), catalog...)
+}
+
+func Lookup(code Code) (Definition, bool) {
+	definition, ok := definitions[code]
+	return definition, ok
+}
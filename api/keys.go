@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"time"
+)
+
+// QuotaLimits caps usage within one period. Zero fields are unlimited.
+type QuotaLimits struct {
+	Requests    int64   `json:"requests,omitempty" yaml:"requests,omitempty"`
+	Megapixels  float64 `json:"megapixels,omitempty" yaml:"megapixels,omitempty"`
+	OutputBytes int64   `json:"outputBytes,omitempty" yaml:"outputBytes,omitempty"`
+}
+
+type QuotaPolicy struct {
+	Daily   QuotaLimits `json:"daily" yaml:"daily"`
+	Monthly QuotaLimits `json:"monthly" yaml:"monthly"`
+}
+
+func (p QuotaPolicy) IsZero() bool {
+	return p == QuotaPolicy{}
+}
+
+func (p QuotaPolicy) Validate() error {
+	for _, limits := range []QuotaLimits{p.Daily, p.Monthly} {
+		if limits.Requests < 0 || limits.Megapixels < 0 || limits.OutputBytes < 0 {
+			return errors.New(`Quota limits must not be negative.`)
+		}
+	}
+
+	return nil
+}
+
+type QuotaUsage struct {
+	Requests    int64   `json:"requests"`
+	Megapixels  float64 `json:"megapixels"`
+	OutputBytes int64   `json:"outputBytes"`
+}
+
+// CostBudget is a cost rate limit as it is configured, Units of cost per
+// window of WindowSeconds.
+type CostBudget struct {
+	Units         int `json:"units" yaml:"units"`
+	WindowSeconds int `json:"windowSeconds" yaml:"windowSeconds"`
+}
+
+func (b CostBudget) Validate() error {
+	if b.Units <= 0 || b.WindowSeconds <= 0 {
+		return errors.New(`Cost budget units and window must be positive.`)
+	}
+
+	return nil
+}
+
+// APIKey is what the server keeps about a key. Only the SHA-256 hash of the
+// secret is kept, the plain key is shown once when it is created.
+type APIKey struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	Scopes Scopes `json:"scopes"`
+	// Quota and CostBudget override the defaults when set.
+	Quota      *QuotaPolicy `json:"quota,omitempty"`
+	CostBudget *CostBudget  `json:"costBudget,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	RevokedAt  *time.Time   `json:"revokedAt,omitempty"`
+}
+
+type CreateAPIKeyBody struct {
+	Name       string       `json:"name"`
+	Scopes     Scopes       `json:"scopes"`
+	Quota      *QuotaPolicy `json:"quota"`
+	CostBudget *CostBudget  `json:"costBudget"`
+}
+
+// APIKeyResponse is an APIKey without its hash, which never leaves the server.
+type APIKeyResponse struct {
+	APIKey
+	Hash string `json:"hash,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+type UsageWindow struct {
+	Period  string      `json:"period"`
+	Used    QuotaUsage  `json:"used"`
+	Limits  QuotaLimits `json:"limits"`
+	ResetAt time.Time   `json:"resetAt"`
+}
+
+type UsageResponse struct {
+	Client  string      `json:"client"`
+	Daily   UsageWindow `json:"daily"`
+	Monthly UsageWindow `json:"monthly"`
+}
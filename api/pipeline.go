@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Operation is one step of an operation chain. Metadata is the same JSON the
+// matching single image route accepts in its `metadata` form field.
+type Operation struct {
+	Name     string          `json:"name"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// OutputOptions controls how a processed image is encoded. Zero values keep
+// the format the chain produced and the default JPEG quality.
+type OutputOptions struct {
+	Format  string `json:"format,omitempty" validate:"oneof=png jpeg jpg"`
+	Quality int    `json:"quality,omitempty" validate:"min=1,max=100"`
+}
+
+// PipelineMetadata takes either an operation chain or a preset reference, for
+// the images of a batch or the one image of a process request.
+type PipelineMetadata struct {
+	Preset     string        `json:"preset,omitempty"`
+	Operations []Operation   `json:"operations"`
+	Output     OutputOptions `json:"output"`
+}
+
+type RotateBody struct {
+	Angle *int `json:"angle" validate:"required"`
+}
+
+// CropMetaData is the rectangle kept, from MinX, MinY included to MaxX, MaxY
+// excluded.
+type CropMetaData struct {
+	MinX *int `json:"minX" validate:"required,min=0"`
+	MinY *int `json:"minY" validate:"required,min=0"`
+
+	MaxX *int `json:"maxX" validate:"required,gtfield=MinX"`
+	MaxY *int `json:"maxY" validate:"required,gtfield=MinY"`
+}
+
+// ResizeMetaData keeps the aspect ratio when only one of Width and Height is
+// set.
+type ResizeMetaData struct {
+	Height *int `json:"height" validate:"min=1,max=maxDimension"`
+	Width  *int `json:"width" validate:"required_without=Height,min=1,max=maxDimension"`
+}
+
+type ChangeFormatMetadata struct {
+	FormatName *string `json:"formatName" validate:"required,oneof=png jpeg jpg"`
+}
+
+type FlipMetadata struct {
+	Direction *string `json:"direction" validate:"required,oneof=horizontal vertical"`
+}
+
+// Preset is a named operation chain with output options. Every change to a
+// preset is stored as a new version so results produced by an older version
+// stay reproducible and distinguishable.
+type Preset struct {
+	Name       string        `json:"name"`
+	Version    int           `json:"version"`
+	Operations []Operation   `json:"operations"`
+	Output     OutputOptions `json:"output"`
+	UpdatedAt  time.Time     `json:"updatedAt"`
+}
+
+// Reference is the `name@version` form clients and caches can pin to.
+func (p Preset) Reference() string {
+	return p.Name + `@` + strconv.Itoa(p.Version)
+}
+
+type BatchManifestEntry struct {
+	Name    string `json:"name"`
+	Output  string `json:"output,omitempty"`
+	Success bool   `json:"success"`
+	// Skipped files, hidden ones and macOS metadata, were not processed.
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Code    Code   `json:"code,omitempty"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+}
+
+type BatchManifest struct {
+	Files []BatchManifestEntry `json:"files"`
+}
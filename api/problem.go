@@ -0,0 +1,55 @@
+package api
+
+import "strings"
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = `application/problem+json`
+
+// ProblemTypePrefix is followed by the code in the type of a problem, the
+// catalog entry of the code is served there.
+const ProblemTypePrefix = `/problems/`
+
+// Problem is the body of every error response, RFC 7807 problem details
+// extended with the code of the error and the ID of the request.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail explains this occurrence, clients should switch on Code instead.
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      Code   `json:"code"`
+	RequestID string `json:"requestId,omitempty"`
+	// Errors lists every field of the request that broke a rule.
+	Errors FieldErrors `json:"errors,omitempty"`
+}
+
+// FieldError is a field that broke a rule. Field is the path of its JSON
+// name, like output.quality or widths[2].
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// FieldErrors lists every field that broke a rule.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		messages = append(messages, fieldErr.Field+`: `+fieldErr.Reason)
+	}
+
+	return strings.Join(messages, `; `) + `.`
+}
+
+// Prefix puts prefix before the path of every field, for values nested in
+// another one.
+func (e FieldErrors) Prefix(prefix string) FieldErrors {
+	prefixed := make(FieldErrors, 0, len(e))
+	for _, fieldErr := range e {
+		prefixed = append(prefixed, FieldError{Field: prefix + fieldErr.Field, Reason: fieldErr.Reason})
+	}
+
+	return prefixed
+}
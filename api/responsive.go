@@ -0,0 +1,36 @@
+package api
+
+// ResponsiveMetaData asks for either explicit Widths or breakpoints derived
+// from SizeBudget, the minimum growth in bytes between two widths.
+type ResponsiveMetaData struct {
+	Widths     []int    `json:"widths" validate:"required_without=SizeBudget,max=maxResponsiveWidths,dive,min=1,max=maxDimension"`
+	SizeBudget *int     `json:"sizeBudget" validate:"min=1"`
+	MinWidth   *int     `json:"minWidth" validate:"min=1"`
+	MaxWidth   *int     `json:"maxWidth" validate:"min=1,gtefield=MinWidth"`
+	Formats    []string `json:"formats" validate:"dive,oneof=png jpeg jpg"`
+	Quality    int      `json:"quality" validate:"min=1,max=100"`
+	Sizes      string   `json:"sizes"`
+	Alt        string   `json:"alt"`
+	BaseURL    string   `json:"baseUrl"`
+	// Store writes the set to the storage directory of the server instead of
+	// returning a ZIP.
+	Store bool `json:"store"`
+}
+
+type ResponsiveImage struct {
+	File   string `json:"file"`
+	URL    string `json:"url"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int    `json:"bytes"`
+}
+
+type ResponsiveManifest struct {
+	Name    string            `json:"name"`
+	Width   int               `json:"width"`
+	Height  int               `json:"height"`
+	Images  []ResponsiveImage `json:"images"`
+	SrcSet  map[string]string `json:"srcset"`
+	Picture string            `json:"picture"`
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Operations a scope can name. Routes that run several operations, like
+// batch or preset requests, need every operation of their chain.
+var KnownOperations = []string{`resize`, `crop`, `rotate`, `flip`, `grayscale`, `changeformat`}
+
+var KnownFormats = []string{`png`, `jpeg`}
+
+// Scopes restrict what a caller may do. Empty lists and zero dimensions mean
+// no restriction.
+type Scopes struct {
+	Operations []string `json:"operations,omitempty"`
+	Formats    []string `json:"formats,omitempty"`
+	MaxWidth   int      `json:"maxWidth,omitempty"`
+	MaxHeight  int      `json:"maxHeight,omitempty"`
+}
+
+// ScopeError explains why an authenticated caller is not allowed a request.
+type ScopeError struct {
+	Reason string
+}
+
+func (e *ScopeError) Error() string {
+	return e.Reason
+}
+
+func (s Scopes) Validate() error {
+
+	for _, operation := range s.Operations {
+		if !slices.Contains(KnownOperations, operation) {
+			return &ScopeError{Reason: `Unknown operation in scopes: ` + operation}
+		}
+	}
+
+	for _, format := range s.Formats {
+		if !slices.Contains(KnownFormats, normalizeFormat(format)) {
+			return &ScopeError{Reason: `Unknown format in scopes: ` + format}
+		}
+	}
+
+	if s.MaxWidth < 0 || s.MaxHeight < 0 {
+		return &ScopeError{Reason: `Maximum dimensions must not be negative.`}
+	}
+
+	return nil
+}
+
+// Authorize checks one request against the scopes. Zero dimensions are not
+// checked, so it can be called before an image is decoded.
+func (s Scopes) Authorize(operations []string, format string, width int, height int) error {
+
+	if len(s.Operations) > 0 {
+		for _, operation := range operations {
+			if !slices.Contains(s.Operations, strings.ToLower(operation)) {
+				return &ScopeError{Reason: `Credentials are not allowed to use the ` + operation + ` operation.`}
+			}
+		}
+	}
+
+	if format != `` && len(s.Formats) > 0 && !slices.ContainsFunc(s.Formats, func(allowed string) bool {
+		return normalizeFormat(allowed) == normalizeFormat(format)
+	}) {
+		return &ScopeError{Reason: `Credentials are not allowed to output ` + normalizeFormat(format) + ` images.`}
+	}
+
+	if s.MaxWidth > 0 && width > s.MaxWidth || s.MaxHeight > 0 && height > s.MaxHeight {
+		return &ScopeError{Reason: `Credentials are limited to images of ` + dimensionLimit(s.MaxWidth) + `x` + dimensionLimit(s.MaxHeight) + ` pixels.`}
+	}
+
+	return nil
+}
+
+func normalizeFormat(format string) string {
+	format = strings.TrimPrefix(strings.ToLower(format), `.`)
+	if format == `jpg` {
+		return `jpeg`
+	}
+
+	return format
+}
+
+func dimensionLimit(limit int) string {
+	if limit == 0 {
+		return `any`
+	}
+
+	return strconv.Itoa(limit)
+}
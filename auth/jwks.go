@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	Algorithm string `json:"alg"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+var jwksClient = &http.Client{Timeout: time.Second * 5}
+
+// loadJWKS reads a key set from a file path or an http(s) URL and returns the
+// signing keys by key id.
+func loadJWKS(source string) (map[string]crypto.PublicKey, error) {
+
+	var content []byte
+	var err error
+	if strings.HasPrefix(source, `http://`) || strings.HasPrefix(source, `https://`) {
+		content, err = fetchJWKS(source)
+	} else {
+		content, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var set jsonWebKeySet
+	err = json.Unmarshal(content, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Use != `` && key.Use != `sig` {
+			continue
+		}
+
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, errors.New(`key ` + key.KeyID + `: ` + err.Error())
+		}
+		keys[key.KeyID] = publicKey
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New(`key set has no signing keys`)
+	}
+
+	return keys, nil
+}
+
+func fetchJWKS(url string) ([]byte, error) {
+
+	response, err := jwksClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(`key set endpoint answered ` + response.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(response.Body, 1024*1024))
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+
+	switch k.KeyType {
+	case `RSA`:
+		modulus, err := decodeBigInt(k.Modulus)
+		if err != nil {
+			return nil, err
+		}
+		exponent, err := decodeBigInt(k.Exponent)
+		if err != nil {
+			return nil, err
+		}
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New(`invalid RSA exponent`)
+		}
+
+		return &rsa.PublicKey{N: modulus, E: int(exponent.Int64())}, nil
+
+	case `EC`:
+		var curve elliptic.Curve
+		switch k.Curve {
+		case `P-256`:
+			curve = elliptic.P256()
+		case `P-384`:
+			curve = elliptic.P384()
+		case `P-521`:
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New(`unsupported curve ` + k.Curve)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New(`point is not on curve`)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errors.New(`unsupported key type ` + k.KeyType)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"imageProcessorAPI/api"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keyPrefix = `ipa_`
+
+var (
+	ErrInvalidKey  = errors.New(`Invalid API key.`)
+	ErrRevokedKey  = errors.New(`API key has been revoked.`)
+	ErrKeyNotFound = errors.New(`API key not found.`)
+)
+
+// APIKey is what the store keeps about a key. Only the SHA-256 hash of the
+// secret is kept, the plain key is shown once when it is created.
+type APIKey = api.APIKey
+
+type KeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+	// File the keys are persisted to, nothing is persisted when empty.
+	path string
+	// written is the SHA-256 of what the store last wrote to the file.
+	written string
+}
+
+// KeyFile is the keys read from the file of a store, see Read.
+type KeyFile struct {
+	keys map[string]*APIKey
+}
+
+// NewKeyStore loads the keys persisted at path, if any.
+func NewKeyStore(path string) (*KeyStore, error) {
+
+	keys, err := readKeys(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyStore{keys: keys, path: path}, nil
+}
+
+// Read reads the keys persisted in the file of the store, to pick up keys
+// added or revoked by editing it. Replace makes them take effect, once
+// whatever else depends on the file was read too.
+func (s *KeyStore) Read() (KeyFile, error) {
+
+	keys, err := readKeys(s.path)
+	if err != nil {
+		return KeyFile{}, err
+	}
+
+	return KeyFile{keys: keys}, nil
+}
+
+// Replace replaces the keys of the store with the ones of file.
+func (s *KeyStore) Replace(file KeyFile) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = file.keys
+}
+
+// Written returns the SHA-256 of what the store last wrote to its file in
+// hex, empty when it did not write yet. Changes to the file that match it are
+// the store's own.
+func (s *KeyStore) Written() string {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.written
+}
+
+func readKeys(path string) (map[string]*APIKey, error) {
+
+	keys := map[string]*APIKey{}
+	if path == `` {
+		return keys, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var persisted []*APIKey
+	err = json.Unmarshal(content, &persisted)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range persisted {
+		keys[key.ID] = key
+	}
+
+	return keys, nil
+}
+
+// Create stores a new key with the name, scopes, quota and cost budget of
+// template and returns it with its plain text form.
+func (s *KeyStore) Create(template APIKey) (string, APIKey, error) {
+
+	err := template.Scopes.Validate()
+	if err != nil {
+		return ``, APIKey{}, err
+	}
+	if template.Quota != nil {
+		err = template.Quota.Validate()
+		if err != nil {
+			return ``, APIKey{}, &ScopeError{Reason: err.Error()}
+		}
+	}
+	if template.CostBudget != nil {
+		err = template.CostBudget.Validate()
+		if err != nil {
+			return ``, APIKey{}, &ScopeError{Reason: err.Error()}
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return ``, APIKey{}, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return ``, APIKey{}, err
+	}
+
+	plain := keyPrefix + id + `_` + secret
+	key := &APIKey{
+		ID:         id,
+		Name:       template.Name,
+		Hash:       hashKey(plain),
+		Scopes:     template.Scopes,
+		Quota:      template.Quota,
+		CostBudget: template.CostBudget,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[id] = key
+	err = s.persist()
+	if err != nil {
+		delete(s.keys, id)
+		return ``, APIKey{}, err
+	}
+
+	return plain, *key, nil
+}
+
+// Revoke keeps the key in the store so later uses get a clear reason.
+func (s *KeyStore) Revoke(id string) (APIKey, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, ErrKeyNotFound
+	}
+
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+
+		err := s.persist()
+		if err != nil {
+			key.RevokedAt = nil
+			return APIKey{}, err
+		}
+	}
+
+	return *key, nil
+}
+
+// List returns every key, oldest first.
+func (s *KeyStore) List() []APIKey {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	return keys
+}
+
+// Authenticate returns the key a plain text key belongs to.
+func (s *KeyStore) Authenticate(plain string) (APIKey, error) {
+
+	// The id is embedded in the key so the lookup does not depend on the secret.
+	id, _, ok := strings.Cut(strings.TrimPrefix(plain, keyPrefix), `_`)
+	if !ok || !strings.HasPrefix(plain, keyPrefix) {
+		return APIKey{}, ErrInvalidKey
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashKey(plain))) != 1 {
+		return APIKey{}, ErrInvalidKey
+	}
+
+	if key.RevokedAt != nil {
+		return APIKey{}, ErrRevokedKey
+	}
+
+	return *key, nil
+}
+
+func (s *KeyStore) persist() error {
+
+	if s.path == `` {
+		return nil
+	}
+
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	content, err := json.MarshalIndent(keys, ``, `  `)
+	if err != nil {
+		return err
+	}
+
+	// Written next to the target and renamed so a crash never leaves a
+	// truncated key file behind.
+	temporaryPath := s.path + `.tmp`
+	err = os.WriteFile(temporaryPath, content, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(temporaryPath, s.path)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(content)
+	s.written = hex.EncodeToString(sum[:])
+
+	return nil
+}
+
+func hashKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (string, error) {
+	bytes := make([]byte, size)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return ``, err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticateLooksUpKeysByHash(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `api_keys.json`)
+	store, err := NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, created, err := store.Create(APIKey{Name: `client`, Scopes: Scopes{Operations: []string{`resize`}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the hash of the key is persisted, by which it is found again after
+	// a restart.
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), plain) || !strings.Contains(string(content), hashKey(plain)) {
+		t.Fatalf(`got %s persisted, want only the hash of the key`, content)
+	}
+	sum := sha256.Sum256(content)
+	if store.Written() != hex.EncodeToString(sum[:]) {
+		t.Fatalf(`got %s written, want the hash of the file`, store.Written())
+	}
+	store, err = NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, secret, _ := strings.Cut(strings.TrimPrefix(plain, keyPrefix), `_`)
+	keys := []struct {
+		name  string
+		plain string
+		want  error
+	}{
+		{`created`, plain, nil},
+		{`wrong secret`, keyPrefix + created.ID + `_` + strings.Repeat(`0`, len(secret)), ErrInvalidKey},
+		{`unknown id`, keyPrefix + `0000000000000000_` + secret, ErrInvalidKey},
+		{`without prefix`, strings.TrimPrefix(plain, keyPrefix), ErrInvalidKey},
+		{`without secret`, keyPrefix + created.ID, ErrInvalidKey},
+		{`empty`, ``, ErrInvalidKey},
+	}
+	for _, key := range keys {
+		got, err := store.Authenticate(key.plain)
+		if !errors.Is(err, key.want) {
+			t.Fatalf(`%s: got %v, want %v`, key.name, err, key.want)
+		}
+		if err == nil && (got.ID != created.ID || got.Scopes.Operations[0] != `resize`) {
+			t.Fatalf(`%s: got %+v, want the created key`, key.name, got)
+		}
+	}
+}
+
+func TestRevokedKeysAreRejected(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `api_keys.json`)
+	store, err := NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, created, err := store.Create(APIKey{Name: `client`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Revoke(`unknown`)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf(`got %v, want %v`, err, ErrKeyNotFound)
+	}
+	revoked, err := store.Revoke(created.ID)
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf(`got %+v and %v, want the key revoked`, revoked, err)
+	}
+
+	_, err = store.Authenticate(plain)
+	if !errors.Is(err, ErrRevokedKey) {
+		t.Fatalf(`got %v, want %v`, err, ErrRevokedKey)
+	}
+
+	// The revocation is persisted, and a wrong secret still reads as invalid
+	// rather than revealing the key was revoked.
+	store, err = NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Authenticate(plain)
+	if !errors.Is(err, ErrRevokedKey) {
+		t.Fatalf(`got %v after a restart, want %v`, err, ErrRevokedKey)
+	}
+	_, err = store.Authenticate(keyPrefix + created.ID + `_wrong`)
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf(`got %v, want %v`, err, ErrInvalidKey)
+	}
+}
+
+func TestReplacePicksUpEditsOfTheFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `api_keys.json`)
+	store, err := NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, created, err := store.Create(APIKey{Name: `client`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone revokes the key by editing the file of a running server.
+	other, err := NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.Revoke(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Authenticate(plain); err != nil {
+		t.Fatalf(`got %v, want the key valid until the file is read`, err)
+	}
+
+	file, err := store.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Replace(file)
+	_, err = store.Authenticate(plain)
+	if !errors.Is(err, ErrRevokedKey) {
+		t.Fatalf(`got %v, want %v`, err, ErrRevokedKey)
+	}
+
+	// A file that cannot be read changes nothing.
+	err = os.WriteFile(path, []byte(`{`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Read()
+	if err == nil {
+		t.Fatal(`got no error, want the broken file rejected`)
+	}
+}
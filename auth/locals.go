@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"imageProcessorAPI/clientip"
+	"imageProcessorAPI/quota"
+	"imageProcessorAPI/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LocalsKey is where the authentication middleware stores the caller.
+const LocalsKey = `principal`
+
+const (
+	MethodAPIKey = `api_key`
+	MethodJWT    = `jwt`
+)
+
+// Principal is an authenticated caller, whichever way it authenticated.
+type Principal struct {
+	// ID is the API key id or the token subject.
+	ID     string
+	Name   string
+	Method string
+	Scopes Scopes
+	// Quota and CostBudget override the defaults when set.
+	Quota      *quota.Policy
+	CostBudget *ratelimit.Budget
+}
+
+// QuotaPolicy returns the caller's own quota policy, or defaults.
+func (p Principal) QuotaPolicy(defaults quota.Policy) quota.Policy {
+	if p.Quota != nil {
+		return *p.Quota
+	}
+
+	return defaults
+}
+
+// Caller returns the authenticated caller, if any.
+func Caller(c *fiber.Ctx) (Principal, bool) {
+	principal, ok := c.Locals(LocalsKey).(Principal)
+	return principal, ok
+}
+
+// CallerScopes returns the scopes of the authenticated caller, if any.
+func CallerScopes(c *fiber.Ctx) (Scopes, bool) {
+	principal, ok := Caller(c)
+	if !ok {
+		return Scopes{}, false
+	}
+
+	return principal.Scopes, true
+}
+
+// CostPolicy returns the caller's own cost budget, or defaults.
+func (p Principal) CostPolicy(defaults ratelimit.Budget) ratelimit.Policy {
+	if p.CostBudget != nil {
+		return ratelimit.BudgetPolicy(*p.CostBudget)
+	}
+
+	return ratelimit.BudgetPolicy(defaults)
+}
+
+// ClientID identifies the caller for quotas and rate limits. Callers that did
+// not authenticate, as when authentication is off, are told apart by address.
+func ClientID(c *fiber.Ctx) string {
+	principal, ok := Caller(c)
+	if !ok {
+		return `ip:` + clientip.FromContext(c)
+	}
+
+	return principal.Method + `:` + principal.ID
+}
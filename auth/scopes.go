@@ -0,0 +1,10 @@
+package auth
+
+import "imageProcessorAPI/api"
+
+// Scopes restrict what a caller may do. Empty lists and zero dimensions mean
+// no restriction.
+type Scopes = api.Scopes
+
+// ScopeError explains why an authenticated caller is not allowed a request.
+type ScopeError = api.ScopeError
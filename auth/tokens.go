@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto"
+	"errors"
+	"imageProcessorAPI/metrics"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidToken   = errors.New(`Invalid bearer token.`)
+	ErrExpiredToken   = errors.New(`Bearer token has expired.`)
+	ErrTokenAudience  = errors.New(`Bearer token audience is not accepted.`)
+	ErrTokenIssuer    = errors.New(`Bearer token issuer is not accepted.`)
+	ErrTokenNoScopes  = errors.New(`Bearer token has no scopes.`)
+	ErrTokenNoSubject = errors.New(`Bearer token has no subject.`)
+	ErrTokensDisabled = errors.New(`Bearer tokens are not accepted, use an API key.`)
+)
+
+const (
+	defaultJWKSCacheDuration = time.Minute * 5
+	// An unknown key id forces a refresh, at most this often, so a rotated
+	// key is picked up without letting bad tokens hammer the key source.
+	minJWKSRefreshInterval = time.Second * 30
+)
+
+type TokenConfig struct {
+	// JWKS is a file path or an http(s) URL serving the key set.
+	JWKS          string
+	Issuer        string
+	Audience      string
+	CacheDuration time.Duration
+	Leeway        time.Duration
+}
+
+// TokenClaims maps token claims to Scopes. Scope lists operations separated
+// by spaces, `*` allowing all of them.
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	Name      string   `json:"name,omitempty"`
+	Scope     string   `json:"scope"`
+	Formats   []string `json:"formats,omitempty"`
+	MaxWidth  int      `json:"max_width,omitempty"`
+	MaxHeight int      `json:"max_height,omitempty"`
+}
+
+type TokenVerifier struct {
+	config TokenConfig
+	parser *jwt.Parser
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	loadedAt    time.Time
+	refreshedAt time.Time
+}
+
+// NewTokenVerifier loads the key set once so a bad configuration fails at
+// startup rather than on the first request.
+func NewTokenVerifier(config TokenConfig) (*TokenVerifier, error) {
+
+	if config.Issuer == `` || config.Audience == `` {
+		return nil, errors.New(`Token issuer and audience must be set.`)
+	}
+	if config.CacheDuration == 0 {
+		config.CacheDuration = defaultJWKSCacheDuration
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{`RS256`, `RS384`, `RS512`, `PS256`, `PS384`, `PS512`, `ES256`, `ES384`, `ES512`}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(config.Issuer),
+		jwt.WithAudience(config.Audience),
+		jwt.WithLeeway(config.Leeway),
+	)
+
+	verifier := &TokenVerifier{config: config, parser: parser}
+	err := verifier.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	return verifier, nil
+}
+
+// Verify validates a token and maps its claims to a Principal.
+func (v *TokenVerifier) Verify(token string) (Principal, error) {
+
+	claims := TokenClaims{}
+	_, err := v.parser.ParseWithClaims(token, &claims, v.key)
+	switch {
+	case err == nil:
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return Principal{}, ErrExpiredToken
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return Principal{}, ErrTokenAudience
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return Principal{}, ErrTokenIssuer
+	default:
+		return Principal{}, ErrInvalidToken
+	}
+
+	// Callers are told apart by subject, for quotas and rate limits, so
+	// tokens without one would all count as one caller.
+	if claims.Subject == `` {
+		return Principal{}, ErrTokenNoSubject
+	}
+
+	scopes := Scopes{
+		Operations: strings.Fields(claims.Scope),
+		Formats:    claims.Formats,
+		MaxWidth:   claims.MaxWidth,
+		MaxHeight:  claims.MaxHeight,
+	}
+	if len(scopes.Operations) == 0 {
+		return Principal{}, ErrTokenNoScopes
+	}
+	if len(scopes.Operations) == 1 && scopes.Operations[0] == `*` {
+		scopes.Operations = nil
+	}
+
+	return Principal{ID: claims.Subject, Name: claims.Name, Method: MethodJWT, Scopes: scopes}, nil
+}
+
+func (v *TokenVerifier) key(token *jwt.Token) (any, error) {
+
+	keyID, _ := token.Header[`kid`].(string)
+
+	v.mu.RLock()
+	key, ok := v.keys[keyID]
+	expired := time.Since(v.loadedAt) > v.config.CacheDuration
+	canRefresh := time.Since(v.refreshedAt) > minJWKSRefreshInterval
+	v.mu.RUnlock()
+
+	metrics.ObserveCache(metrics.CacheJWKS, ok && !expired)
+	if (expired || !ok) && canRefresh {
+		err := v.refresh()
+		if err != nil {
+			// Keep using the cached keys while the key source is unavailable.
+			slog.Error(`Could not refresh JWKS. Error: ` + err.Error())
+		}
+
+		v.mu.RLock()
+		key, ok = v.keys[keyID]
+		v.mu.RUnlock()
+	}
+
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	return key, nil
+}
+
+func (v *TokenVerifier) refresh() error {
+
+	v.mu.Lock()
+	v.refreshedAt = time.Now()
+	v.mu.Unlock()
+
+	keys, err := loadJWKS(v.config.JWKS)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.loadedAt = time.Now()
+	v.mu.Unlock()
+
+	return nil
+}
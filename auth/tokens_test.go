@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = `https://issuer.example`
+	testAudience = `image-api`
+)
+
+// keySource serves a JWKS of its keys and counts how often it was fetched.
+type keySource struct {
+	mu      sync.Mutex
+	keys    map[string]*ecdsa.PrivateKey
+	fetches atomic.Int32
+	server  *httptest.Server
+}
+
+func newKeySource(t *testing.T, keyIDs ...string) *keySource {
+	t.Helper()
+
+	source := &keySource{keys: map[string]*ecdsa.PrivateKey{}}
+	for _, keyID := range keyIDs {
+		source.add(t, keyID)
+	}
+	source.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source.fetches.Add(1)
+
+		source.mu.Lock()
+		defer source.mu.Unlock()
+
+		set := jsonWebKeySet{}
+		for keyID, key := range source.keys {
+			set.Keys = append(set.Keys, jsonWebKey{
+				KeyType: `EC`,
+				KeyID:   keyID,
+				Use:     `sig`,
+				Curve:   `P-256`,
+				X:       base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				Y:       base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(source.server.Close)
+
+	return source
+}
+
+// add generates a key, or replaces the one of keyID, as rotating keys does.
+func (s *keySource) add(t *testing.T, keyID string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[keyID] = key
+}
+
+func (s *keySource) sign(t *testing.T, keyID string, claims jwt.Claims) string {
+	t.Helper()
+
+	s.mu.Lock()
+	key := s.keys[keyID]
+	s.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header[`kid`] = keyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+// validClaims are accepted by a verifier of the test issuer and audience.
+func validClaims() TokenClaims {
+	now := time.Now()
+	return TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Audience:  jwt.ClaimStrings{testAudience},
+			Subject:   `client-1`,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Name:     `Client`,
+		Scope:    `resize crop`,
+		Formats:  []string{`png`},
+		MaxWidth: 800,
+	}
+}
+
+func newTestVerifier(t *testing.T, source *keySource) *TokenVerifier {
+	t.Helper()
+
+	verifier, err := NewTokenVerifier(TokenConfig{
+		JWKS:     source.server.URL,
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		Leeway:   time.Second * 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return verifier
+}
+
+func TestVerifyChecksClaims(t *testing.T) {
+
+	source := newKeySource(t, `current`)
+	verifier := newTestVerifier(t, source)
+
+	tokens := []struct {
+		name   string
+		change func(claims *TokenClaims)
+		want   error
+	}{
+		{`valid`, func(claims *TokenClaims) {}, nil},
+		{`other issuer`, func(claims *TokenClaims) { claims.Issuer = `https://other.example` }, ErrTokenIssuer},
+		{`no issuer`, func(claims *TokenClaims) { claims.Issuer = `` }, ErrInvalidToken},
+		{`other audience`, func(claims *TokenClaims) { claims.Audience = jwt.ClaimStrings{`other-api`} }, ErrTokenAudience},
+		{`no audience`, func(claims *TokenClaims) { claims.Audience = nil }, ErrInvalidToken},
+		{`expired`, func(claims *TokenClaims) { claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }, ErrExpiredToken},
+		{`expired within leeway`, func(claims *TokenClaims) { claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Second * 10)) }, nil},
+		{`without expiry`, func(claims *TokenClaims) { claims.ExpiresAt = nil }, ErrInvalidToken},
+		{`not yet valid`, func(claims *TokenClaims) { claims.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute)) }, ErrInvalidToken},
+		{`no subject`, func(claims *TokenClaims) { claims.Subject = `` }, ErrTokenNoSubject},
+		{`no scopes`, func(claims *TokenClaims) { claims.Scope = `` }, ErrTokenNoScopes},
+	}
+	for _, token := range tokens {
+		claims := validClaims()
+		token.change(&claims)
+
+		principal, err := verifier.Verify(source.sign(t, `current`, claims))
+		if !errors.Is(err, token.want) {
+			t.Fatalf(`%s: got %v, want %v`, token.name, err, token.want)
+		}
+		if err == nil && (principal.ID != `client-1` || principal.Method != MethodJWT) {
+			t.Fatalf(`%s: got principal %+v, want client-1 authenticated by token`, token.name, principal)
+		}
+	}
+
+	// Claims map to scopes, * allowing every operation.
+	principal, err := verifier.Verify(source.sign(t, `current`, validClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopes := principal.Scopes
+	if len(scopes.Operations) != 2 || scopes.Formats[0] != `png` || scopes.MaxWidth != 800 || principal.Name != `Client` {
+		t.Fatalf(`got principal %+v, want the scopes of the claims`, principal)
+	}
+	claims := validClaims()
+	claims.Scope = `*`
+	principal, err = verifier.Verify(source.sign(t, `current`, claims))
+	if err != nil || principal.Scopes.Operations != nil {
+		t.Fatalf(`got %v and operations %v, want every operation allowed`, err, principal.Scopes.Operations)
+	}
+}
+
+func TestVerifyPinsAlgorithms(t *testing.T) {
+
+	source := newKeySource(t, `current`)
+	verifier := newTestVerifier(t, source)
+
+	// A token signed with HMAC, keyed with the public key anyone can fetch.
+	source.mu.Lock()
+	publicKey := source.keys[`current`].PublicKey
+	source.mu.Unlock()
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+	hmacToken.Header[`kid`] = `current`
+	signed, err := hmacToken.SignedString(elliptic.MarshalCompressed(publicKey.Curve, publicKey.X, publicKey.Y))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifier.Verify(signed)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf(`got %v for an HS256 token, want it rejected`, err)
+	}
+
+	// An unsigned one.
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifier.Verify(unsigned)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf(`got %v for an unsigned token, want it rejected`, err)
+	}
+
+	// And one signed by a key the issuer does not publish.
+	other := newKeySource(t, `current`)
+	_, err = verifier.Verify(other.sign(t, `current`, validClaims()))
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf(`got %v for a token of another key, want it rejected`, err)
+	}
+}
+
+func TestVerifyPicksUpRotatedKeys(t *testing.T) {
+
+	source := newKeySource(t, `first`)
+	verifier := newTestVerifier(t, source)
+	if source.fetches.Load() != 1 {
+		t.Fatalf(`got %d fetches, want the key set fetched when the verifier is created`, source.fetches.Load())
+	}
+
+	// Unknown key ids do not refresh the key set more than once per interval.
+	_, err := verifier.Verify(newKeySource(t, `unknown`).sign(t, `unknown`, validClaims()))
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf(`got %v for an unknown key id, want it rejected`, err)
+	}
+	source.add(t, `second`)
+	_, err = verifier.Verify(source.sign(t, `second`, validClaims()))
+	if !errors.Is(err, ErrInvalidToken) || source.fetches.Load() != 1 {
+		t.Fatalf(`got %v after %d fetches, want the key set not fetched again so soon`, err, source.fetches.Load())
+	}
+
+	// Once the interval passed, the key the issuer rotated to is fetched.
+	verifier.mu.Lock()
+	verifier.refreshedAt = time.Now().Add(-minJWKSRefreshInterval)
+	verifier.mu.Unlock()
+	_, err = verifier.Verify(source.sign(t, `second`, validClaims()))
+	if err != nil || source.fetches.Load() != 2 {
+		t.Fatalf(`got %v after %d fetches, want the rotated key fetched once`, err, source.fetches.Load())
+	}
+	_, err = verifier.Verify(source.sign(t, `first`, validClaims()))
+	if err != nil {
+		t.Fatalf(`got %v, want the first key still accepted`, err)
+	}
+}
+
+func TestNewTokenVerifierRequiresIssuerAndAudience(t *testing.T) {
+
+	source := newKeySource(t, `current`)
+	for _, config := range []TokenConfig{
+		{JWKS: source.server.URL, Audience: testAudience},
+		{JWKS: source.server.URL, Issuer: testIssuer},
+	} {
+		_, err := NewTokenVerifier(config)
+		if err == nil {
+			t.Fatalf(`got a verifier of %+v, want issuer and audience required`, config)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package client calls the v2 API of the image processor, with the request
+// and response types of package api, which the server uses too. Uploads and
+// processed images are streamed, requests answered 429 or 503 are retried
+// after the Retry-After the server sent, and error responses are returned as
+// *Error.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"imageProcessorAPI/api"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaxRetries   = 3
+	DefaultMaxRetryWait = 30 * time.Second
+
+	// initialBackoff is waited before the first retry of responses without
+	// Retry-After, doubling with every retry.
+	initialBackoff = 500 * time.Millisecond
+)
+
+type Client struct {
+	baseURL string
+
+	// APIKey is sent in the X-API-Key header.
+	APIKey string
+	// Token is sent as a bearer token, the admin token for admin routes.
+	Token string
+
+	HTTPClient *http.Client
+	// MaxRetries is how many times a request answered 429 or 503 is sent
+	// again.
+	MaxRetries int
+	// MaxRetryWait caps the wait before a retry. Responses asking to wait
+	// longer are returned as errors instead.
+	MaxRetryWait time.Duration
+}
+
+// New returns a client of the server at baseURL, like
+// `https://images.example.com`.
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:      strings.TrimSuffix(baseURL, `/`),
+		HTTPClient:   http.DefaultClient,
+		MaxRetries:   DefaultMaxRetries,
+		MaxRetryWait: DefaultMaxRetryWait,
+	}
+}
+
+// Result is a processed image or archive, streamed from the server. Callers
+// must close it.
+type Result struct {
+	io.ReadCloser
+	ContentType string
+	// Preset is the `name@version` reference of the preset that produced the
+	// result, if any.
+	Preset    string
+	RequestID string
+}
+
+// body is the body of a request, opened again for every attempt.
+type body interface {
+	open() (io.ReadCloser, string, error)
+	// replayable reports whether the body can be opened again.
+	replayable() bool
+}
+
+type jsonBody []byte
+
+func (b jsonBody) open() (io.ReadCloser, string, error) {
+	return io.NopCloser(bytes.NewReader(b)), `application/json`, nil
+}
+
+func (b jsonBody) replayable() bool {
+	return true
+}
+
+type request struct {
+	method string
+	// path is relative to the base URL, with its query.
+	path string
+	body body
+}
+
+// do sends r until it is answered with anything but a retryable error, and
+// returns the response of statuses below 400. Waits between attempts end with
+// ctx.
+func (c *Client) do(ctx context.Context, r request) (*http.Response, error) {
+
+	for attempt := 0; ; attempt++ {
+		var requestBody io.ReadCloser
+		contentType := ``
+		if r.body != nil {
+			var err error
+			requestBody, contentType, err = r.body.open()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		httpRequest, err := http.NewRequestWithContext(ctx, r.method, c.baseURL+r.path, requestBody)
+		if err != nil {
+			if requestBody != nil {
+				requestBody.Close()
+			}
+			return nil, err
+		}
+		if contentType != `` {
+			httpRequest.Header.Set(`Content-Type`, contentType)
+		}
+		if c.APIKey != `` {
+			httpRequest.Header.Set(api.APIKeyHeader, c.APIKey)
+		}
+		if c.Token != `` {
+			httpRequest.Header.Set(`Authorization`, `Bearer `+c.Token)
+		}
+
+		// The transport only notices ctx between reads of the body, which
+		// may block for as long as the reader of an upload does.
+		stopClosing := func() bool { return false }
+		if requestBody != nil {
+			stopClosing = context.AfterFunc(ctx, func() { requestBody.Close() })
+		}
+		response, err := c.HTTPClient.Do(httpRequest)
+		stopClosing()
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode < http.StatusBadRequest {
+			return response, nil
+		}
+
+		apiErr := decodeError(response)
+		if !retryable(response.StatusCode) || attempt >= c.MaxRetries || (r.body != nil && !r.body.replayable()) {
+			return nil, apiErr
+		}
+
+		wait := apiErr.RetryAfter
+		if wait <= 0 {
+			wait = initialBackoff << attempt
+		}
+		if wait > c.MaxRetryWait {
+			return nil, apiErr
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// doJSON sends in as JSON, when not nil, and decodes the response into out,
+// when not nil.
+func (c *Client) doJSON(ctx context.Context, method string, path string, in any, out any) error {
+
+	r := request{method: method, path: path}
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		r.body = jsonBody(data)
+	}
+
+	response, err := c.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, response.Body)
+		return err
+	}
+
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// stream sends r and returns the response body for the caller to read.
+func (c *Client) stream(ctx context.Context, r request) (*Result, error) {
+
+	response, err := c.do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		ReadCloser:  response.Body,
+		ContentType: response.Header.Get(`Content-Type`),
+		Preset:      response.Header.Get(`X-Preset`),
+		RequestID:   response.Header.Get(`X-Request-ID`),
+	}, nil
+}
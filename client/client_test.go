@@ -0,0 +1,261 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/config"
+	"imageProcessorAPI/server"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const adminToken = `test-admin-token`
+
+// startServer serves server.New of the default configuration, on files of a
+// temporary directory and with change applied, until the test is done. It
+// returns the URL of the server.
+func startServer(t *testing.T, change func(cfg *config.Config)) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Auth.AdminToken = adminToken
+	cfg.Auth.APIKeysFile = filepath.Join(dir, `api_keys.json`)
+	cfg.PresetStoreFile = filepath.Join(dir, `preset_store.json`)
+	cfg.Quota.File = filepath.Join(dir, `quota_usage.json`)
+	if change != nil {
+		change(&cfg)
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.App.Listener(listener)
+	t.Cleanup(func() {
+		srv.App.Shutdown()
+		srv.Close()
+	})
+
+	return `http://` + listener.Addr().String()
+}
+
+// rateLimited serves requests without credentials and limits every caller to
+// one request per window.
+func rateLimited(window string) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Auth.Disabled = true
+		cfg.RateLimit.Policy = `1/` + window
+		cfg.RateLimit.Allowlist = nil
+	}
+}
+
+// newAPIKeyClient creates an API key with the admin API and returns a client
+// authenticating with it.
+func newAPIKeyClient(t *testing.T, baseURL string) *Client {
+	t.Helper()
+
+	admin := New(baseURL)
+	admin.Token = adminToken
+	created, err := admin.CreateAPIKey(context.Background(), api.CreateAPIKeyBody{Name: `test`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := New(baseURL)
+	client.APIKey = created.Key
+	return client
+}
+
+func encodePNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	encoded := &bytes.Buffer{}
+	err := png.Encode(encoded, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encoded.Bytes()
+}
+
+// onlyReader hides every method of its reader but Read, like pipes and
+// network streams that cannot be sent again.
+type onlyReader struct {
+	io.Reader
+}
+
+func TestRetriesRateLimitedRequestsAfterRetryAfter(t *testing.T) {
+
+	client := New(startServer(t, rateLimited(`1s`)))
+	ctx := context.Background()
+
+	_, err := client.Usage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second request is limited and sent again once the second passed.
+	start := time.Now()
+	_, err = client.Usage(ctx)
+	if err != nil {
+		t.Fatalf(`got %v, want the limited request retried`, err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf(`got a retry after %s, want it after the Retry-After of the response`, elapsed)
+	}
+
+	// Uploads that can be read again are sent whole again.
+	encoded := encodePNG(t, 8, 8)
+	result, err := client.GrayScale(ctx, Upload{Name: `photo.png`, Reader: bytes.NewReader(encoded)})
+	if err != nil {
+		t.Fatalf(`got %v, want the limited upload retried`, err)
+	}
+	defer result.Close()
+	_, err = png.Decode(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDoesNotRetryWhatCannotBeSentAgain(t *testing.T) {
+
+	client := New(startServer(t, rateLimited(`1s`)))
+	ctx := context.Background()
+
+	_, err := client.Usage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = client.GrayScale(ctx, Upload{Name: `photo.png`, Reader: onlyReader{bytes.NewReader(encodePNG(t, 8, 8))}})
+	if CodeOf(err) != api.RateLimited {
+		t.Fatalf(`got %v, want the limited upload of a plain reader returned`, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf(`got the error after %s, want it without waiting`, elapsed)
+	}
+
+	// Neither are requests asked to wait longer than MaxRetryWait, nor those
+	// that used up MaxRetries.
+	client.MaxRetryWait = 100 * time.Millisecond
+	_, err = client.Usage(ctx)
+	if CodeOf(err) != api.RateLimited {
+		t.Fatalf(`got %v, want the error of a wait past MaxRetryWait`, err)
+	}
+	client.MaxRetryWait, client.MaxRetries = DefaultMaxRetryWait, 0
+	_, err = client.Usage(ctx)
+	if CodeOf(err) != api.RateLimited {
+		t.Fatalf(`got %v, want the error once retries are used up`, err)
+	}
+}
+
+func TestRetriesUnavailableResponses(t *testing.T) {
+
+	// A proxy in front of the server answers the first attempts itself, like
+	// a load balancer without a healthy instance.
+	target, err := url.Parse(startServer(t, func(cfg *config.Config) { cfg.Auth.Disabled = true }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var attempts atomic.Int32
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= 2 {
+			w.Header().Set(`Retry-After`, `0`)
+			http.Error(w, `no healthy upstream`, http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer unavailable.Close()
+
+	client := New(unavailable.URL)
+	result, err := client.Process(context.Background(), Upload{Name: `photo.png`, Reader: bytes.NewReader(encodePNG(t, 8, 8))}, api.PipelineMetadata{
+		Operations: []api.Operation{{Name: `grayscale`}},
+	})
+	if err != nil {
+		t.Fatalf(`got %v, want the request retried until the server answered`, err)
+	}
+	result.Close()
+	if attempts.Load() != 3 {
+		t.Fatalf(`got %d attempts, want 3`, attempts.Load())
+	}
+
+	// Once retries are used up, the answer of the proxy is the error.
+	attempts.Store(0)
+	client.MaxRetries = 1
+	_, err = client.Usage(context.Background())
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusServiceUnavailable || apiErr.Detail != `no healthy upstream` || apiErr.Code != `` {
+		t.Fatalf(`got %v, want the 503 of the proxy with its body as detail`, err)
+	}
+}
+
+func TestContextCancelsRequests(t *testing.T) {
+
+	client := New(startServer(t, rateLimited(`1m`)))
+	_, err := client.Usage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Waits for a retry end with the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	client.MaxRetryWait = time.Hour
+	start := time.Now()
+	_, err = client.Usage(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf(`got %v, want the wait for a retry cancelled`, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf(`got the wait cancelled after %s, want it cancelled with the context`, elapsed)
+	}
+
+	// So do uploads still being streamed.
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	go writer.Write(encodePNG(t, 8, 8)[:32])
+	client = New(startServer(t, func(cfg *config.Config) { cfg.Auth.Disabled = true }))
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GrayScale(ctx, Upload{Name: `photo.png`, Reader: reader})
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err = <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf(`got %v, want the upload cancelled`, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`got the upload still running, want it cancelled with the context`)
+	}
+}
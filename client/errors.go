@@ -0,0 +1,90 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"imageProcessorAPI/api"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrorBody caps what is read of error responses.
+const maxErrorBody = 64 * 1024
+
+// Error is an error response, with the problem details the server answered
+// with. Clients should switch on Code, see CodeOf.
+type Error struct {
+	api.Problem
+	// RetryAfter is how long the server asked to wait before retrying, zero
+	// when it did not.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	message := strconv.Itoa(e.Status) + ` ` + e.Title
+	if e.Code != `` {
+		message += ` (` + string(e.Code) + `)`
+	}
+	if e.Detail != `` {
+		message += `: ` + e.Detail
+	}
+
+	return message
+}
+
+// CodeOf returns the code of the problem err is, or wraps, and an empty code
+// for other errors.
+func CodeOf(err error) api.Code {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+
+	return ``
+}
+
+// decodeError reads and closes the body of an error response. Responses that
+// are not problem details, like those of proxies, keep their status and have
+// their body as detail.
+func decodeError(response *http.Response) *Error {
+	defer response.Body.Close()
+
+	apiErr := &Error{RetryAfter: retryAfter(response.Header.Get(`Retry-After`))}
+	data, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get(`Content-Type`))
+	if mediaType == api.ProblemContentType && json.Unmarshal(data, &apiErr.Problem) == nil {
+		return apiErr
+	}
+
+	apiErr.Problem = api.Problem{
+		Status: response.StatusCode,
+		Title:  http.StatusText(response.StatusCode),
+		Detail: strings.TrimSpace(string(data)),
+	}
+	return apiErr
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(header string) time.Duration {
+
+	if header == `` {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(header)
+	if err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0
+	}
+
+	return time.Until(date)
+}
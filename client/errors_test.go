@@ -0,0 +1,59 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"imageProcessorAPI/api"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodesProblemDetails(t *testing.T) {
+
+	client := newAPIKeyClient(t, startServer(t, nil))
+	ctx := context.Background()
+
+	// The bounds are only known to be wrong once the image is decoded.
+	_, err := client.Crop(ctx, Upload{Name: `photo.png`, Reader: bytes.NewReader(encodePNG(t, 8, 8))}, api.CropMetaData{MinX: ptr(0), MinY: ptr(0), MaxX: ptr(20), MaxY: ptr(4)})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf(`got %v, want an *Error`, err)
+	}
+	if apiErr.Status != http.StatusBadRequest || apiErr.Code != api.InvalidBounds || apiErr.Type != api.ProblemTypePrefix+string(api.InvalidBounds) || apiErr.Instance != api.APIv2+`/process` || apiErr.RequestID == `` {
+		t.Fatalf(`got problem %+v, want the invalid_bounds problem of the request`, apiErr.Problem)
+	}
+	if len(apiErr.Errors) != 1 || apiErr.Errors[0].Field != `maxX` {
+		t.Fatalf(`got fields %+v, want maxX`, apiErr.Errors)
+	}
+	if !strings.Contains(err.Error(), `400 Invalid bounds (invalid_bounds)`) {
+		t.Fatalf(`got message %q, want the status, title and code in it`, err.Error())
+	}
+
+	// Callers without credentials are told how to authenticate.
+	_, err = New(client.baseURL).Usage(ctx)
+	if CodeOf(err) != api.Unauthenticated {
+		t.Fatalf(`got %v, want unauthenticated`, err)
+	}
+	if CodeOf(errors.New(`other`)) != `` {
+		t.Fatal(`got a code of an error that is no problem, want none`)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	if wait := retryAfter(`3`); wait != 3*time.Second {
+		t.Fatalf(`got %s for seconds, want 3s`, wait)
+	}
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if wait := retryAfter(date); wait < 58*time.Second || wait > time.Minute {
+		t.Fatalf(`got %s for a date a minute ahead, want about a minute`, wait)
+	}
+
+	if wait := retryAfter(`soon`); wait != 0 {
+		t.Fatalf(`got %s for an invalid header, want none`, wait)
+	}
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/openapi"
+	"net/http"
+	"net/url"
+)
+
+// Process runs an operation chain, or the preset pipeline references, on
+// image.
+func (c *Client) Process(ctx context.Context, image Upload, pipeline api.PipelineMetadata) (*Result, error) {
+
+	form, err := pipelineForm(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	form.addFile(`image`, image)
+
+	return c.stream(ctx, request{method: http.MethodPost, path: api.APIv2 + `/process`, body: form})
+}
+
+// ApplyPreset runs the preset reference, `name` or `name@version`, on image.
+func (c *Client) ApplyPreset(ctx context.Context, image Upload, reference string) (*Result, error) {
+	return c.Process(ctx, image, api.PipelineMetadata{Preset: reference})
+}
+
+// The single operations of v1 are sent as chains of one operation, which the
+// server answers the same way.
+
+func (c *Client) Resize(ctx context.Context, image Upload, metadata api.ResizeMetaData) (*Result, error) {
+	return c.processOne(ctx, image, `resize`, metadata)
+}
+
+func (c *Client) Crop(ctx context.Context, image Upload, metadata api.CropMetaData) (*Result, error) {
+	return c.processOne(ctx, image, `crop`, metadata)
+}
+
+func (c *Client) Rotate(ctx context.Context, image Upload, metadata api.RotateBody) (*Result, error) {
+	return c.processOne(ctx, image, `rotate`, metadata)
+}
+
+func (c *Client) Flip(ctx context.Context, image Upload, metadata api.FlipMetadata) (*Result, error) {
+	return c.processOne(ctx, image, `flip`, metadata)
+}
+
+func (c *Client) GrayScale(ctx context.Context, image Upload) (*Result, error) {
+	return c.processOne(ctx, image, `grayscale`, nil)
+}
+
+func (c *Client) ChangeFormat(ctx context.Context, image Upload, metadata api.ChangeFormatMetadata) (*Result, error) {
+	return c.processOne(ctx, image, `changeformat`, metadata)
+}
+
+func (c *Client) processOne(ctx context.Context, image Upload, name string, metadata any) (*Result, error) {
+
+	operation := api.Operation{Name: name}
+	if metadata != nil {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, err
+		}
+		operation.Metadata = data
+	}
+
+	return c.Process(ctx, image, api.PipelineMetadata{Operations: []api.Operation{operation}})
+}
+
+// Batch runs one pipeline on every image and returns a ZIP of the results
+// with a manifest.json, see api.BatchManifest.
+func (c *Client) Batch(ctx context.Context, images []Upload, pipeline api.PipelineMetadata) (*Result, error) {
+
+	form, err := pipelineForm(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		form.addFile(`image`, image)
+	}
+
+	return c.stream(ctx, request{method: http.MethodPost, path: api.APIv2 + `/batch`, body: form})
+}
+
+// BatchArchive runs one pipeline on every image of a ZIP archive.
+func (c *Client) BatchArchive(ctx context.Context, archive Upload, pipeline api.PipelineMetadata) (*Result, error) {
+
+	form, err := pipelineForm(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	form.addFile(`archive`, archive)
+
+	return c.stream(ctx, request{method: http.MethodPost, path: api.APIv2 + `/batch`, body: form})
+}
+
+func pipelineForm(pipeline api.PipelineMetadata) (*multipartBody, error) {
+
+	data, err := json.Marshal(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	form := &multipartBody{}
+	form.addField(`metadata`, string(data))
+	return form, nil
+}
+
+// Responsive returns a ZIP of the widths and formats metadata asks for, with
+// their manifest.
+func (c *Client) Responsive(ctx context.Context, image Upload, metadata api.ResponsiveMetaData) (*Result, error) {
+
+	metadata.Store = false
+	form, err := responsiveForm(image, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.stream(ctx, request{method: http.MethodPost, path: api.APIv2 + `/responsive`, body: form})
+}
+
+// StoreResponsive has the server store the set metadata asks for, and returns
+// its manifest.
+func (c *Client) StoreResponsive(ctx context.Context, image Upload, metadata api.ResponsiveMetaData) (api.ResponsiveManifest, error) {
+
+	metadata.Store = true
+	form, err := responsiveForm(image, metadata)
+	if err != nil {
+		return api.ResponsiveManifest{}, err
+	}
+
+	response, err := c.do(ctx, request{method: http.MethodPost, path: api.APIv2 + `/responsive`, body: form})
+	if err != nil {
+		return api.ResponsiveManifest{}, err
+	}
+	defer response.Body.Close()
+
+	manifest := api.ResponsiveManifest{}
+	err = json.NewDecoder(response.Body).Decode(&manifest)
+	return manifest, err
+}
+
+func responsiveForm(image Upload, metadata api.ResponsiveMetaData) (*multipartBody, error) {
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	form := &multipartBody{}
+	form.addField(`metadata`, string(data))
+	form.addFile(`image`, image)
+	return form, nil
+}
+
+// Usage reports the caller's usage against its quota.
+func (c *Client) Usage(ctx context.Context) (api.UsageResponse, error) {
+	usage := api.UsageResponse{}
+	err := c.doJSON(ctx, http.MethodGet, api.APIv2+`/usage`, nil, &usage)
+	return usage, err
+}
+
+// Problems lists the codes errors are answered with.
+func (c *Client) Problems(ctx context.Context) ([]api.Definition, error) {
+	var definitions []api.Definition
+	err := c.doJSON(ctx, http.MethodGet, `/problems`, nil, &definitions)
+	return definitions, err
+}
+
+func (c *Client) OpenAPI(ctx context.Context) (openapi.Document, error) {
+	document := openapi.Document{}
+	err := c.doJSON(ctx, http.MethodGet, `/openapi.json`, nil, &document)
+	return document, err
+}
+
+// The admin routes take the admin token as Token.
+
+func (c *Client) ListPresets(ctx context.Context) ([]api.Preset, error) {
+	var presets []api.Preset
+	err := c.doJSON(ctx, http.MethodGet, `/admin/presets`, nil, &presets)
+	return presets, err
+}
+
+// GetPreset returns every version of the preset name, oldest first.
+func (c *Client) GetPreset(ctx context.Context, name string) ([]api.Preset, error) {
+	var history []api.Preset
+	err := c.doJSON(ctx, http.MethodGet, `/admin/presets/`+url.PathEscape(name), nil, &history)
+	return history, err
+}
+
+// PutPreset stores preset under its name and returns the version stored.
+func (c *Client) PutPreset(ctx context.Context, preset api.Preset) (api.Preset, error) {
+	stored := api.Preset{}
+	err := c.doJSON(ctx, http.MethodPut, `/admin/presets/`+url.PathEscape(preset.Name), preset, &stored)
+	return stored, err
+}
+
+func (c *Client) DeletePreset(ctx context.Context, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, `/admin/presets/`+url.PathEscape(name), nil, nil)
+}
+
+func (c *Client) ListAPIKeys(ctx context.Context) ([]api.APIKeyResponse, error) {
+	var keys []api.APIKeyResponse
+	err := c.doJSON(ctx, http.MethodGet, `/admin/keys`, nil, &keys)
+	return keys, err
+}
+
+// CreateAPIKey returns the key with its plain value, the only time it is
+// shown.
+func (c *Client) CreateAPIKey(ctx context.Context, key api.CreateAPIKeyBody) (api.APIKeyResponse, error) {
+	created := api.APIKeyResponse{}
+	err := c.doJSON(ctx, http.MethodPost, `/admin/keys`, key, &created)
+	return created, err
+}
+
+func (c *Client) RevokeAPIKey(ctx context.Context, id string) (api.APIKeyResponse, error) {
+	revoked := api.APIKeyResponse{}
+	err := c.doJSON(ctx, http.MethodDelete, `/admin/keys/`+url.PathEscape(id), nil, &revoked)
+	return revoked, err
+}
@@ -0,0 +1,326 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	_ "image/jpeg"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/config"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+// decodeResult reads and closes result, and returns the size and format of
+// its image.
+func decodeResult(t *testing.T, result *Result, err error) (image.Config, string) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+
+	config, format, err := image.DecodeConfig(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config, format
+}
+
+// readArchive reads and closes result, a ZIP, and returns its files by name.
+func readArchive(t *testing.T, result *Result, err error) map[string][]byte {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+
+	data, err := io.ReadAll(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name], err = io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return files
+}
+
+func TestImageRoutes(t *testing.T) {
+
+	client := newAPIKeyClient(t, startServer(t, nil))
+	ctx := context.Background()
+	encoded := encodePNG(t, 40, 20)
+	upload := func() Upload {
+		return Upload{Name: `photo.png`, Reader: bytes.NewReader(encoded)}
+	}
+
+	routes := []struct {
+		name   string
+		call   func() (*Result, error)
+		width  int
+		height int
+		format string
+	}{
+		{`process`, func() (*Result, error) {
+			return client.Process(ctx, upload(), api.PipelineMetadata{
+				Operations: []api.Operation{{Name: `resize`, Metadata: json.RawMessage(`{"width":10}`)}, {Name: `grayscale`}},
+				Output:     api.OutputOptions{Format: `jpeg`},
+			})
+		}, 10, 5, `jpeg`},
+		{`resize`, func() (*Result, error) {
+			return client.Resize(ctx, upload(), api.ResizeMetaData{Height: ptr(10)})
+		}, 20, 10, `png`},
+		{`crop`, func() (*Result, error) {
+			return client.Crop(ctx, upload(), api.CropMetaData{MinX: ptr(5), MinY: ptr(0), MaxX: ptr(15), MaxY: ptr(5)})
+		}, 10, 5, `png`},
+		{`rotate`, func() (*Result, error) {
+			return client.Rotate(ctx, upload(), api.RotateBody{Angle: ptr(90)})
+		}, 20, 40, `png`},
+		{`flip`, func() (*Result, error) {
+			return client.Flip(ctx, upload(), api.FlipMetadata{Direction: ptr(`horizontal`)})
+		}, 40, 20, `png`},
+		{`grayscale`, func() (*Result, error) {
+			return client.GrayScale(ctx, upload())
+		}, 40, 20, `png`},
+		{`changeformat`, func() (*Result, error) {
+			return client.ChangeFormat(ctx, upload(), api.ChangeFormatMetadata{FormatName: ptr(`jpeg`)})
+		}, 40, 20, `jpeg`},
+	}
+	for _, route := range routes {
+		result, err := route.call()
+		config, format := decodeResult(t, result, err)
+		if config.Width != route.width || config.Height != route.height || format != route.format {
+			t.Fatalf(`got a %dx%d %s result from %s, want a %dx%d %s`, config.Width, config.Height, format, route.name, route.width, route.height, route.format)
+		}
+	}
+}
+
+func TestPresetRoutes(t *testing.T) {
+
+	baseURL := startServer(t, nil)
+	client := newAPIKeyClient(t, baseURL)
+	admin := New(baseURL)
+	admin.Token = adminToken
+	ctx := context.Background()
+
+	thumbnail := api.Preset{Name: `thumbnail`, Operations: []api.Operation{{Name: `resize`, Metadata: json.RawMessage(`{"width":8}`)}}}
+	stored, err := admin.PutPreset(ctx, thumbnail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbnail.Operations = append(thumbnail.Operations, api.Operation{Name: `grayscale`})
+	changed, err := admin.PutPreset(ctx, thumbnail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != 1 || changed.Version != 2 {
+		t.Fatalf(`got versions %d and %d, want 1 and 2`, stored.Version, changed.Version)
+	}
+
+	presets, err := admin.ListPresets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := admin.GetPreset(ctx, `thumbnail`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(presets) != 1 || presets[0].Version != 2 || len(history) != 2 || history[0].Reference() != `thumbnail@1` {
+		t.Fatalf(`got presets %+v and history %+v, want the latest version listed and both in the history`, presets, history)
+	}
+
+	// A pinned version runs as it was stored.
+	result, err := client.ApplyPreset(ctx, Upload{Name: `photo.png`, Reader: bytes.NewReader(encodePNG(t, 16, 16))}, `thumbnail@1`)
+	preset := ``
+	if result != nil {
+		preset = result.Preset
+	}
+	config, _ := decodeResult(t, result, err)
+	if config.Width != 8 || preset != `thumbnail@1` {
+		t.Fatalf(`got a %d wide result of preset %q, want 8 wide of thumbnail@1`, config.Width, preset)
+	}
+
+	err = admin.DeletePreset(ctx, `thumbnail`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = admin.GetPreset(ctx, `thumbnail`)
+	if CodeOf(err) != api.PresetNotFound {
+		t.Fatalf(`got %v, want the deleted preset not found`, err)
+	}
+}
+
+func TestBatchRoutes(t *testing.T) {
+
+	client := newAPIKeyClient(t, startServer(t, nil))
+	ctx := context.Background()
+	pipeline := api.PipelineMetadata{Operations: []api.Operation{{Name: `grayscale`}}}
+
+	images := []Upload{
+		{Name: `a.png`, Reader: bytes.NewReader(encodePNG(t, 8, 8))},
+		{Name: `b.png`, Reader: bytes.NewReader(encodePNG(t, 4, 4))},
+	}
+	result, err := client.Batch(ctx, images, pipeline)
+	files := readArchive(t, result, err)
+	checkBatchManifest(t, files, `a.png`, `b.png`)
+
+	archived := &bytes.Buffer{}
+	writer := zip.NewWriter(archived)
+	for _, name := range []string{`c.png`, `d.png`} {
+		file, err := writer.Create(name)
+		if err == nil {
+			_, err = file.Write(encodePNG(t, 6, 6))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = client.BatchArchive(ctx, Upload{Name: `images.zip`, Reader: bytes.NewReader(archived.Bytes())}, pipeline)
+	files = readArchive(t, result, err)
+	checkBatchManifest(t, files, `c.png`, `d.png`)
+}
+
+// checkBatchManifest fails t unless every input named succeeded and its
+// output is in files.
+func checkBatchManifest(t *testing.T, files map[string][]byte, names ...string) {
+	t.Helper()
+
+	manifest := api.BatchManifest{}
+	err := json.Unmarshal(files[`manifest.json`], &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != len(names) {
+		t.Fatalf(`got manifest %+v, want %v`, manifest, names)
+	}
+	for i, entry := range manifest.Files {
+		if entry.Name != names[i] || !entry.Success || files[entry.Output] == nil {
+			t.Fatalf(`got entry %+v, want %s processed`, entry, names[i])
+		}
+	}
+}
+
+func TestResponsiveRoutes(t *testing.T) {
+
+	baseURL := startServer(t, func(cfg *config.Config) { cfg.ResponsiveStorageDir = t.TempDir() })
+	client := newAPIKeyClient(t, baseURL)
+	ctx := context.Background()
+	metadata := api.ResponsiveMetaData{Widths: []int{16, 32}, Formats: []string{`png`}}
+
+	result, err := client.Responsive(ctx, Upload{Name: `photo.png`, Reader: bytes.NewReader(encodePNG(t, 64, 32))}, metadata)
+	files := readArchive(t, result, err)
+	manifest := api.ResponsiveManifest{}
+	err = json.Unmarshal(files[`manifest.json`], &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Images) != 2 || files[manifest.Images[0].File] == nil {
+		t.Fatalf(`got manifest %+v, want both widths in the archive`, manifest)
+	}
+
+	// Stored sets are served by the server.
+	stored, err := client.StoreResponsive(ctx, Upload{Name: `photo.png`, Reader: bytes.NewReader(encodePNG(t, 64, 32))}, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Images) != 2 {
+		t.Fatalf(`got manifest %+v, want both widths stored`, stored)
+	}
+	response, err := http.Get(baseURL + stored.Images[1].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _, err := image.DecodeConfig(response.Body)
+	response.Body.Close()
+	if err != nil || config.Width != 32 {
+		t.Fatalf(`got %v and a %d wide image at %s, want the stored 32 wide one`, err, config.Width, stored.Images[1].URL)
+	}
+}
+
+func TestAccountRoutes(t *testing.T) {
+
+	baseURL := startServer(t, nil)
+	client := newAPIKeyClient(t, baseURL)
+	admin := New(baseURL)
+	admin.Token = adminToken
+	ctx := context.Background()
+
+	_, err := client.GrayScale(ctx, Upload{Name: `photo.png`, Reader: bytes.NewReader(encodePNG(t, 8, 8))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := client.Usage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Daily.Used.Requests != 1 || usage.Monthly.Used.Requests != 1 {
+		t.Fatalf(`got usage %+v, want the one request counted`, usage)
+	}
+
+	definitions, err := client.Problems(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(definitions, func(definition api.Definition) bool {
+		return definition.Code == api.RateLimited && definition.Status == http.StatusTooManyRequests
+	}) {
+		t.Fatalf(`got definitions %+v, want rate_limited among them`, definitions)
+	}
+
+	document, err := client.OpenAPI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := document.Paths[api.APIv2+`/process`]; !ok {
+		t.Fatalf(`got paths %v, want %s/process documented`, document.Paths, api.APIv2)
+	}
+
+	keys, err := admin.ListAPIKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Hash != `` || keys[0].Key != `` {
+		t.Fatalf(`got keys %+v, want the one key without secrets`, keys)
+	}
+	revoked, err := admin.RevokeAPIKey(ctx, keys[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked.RevokedAt == nil {
+		t.Fatalf(`got key %+v, want it revoked`, revoked)
+	}
+	_, err = client.Usage(ctx)
+	if CodeOf(err) != api.InvalidCredentials {
+		t.Fatalf(`got %v, want the revoked key rejected`, err)
+	}
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"path"
+	"strings"
+)
+
+// quoteEscaper escapes names in Content-Disposition, like multipart does.
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// Upload is a file sent to the server, streamed from Reader. Name tells the
+// server the format of images by its extension, and the content type when
+// ContentType is empty. Requests with uploads are only retried when every
+// Reader is also an io.Seeker, like files, so it can be sent again from where
+// it started.
+type Upload struct {
+	Name        string
+	Reader      io.Reader
+	ContentType string
+}
+
+func (u Upload) contentType() string {
+	if u.ContentType != `` {
+		return u.ContentType
+	}
+
+	contentType := mime.TypeByExtension(strings.ToLower(path.Ext(u.Name)))
+	if contentType == `` {
+		return `application/octet-stream`
+	}
+
+	return contentType
+}
+
+type formField struct {
+	name  string
+	value string
+}
+
+type formFile struct {
+	field  string
+	upload Upload
+}
+
+// multipartBody streams a form, without holding its files in memory.
+type multipartBody struct {
+	fields []formField
+	files  []formFile
+
+	// offsets are where the readers of files started, once opened.
+	offsets []int64
+	// previous is the pipe of the last attempt, and done is closed once its
+	// writer stopped.
+	previous *io.PipeReader
+	done     chan struct{}
+}
+
+func (b *multipartBody) addField(name string, value string) {
+	if value != `` {
+		b.fields = append(b.fields, formField{name: name, value: value})
+	}
+}
+
+func (b *multipartBody) addFile(field string, upload Upload) {
+	b.files = append(b.files, formFile{field: field, upload: upload})
+}
+
+func (b *multipartBody) replayable() bool {
+	for _, file := range b.files {
+		if _, ok := file.upload.Reader.(io.Seeker); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *multipartBody) open() (io.ReadCloser, string, error) {
+
+	err := b.rewind()
+	if err != nil {
+		return nil, ``, err
+	}
+
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	done := make(chan struct{})
+	b.previous, b.done = reader, done
+
+	go func() {
+		defer close(done)
+		writer.CloseWithError(b.write(form))
+	}()
+
+	return reader, form.FormDataContentType(), nil
+}
+
+// rewind stops the writer of the previous attempt and seeks the readers back
+// to where they started, or records where they start on the first attempt.
+func (b *multipartBody) rewind() error {
+
+	if b.previous != nil {
+		b.previous.CloseWithError(io.ErrClosedPipe)
+		<-b.done
+	}
+
+	first := b.offsets == nil
+	if first {
+		b.offsets = make([]int64, len(b.files))
+	}
+	for i, file := range b.files {
+		seeker, ok := file.upload.Reader.(io.Seeker)
+		if !ok {
+			continue
+		}
+
+		var err error
+		if first {
+			b.offsets[i], err = seeker.Seek(0, io.SeekCurrent)
+		} else {
+			_, err = seeker.Seek(b.offsets[i], io.SeekStart)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *multipartBody) write(form *multipart.Writer) error {
+
+	for _, field := range b.fields {
+		err := form.WriteField(field.name, field.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, file := range b.files {
+		header := textproto.MIMEHeader{}
+		header.Set(`Content-Disposition`, `form-data; name="`+quoteEscaper.Replace(file.field)+`"; filename="`+quoteEscaper.Replace(file.upload.Name)+`"`)
+		header.Set(`Content-Type`, file.upload.contentType())
+		part, err := form.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file.upload.Reader)
+		if err != nil {
+			return err
+		}
+	}
+
+	return form.Close()
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signalReader closes read once it was first read from.
+type signalReader struct {
+	io.ReadCloser
+	once sync.Once
+	read chan struct{}
+}
+
+func (r *signalReader) Read(p []byte) (int, error) {
+	r.once.Do(func() { close(r.read) })
+	return r.ReadCloser.Read(p)
+}
+
+func TestUploadsAreStreamed(t *testing.T) {
+
+	target, err := url.Parse(startServer(t, func(cfg *config.Config) { cfg.Auth.Disabled = true }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	received := make(chan struct{})
+	var contentLength int64
+	observer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		r.Body = &signalReader{ReadCloser: r.Body, read: received}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer observer.Close()
+
+	// The second half of the image is only written once the server got the
+	// first, which a client reading the whole upload first would wait for
+	// forever.
+	encoded := encodePNG(t, 64, 64)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.Write(encoded[:len(encoded)/2])
+		select {
+		case <-received:
+			writer.Write(encoded[len(encoded)/2:])
+			writer.Close()
+		case <-time.After(5 * time.Second):
+			writer.CloseWithError(io.ErrUnexpectedEOF)
+		}
+	}()
+
+	result, err := New(observer.URL).Process(context.Background(), Upload{Name: `photo.png`, Reader: reader}, api.PipelineMetadata{
+		Operations: []api.Operation{{Name: `flip`, Metadata: []byte(`{"direction":"vertical"}`)}},
+	})
+	if err != nil {
+		t.Fatalf(`got %v, want the upload streamed`, err)
+	}
+	defer result.Close()
+	if contentLength != -1 {
+		t.Fatalf(`got a content length of %d, want the upload sent without knowing it`, contentLength)
+	}
+	img, err := png.Decode(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 64 || result.ContentType != `image/png` || result.RequestID == `` {
+		t.Fatalf(`got a %d wide %s result of request %q, want the 64 wide PNG`, img.Bounds().Dx(), result.ContentType, result.RequestID)
+	}
+}
+
+func TestUploadContentType(t *testing.T) {
+
+	uploads := []struct {
+		upload Upload
+		want   string
+	}{
+		{Upload{Name: `photo.PNG`}, `image/png`},
+		{Upload{Name: `dir/photo.jpg`}, `image/jpeg`},
+		{Upload{Name: `photo.png`, ContentType: `image/jpeg`}, `image/jpeg`},
+		{Upload{Name: `photo`}, `application/octet-stream`},
+	}
+	for _, upload := range uploads {
+		if contentType := upload.upload.contentType(); contentType != upload.want {
+			t.Fatalf(`got %s for %+v, want %s`, contentType, upload.upload, upload.want)
+		}
+	}
+
+	// Names are escaped within the header of their part.
+	form := &multipartBody{}
+	form.addFile(`image`, Upload{Name: `a "quoted" name.png`, Reader: bytes.NewReader(nil)})
+	body, _, err := form.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`filename="a \"quoted\" name.png"`)) {
+		t.Fatalf(`got form %q, want the name escaped`, data)
+	}
+}
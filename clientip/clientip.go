@@ -0,0 +1,104 @@
+package clientip
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const LocalsKey = `clientIP`
+
+// Resolver finds the address of the client behind trusted reverse proxies.
+type Resolver struct {
+	trusted PrefixList
+}
+
+// NewResolver trusts the forwarding headers set by the proxies in trusted.
+// Without trusted proxies the headers are ignored, as anyone can set them.
+func NewResolver(trusted PrefixList) *Resolver {
+	return &Resolver{trusted: trusted}
+}
+
+// Resolve walks the forwarding chain from the peer towards the client and
+// returns the first address that is not a trusted proxy. The Forwarded header
+// is preferred over X-Forwarded-For when a request has both.
+func (r *Resolver) Resolve(c *fiber.Ctx) netip.Addr {
+
+	client, ok := netip.AddrFromSlice(c.Context().RemoteIP())
+	if !ok {
+		return netip.Addr{}
+	}
+	client = client.Unmap()
+
+	if !r.trusted.Contains(client) {
+		return client
+	}
+
+	var chain []string
+	if forwarded := c.Get(fiber.HeaderForwarded); forwarded != `` {
+		chain = forwardedFor(forwarded)
+	} else {
+		chain = strings.Split(c.Get(fiber.HeaderXForwardedFor), `,`)
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseNode(chain[i])
+		if !ok {
+			// Anything left of an entry that cannot be read is not trusted.
+			return client
+		}
+
+		client = addr
+		if !r.trusted.Contains(client) {
+			return client
+		}
+	}
+
+	return client
+}
+
+// FromContext returns the address resolved for the request, or the peer
+// address when no resolver ran.
+func FromContext(c *fiber.Ctx) string {
+	if addr, ok := c.Locals(LocalsKey).(netip.Addr); ok && addr.IsValid() {
+		return addr.String()
+	}
+
+	return c.IP()
+}
+
+// forwardedFor returns the for= parameters of an RFC 7239 Forwarded header.
+func forwardedFor(header string) []string {
+
+	var nodes []string
+	for _, element := range strings.Split(header, `,`) {
+		node := ``
+		for _, pair := range strings.Split(element, `;`) {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), `=`)
+			if ok && strings.EqualFold(key, `for`) {
+				node = strings.Trim(value, `"`)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// parseNode reads an address that may carry a port, with IPv6 addresses in
+// brackets. Obfuscated and unknown nodes are rejected.
+func parseNode(node string) (netip.Addr, bool) {
+
+	node = strings.TrimSpace(node)
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(node, `[`), `]`))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
@@ -0,0 +1,51 @@
+package clientip
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// PrefixList is a list of networks, as configured by comma separated CIDRs.
+type PrefixList []netip.Prefix
+
+// ParsePrefixes reads CIDRs. Plain addresses are taken as networks of that
+// single address.
+func ParsePrefixes(entries []string) (PrefixList, error) {
+
+	var prefixes PrefixList
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == `` {
+			continue
+		}
+
+		if !strings.Contains(entry, `/`) {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func (l PrefixList) Contains(addr netip.Addr) bool {
+
+	addr = addr.Unmap()
+	for _, prefix := range l {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import "strconv"
+
+// intFlag and stringFlag leave the pointer they set nil until the flag is
+// given, so the rules of the metadata tell which flags are required, as they
+// tell which fields are.
+
+type intFlag struct {
+	target **int
+}
+
+func (f intFlag) String() string {
+	if f.target == nil || *f.target == nil {
+		return ``
+	}
+
+	return strconv.Itoa(**f.target)
+}
+
+func (f intFlag) Set(value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+
+	*f.target = &parsed
+	return nil
+}
+
+type stringFlag struct {
+	target **string
+}
+
+func (f stringFlag) String() string {
+	if f.target == nil || *f.target == nil {
+		return ``
+	}
+
+	return **f.target
+}
+
+func (f stringFlag) Set(value string) error {
+	*f.target = &value
+	return nil
+}
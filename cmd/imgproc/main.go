@@ -0,0 +1,200 @@
+// Command imgproc runs the operations of the image processor on local files,
+// with the code the API runs them with, and serves the API.
+//
+//	imgproc resize -width 800 -o thumbs photos/*.jpg
+//	imgproc pipeline -metadata '{"operations":[{"name":"grayscale"}]}' < in.png > out.png
+//	imgproc serve -listen :8000
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"imageProcessorAPI/handlers"
+	"imageProcessorAPI/server"
+	"imageProcessorAPI/validation"
+	"os"
+)
+
+// command is a subcommand running an operation chain.
+type command struct {
+	name    string
+	summary string
+	// define adds the flags of the command and returns what builds its
+	// pipeline once they are parsed.
+	define func(flags *flag.FlagSet) func() (handlers.PipelineMetadata, error)
+}
+
+var commands = []command{
+	{`resize`, `Resize images, keeping the aspect ratio when only one of -width and -height is set.`, defineResize},
+	{`crop`, `Keep the rectangle from -minX, -minY included to -maxX, -maxY excluded.`, defineCrop},
+	{`rotate`, `Rotate images counterclockwise by -angle degrees.`, defineRotate},
+	{`flip`, `Flip images in -direction, horizontal or vertical.`, defineFlip},
+	{`grayscale`, `Turn images to grayscale.`, defineGrayScale},
+	{`convert`, `Convert images to -formatName, png or jpeg.`, defineConvert},
+	{`pipeline`, `Run an operation chain or a preset, like POST /v2/process.`, definePipeline},
+}
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case `serve`:
+		server.Run(args)
+		return
+	case `help`, `-h`, `-help`, `--help`:
+		usage()
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(run(cmd, args))
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, `imgproc: unknown command `+name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: imgproc <command> [flags] [inputs]`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `Inputs are files or glob patterns, standard input when there are none or one is -.`)
+	fmt.Fprintln(os.Stderr, `Commands:`)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "  %-10s %s\n", `serve`, `Serve the HTTP API, with the flags of the server.`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `Run imgproc <command> -h for the flags of a command.`)
+}
+
+func defineResize(flags *flag.FlagSet) func() (handlers.PipelineMetadata, error) {
+	metadata := &handlers.ResizeMetaData{}
+	flags.Var(intFlag{&metadata.Width}, `width`, `width in pixels`)
+	flags.Var(intFlag{&metadata.Height}, `height`, `height in pixels`)
+	return operation(`resize`, metadata)
+}
+
+func defineCrop(flags *flag.FlagSet) func() (handlers.PipelineMetadata, error) {
+	metadata := &handlers.CropMetaData{}
+	flags.Var(intFlag{&metadata.MinX}, `minX`, `left edge, included`)
+	flags.Var(intFlag{&metadata.MinY}, `minY`, `top edge, included`)
+	flags.Var(intFlag{&metadata.MaxX}, `maxX`, `right edge, excluded`)
+	flags.Var(intFlag{&metadata.MaxY}, `maxY`, `bottom edge, excluded`)
+	return operation(`crop`, metadata)
+}
+
+func defineRotate(flags *flag.FlagSet) func() (handlers.PipelineMetadata, error) {
+	metadata := &handlers.RotateBody{}
+	flags.Var(intFlag{&metadata.Angle}, `angle`, `angle in degrees, counterclockwise`)
+	return operation(`rotate`, metadata)
+}
+
+func defineFlip(flags *flag.FlagSet) func() (handlers.PipelineMetadata, error) {
+	metadata := &handlers.FlipMetadata{}
+	flags.Var(stringFlag{&metadata.Direction}, `direction`, `horizontal or vertical`)
+	return operation(`flip`, metadata)
+}
+
+func defineGrayScale(flags *flag.FlagSet) func() (handlers.PipelineMetadata, error) {
+	return operation(`grayscale`, nil)
+}
+
+func defineConvert(flags *flag.FlagSet) func() (handlers.PipelineMetadata, error) {
+	metadata := &handlers.ChangeFormatMetadata{}
+	flags.Var(stringFlag{&metadata.FormatName}, `formatName`, `png or jpeg`)
+	return operation(`changeformat`, metadata)
+}
+
+// operation builds a chain of the one operation name, with the metadata its
+// flags were parsed into.
+func operation(name string, metadata any) func() (handlers.PipelineMetadata, error) {
+	return func() (handlers.PipelineMetadata, error) {
+
+		operation := handlers.Operation{Name: name}
+		if metadata != nil {
+			data, err := json.Marshal(metadata)
+			if err != nil {
+				return handlers.PipelineMetadata{}, err
+			}
+			operation.Metadata = data
+		}
+
+		return handlers.PipelineMetadata{Operations: []handlers.Operation{operation}}, nil
+	}
+}
+
+func definePipeline(flags *flag.FlagSet) func() (handlers.PipelineMetadata, error) {
+
+	metadata := flags.String(`metadata`, ``, `operations and output options, or a preset, as JSON like the metadata of POST /v2/process`)
+	metadataFile := flags.String(`metadata-file`, ``, `file to read -metadata from`)
+	preset := flags.String(`preset`, ``, `preset to run, name or name@version, from -presets`)
+	presetsFile := flags.String(`presets`, ``, `JSON file of presets, like the presets file of the server`)
+
+	return func() (handlers.PipelineMetadata, error) {
+
+		data := []byte(*metadata)
+		if *metadataFile != `` {
+			var err error
+			data, err = os.ReadFile(*metadataFile)
+			if err != nil {
+				return handlers.PipelineMetadata{}, err
+			}
+		}
+
+		// Broken rules of the metadata and of its chain are reported
+		// together, like the server does. Decoding checked the output
+		// options already.
+		pipeline := handlers.PipelineMetadata{}
+		if len(data) > 0 {
+			err := validation.Decode(data, &pipeline)
+			var fields validation.Errors
+			if errors.As(err, &fields) {
+				chainFields := handlers.ValidatePipeline(handlers.PipelineMetadata{Operations: pipeline.Operations})
+				return handlers.PipelineMetadata{}, append(fields, chainFields...)
+			}
+			if err != nil {
+				return handlers.PipelineMetadata{}, errors.New(`Invalid metadata: ` + err.Error())
+			}
+		}
+		if *preset != `` {
+			pipeline.Preset = *preset
+		}
+		if pipeline.Preset == `` {
+			if len(pipeline.Operations) == 0 {
+				return handlers.PipelineMetadata{}, errors.New(`Must set operations with -metadata or -metadata-file, or a -preset.`)
+			}
+			return pipeline, nil
+		}
+
+		if *presetsFile == `` {
+			return handlers.PipelineMetadata{}, errors.New(`Must set -presets to run a preset.`)
+		}
+		presets, err := handlers.LoadPresetsFile(*presetsFile)
+		if err != nil {
+			return handlers.PipelineMetadata{}, errors.New(`Could not load presets. Error: ` + err.Error())
+		}
+		// Without a file to load from, the store cannot fail.
+		store, _ := handlers.NewPresetStore(``)
+		err = store.PutFile(presets)
+		if err != nil {
+			return handlers.PipelineMetadata{}, err
+		}
+
+		resolved, err := store.Resolve(pipeline.Preset)
+		if err != nil {
+			return handlers.PipelineMetadata{}, err
+		}
+
+		return handlers.PipelineMetadata{Preset: resolved.Reference(), Operations: resolved.Operations, Output: resolved.Output}, nil
+	}
+}
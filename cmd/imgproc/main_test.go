@@ -0,0 +1,116 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func runCommand(t *testing.T, name string, args ...string) int {
+	t.Helper()
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return run(cmd, args)
+		}
+	}
+
+	t.Fatalf(`got no command %s`, name)
+	return 0
+}
+
+func TestResizeWritesResult(t *testing.T) {
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, `photo.png`)
+	err := imaging.Save(imaging.New(40, 20, color.White), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, `small.png`)
+	code := runCommand(t, `resize`, `-width`, `10`, `-o`, output, input)
+	if code != 0 {
+		t.Fatalf(`got exit code %d, want 0`, code)
+	}
+
+	// The aspect ratio is kept, the format is the one of the input.
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 10 || config.Height != 5 || format != `png` {
+		t.Fatalf(`got a %dx%d %s result, want a 10x5 png`, config.Width, config.Height, format)
+	}
+}
+
+func TestPipelineWritesEveryInputToDirectory(t *testing.T) {
+
+	dir := t.TempDir()
+	for _, name := range []string{`a.png`, `b.png`} {
+		err := imaging.Save(imaging.New(8, 8, color.White), filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := filepath.Join(dir, `out`)
+	code := runCommand(t, `pipeline`, `-metadata`, `{"operations":[{"name":"grayscale"}],"output":{"format":"jpeg"}}`, `-o`, output, filepath.Join(dir, `*.png`))
+	if code != 0 {
+		t.Fatalf(`got exit code %d, want 0`, code)
+	}
+
+	for _, name := range []string{`a.jpg`, `b.jpg`} {
+		_, err := os.Stat(filepath.Join(output, name))
+		if err != nil {
+			t.Fatalf(`got no %s: %v`, name, err)
+		}
+	}
+}
+
+func TestInvalidUsageExitsWithoutWriting(t *testing.T) {
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, `photo.png`)
+	err := imaging.Save(imaging.New(4, 4, color.White), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, `result.png`)
+
+	invalid := [][]string{
+		{`resize`, `-width`, `-3`, `-o`, output, input},
+		{`pipeline`, `-metadata`, `{"operations":[{"name":"blur"}]}`, `-o`, output, input},
+		{`rotate`, `-angle`, `90`, `-o`, output, filepath.Join(dir, `missing-*.png`)},
+	}
+	for _, args := range invalid {
+		code := runCommand(t, args[0], args[1:]...)
+		if code != 2 {
+			t.Fatalf(`got exit code %d for %v, want 2`, code, args)
+		}
+	}
+
+	// Images that fail are reported with exit code 1.
+	broken := filepath.Join(dir, `broken.png`)
+	err = os.WriteFile(broken, []byte(`not an image`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := runCommand(t, `grayscale`, `-o`, output, broken); code != 1 {
+		t.Fatalf(`got exit code %d for a broken image, want 1`, code)
+	}
+
+	_, err = os.Stat(output)
+	if !os.IsNotExist(err) {
+		t.Fatalf(`got %s written, want nothing written`, output)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"imageProcessorAPI/handlers"
+	"imageProcessorAPI/validation"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// stdio names standard input as an input and standard output as the output.
+const stdio = `-`
+
+// run parses the flags of cmd, runs its pipeline on every input and returns
+// the exit code, 2 for invalid usage and 1 when an input failed.
+func run(cmd command, args []string) int {
+
+	flags := flag.NewFlagSet(`imgproc `+cmd.name, flag.ContinueOnError)
+	build := cmd.define(flags)
+	output := flags.String(`o`, ``, `file to write the result to, or directory for several inputs; standard output when empty or -`)
+	workers := flags.Int(`j`, runtime.NumCPU(), `images processed in parallel`)
+	quality := flags.Int(`quality`, 0, `JPEG quality, from 1 to 100`)
+	timeout := flags.Duration(`timeout`, handlers.CurrentSettings().ProcessingTimeout, `time allowed to process one image`)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: imgproc %s [flags] [inputs]\n\n%s\n\n", cmd.name, cmd.summary)
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+
+	pipeline, err := build()
+	if err == nil {
+		if *quality != 0 {
+			pipeline.Output.Quality = *quality
+		}
+		if fields := handlers.ValidatePipeline(pipeline); len(fields) > 0 {
+			err = fields
+		}
+	}
+	if err != nil {
+		// The flags of single operations are named after the fields of their
+		// metadata.
+		fieldPrefix := ``
+		if cmd.name != `pipeline` {
+			fieldPrefix = `operations[0].metadata.`
+		}
+		fmt.Fprintln(os.Stderr, `imgproc: `+describe(err, fieldPrefix))
+		return 2
+	}
+
+	inputs, err := expandInputs(flags.Args())
+	if err == nil {
+		err = checkOutput(*output, len(inputs))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, `imgproc: `+err.Error())
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	failed := process(ctx, inputs, pipeline, &destination{path: *output, several: len(inputs) > 1}, max(*workers, 1), *timeout)
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "imgproc: %d of %d inputs failed\n", failed, len(inputs))
+		return 1
+	}
+
+	return 0
+}
+
+// describe lists the fields err reports one per line, with fieldPrefix
+// replaced by the dash of a flag.
+func describe(err error, fieldPrefix string) string {
+
+	var fields validation.Errors
+	var operationErr *handlers.OperationError
+	if errors.As(err, &operationErr) && len(operationErr.Fields) > 0 {
+		fields = operationErr.Fields
+	} else if !errors.As(err, &fields) {
+		return err.Error()
+	}
+
+	lines := make([]string, 0, len(fields))
+	for _, field := range fields {
+		name := field.Field
+		if fieldPrefix != `` && strings.HasPrefix(name, fieldPrefix) {
+			name = `-` + strings.TrimPrefix(name, fieldPrefix)
+		}
+		lines = append(lines, name+`: `+field.Reason)
+	}
+
+	return strings.Join(lines, "\n"+`imgproc: `)
+}
+
+// expandInputs expands glob patterns, which must match at least one file.
+// Without inputs, standard input is read.
+func expandInputs(patterns []string) ([]string, error) {
+
+	if len(patterns) == 0 {
+		return []string{stdio}, nil
+	}
+
+	var inputs []string
+	for _, pattern := range patterns {
+		if pattern == stdio {
+			inputs = append(inputs, stdio)
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.New(`Invalid pattern ` + pattern + `. Error: ` + err.Error())
+		}
+		if len(matches) == 0 {
+			return nil, errors.New(`No file matches ` + pattern + `.`)
+		}
+		inputs = append(inputs, matches...)
+	}
+
+	stdinCount := 0
+	for _, input := range inputs {
+		if input == stdio {
+			stdinCount++
+		}
+	}
+	if stdinCount > 1 {
+		return nil, errors.New(`Standard input can only be read once.`)
+	}
+
+	return inputs, nil
+}
+
+func checkOutput(output string, inputs int) error {
+
+	if inputs > 1 && (output == `` || output == stdio) {
+		return errors.New(`Must set -o to a directory for several inputs.`)
+	}
+
+	return nil
+}
+
+// destination names the file the result of each input is written to. With
+// several inputs, or when the output is a directory already, results are
+// written in the output directory under the name of their input, with the
+// extension of their format.
+type destination struct {
+	path    string
+	several bool
+
+	mu sync.Mutex
+	// claimed maps the files written so far to their input, so results do
+	// not overwrite each other.
+	claimed map[string]string
+}
+
+func (d *destination) file(input string, format imaging.Format) (string, error) {
+
+	if d.path == `` || d.path == stdio {
+		return stdio, nil
+	}
+
+	info, err := os.Stat(d.path)
+	isDir := err == nil && info.IsDir()
+	if !d.several && !isDir && !strings.HasSuffix(d.path, string(filepath.Separator)) {
+		return d.path, nil
+	}
+
+	name := `stdin`
+	if input != stdio {
+		name = strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+	}
+	file := filepath.Join(d.path, name+handlers.FormatExtension(format))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.claimed == nil {
+		d.claimed = map[string]string{}
+	}
+	if other, ok := d.claimed[file]; ok {
+		return ``, errors.New(`The result of ` + other + ` was already written to ` + file + `.`)
+	}
+	d.claimed[file] = input
+
+	return file, os.MkdirAll(d.path, 0o755)
+}
+
+// process runs pipeline on the inputs with workers in parallel, reports the
+// failures on standard error and returns how many inputs failed.
+func process(ctx context.Context, inputs []string, pipeline handlers.PipelineMetadata, dest *destination, workers int, timeout time.Duration) int {
+
+	jobs := make(chan string)
+	failures := make(chan error, len(inputs))
+
+	var wg sync.WaitGroup
+	for range min(workers, len(inputs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for input := range jobs {
+				err := processInput(ctx, input, pipeline, dest, timeout)
+				if err != nil {
+					name := input
+					if input == stdio {
+						name = `standard input`
+					}
+					failures <- errors.New(name + `: ` + describe(err, ``))
+				}
+			}
+		}()
+	}
+
+	for _, input := range inputs {
+		jobs <- input
+	}
+	close(jobs)
+	wg.Wait()
+	close(failures)
+
+	failed := 0
+	for err := range failures {
+		fmt.Fprintln(os.Stderr, `imgproc: `+err.Error())
+		failed++
+	}
+
+	return failed
+}
+
+func processInput(ctx context.Context, input string, pipeline handlers.PipelineMetadata, dest *destination, timeout time.Duration) error {
+
+	ctx, cancelCtx := context.WithTimeout(ctx, timeout)
+	defer cancelCtx()
+
+	reader, format, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decoded, err := handlers.DecodeImage(ctx, reader)
+	if err != nil {
+		return err
+	}
+
+	processed, format, err := handlers.ApplyOperations(ctx, decoded, format, pipeline.Operations)
+	if err != nil {
+		return err
+	}
+	format = handlers.ResolveFormat(pipeline.Output, format)
+
+	file, err := dest.file(input, format)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(file, func(w io.Writer) error {
+		return handlers.EncodeImage(ctx, w, processed, format, pipeline.Output.Quality)
+	})
+}
+
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// openInput opens a file, whose name tells its format like uploads, or reads
+// standard input whole, whose content tells its format.
+func openInput(input string) (readSeekCloser, imaging.Format, error) {
+
+	if input != stdio {
+		format, err := handlers.ImageFormat(input)
+		if err != nil {
+			return nil, format, err
+		}
+
+		file, err := os.Open(input)
+		return file, format, err
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	_, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, errors.New(`Could not decode image.`)
+	}
+	format, err := handlers.ImageFormat(`stdin.` + name)
+	if err != nil {
+		return nil, format, err
+	}
+
+	return nopCloser{bytes.NewReader(data)}, format, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// writeOutput writes a file through a temporary file renamed once complete,
+// so a failure never leaves a truncated result behind.
+func writeOutput(file string, write func(w io.Writer) error) error {
+
+	if file == stdio {
+		w := bufio.NewWriter(os.Stdout)
+		err := write(w)
+		if err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	temporary, err := os.CreateTemp(filepath.Dir(file), `.imgproc-*`)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+
+	err = temporary.Chmod(0o644)
+	if err == nil {
+		err = write(temporary)
+	}
+	if err == nil {
+		err = temporary.Close()
+	} else {
+		temporary.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary.Name(), file)
+}
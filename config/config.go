@@ -0,0 +1,329 @@
+package config
+
+import (
+	"errors"
+	"imageProcessorAPI/clientip"
+	"imageProcessorAPI/quota"
+	"imageProcessorAPI/ratelimit"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Config is everything the server can be configured with. Each setting can
+// come from the config file under its yaml name, from the environment
+// variable in its env tag, or from a flag named after its path in the file,
+// like -limits.maxFileSize. Flags win over the environment, which wins over
+// the file. Settings tagged restart only take effect when the server starts,
+// the others also when the configuration is reloaded.
+type Config struct {
+	Listen string `yaml:"listen" env:"LISTEN_ADDR" usage:"address the server listens on" restart:"true"`
+
+	Limits    Limits    `yaml:"limits"`
+	Timeouts  Timeouts  `yaml:"timeouts"`
+	RateLimit RateLimit `yaml:"rateLimit"`
+	Admission Admission `yaml:"admission"`
+	Auth      Auth      `yaml:"auth"`
+	Quota     Quota     `yaml:"quota"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Log       Log       `yaml:"log"`
+	API       API       `yaml:"api"`
+	Watch     Watch     `yaml:"watch"`
+
+	PresetsFile          string `yaml:"presetsFile" env:"PRESETS_FILE" usage:"JSON file of presets loaded at startup and on reload"`
+	PresetStoreFile      string `yaml:"presetStoreFile" env:"PRESET_STORE_FILE" usage:"file every stored preset version is kept in" restart:"true"`
+	ResponsiveStorageDir string `yaml:"responsiveStorageDir" env:"RESPONSIVE_STORAGE_DIR" usage:"directory stored responsive sets are served from" restart:"true"`
+}
+
+type Limits struct {
+	MaxFileSize int64 `yaml:"maxFileSize" env:"MAX_FILE_SIZE" usage:"largest accepted image upload in bytes"`
+	// MaxRequestSize also bounds what an upload may carry besides its file.
+	MaxRequestSize int64 `yaml:"maxRequestSize" env:"MAX_REQUEST_SIZE" usage:"largest request body in bytes of routes without uploads"`
+	MaxDimension   int   `yaml:"maxDimension" env:"MAX_DIMENSION" usage:"largest accepted image width or height in pixels"`
+	JPEGQuality    int   `yaml:"jpegQuality" env:"JPEG_QUALITY" usage:"JPEG quality when a request does not set one"`
+}
+
+type Timeouts struct {
+	Processing time.Duration `yaml:"processing" env:"PROCESSING_TIMEOUT" usage:"time allowed to process a single image"`
+	Responsive time.Duration `yaml:"responsive" env:"RESPONSIVE_TIMEOUT" usage:"time allowed to generate a responsive set"`
+	Batch      time.Duration `yaml:"batch" env:"BATCH_TIMEOUT" usage:"time allowed to process a batch"`
+	// On shutdown readiness fails for DrainDelay before the server stops
+	// accepting requests, then in-flight requests get Shutdown to finish.
+	DrainDelay time.Duration `yaml:"drainDelay" env:"DRAIN_DELAY" usage:"time readiness fails before the server stops accepting requests on shutdown"`
+	Shutdown   time.Duration `yaml:"shutdown" env:"SHUTDOWN_TIMEOUT" usage:"time allowed for in-flight requests to finish on shutdown"`
+}
+
+type RateLimit struct {
+	// Policy and Routes are written like 10/30s, a limit per window.
+	Policy         string            `yaml:"policy" env:"RATE_LIMIT_POLICY" usage:"requests allowed per window, like 10/30s"`
+	Routes         map[string]string `yaml:"routes" env:"RATE_LIMIT_ROUTES" usage:"policies per path, like /batch=2/30s,/responsive=5/1m"`
+	Allowlist      []string          `yaml:"allowlist" env:"RATE_LIMIT_ALLOWLIST" usage:"CIDRs exempt from rate limits"`
+	Blocklist      []string          `yaml:"blocklist" env:"RATE_LIMIT_BLOCKLIST" usage:"CIDRs rejected outright"`
+	TrustedProxies []string          `yaml:"trustedProxies" env:"TRUSTED_PROXIES" usage:"CIDRs of proxies whose forwarding headers are trusted"`
+	RedisAddr      string            `yaml:"redisAddr" env:"REDIS_ADDR" usage:"Redis shared by the limiters of every instance" restart:"true"`
+	RedisPassword  string            `yaml:"redisPassword" env:"REDIS_PASSWORD" usage:"Redis password" secret:"true" restart:"true"`
+	CostBudget     ratelimit.Budget  `yaml:"costBudget" env:"COST_DEFAULT_BUDGET,json" usage:"pixel work budget of callers without their own, as JSON"`
+}
+
+type Admission struct {
+	MemoryMB int           `yaml:"memoryMB" env:"ADMISSION_MEMORY_MB" usage:"memory budget of requests being processed in MB"`
+	Slots    int           `yaml:"slots" env:"ADMISSION_SLOTS" usage:"requests processed at once"`
+	Queue    int           `yaml:"queue" env:"ADMISSION_QUEUE" usage:"requests waiting for admission at most"`
+	MaxWait  time.Duration `yaml:"maxWait" env:"ADMISSION_MAX_WAIT" usage:"longest wait for admission"`
+}
+
+type Auth struct {
+	Disabled    bool   `yaml:"disabled" env:"AUTH_DISABLED" usage:"serve requests without credentials"`
+	AdminToken  string `yaml:"adminToken" env:"ADMIN_TOKEN" usage:"bearer token of the admin API, disabled when empty" secret:"true"`
+	APIKeysFile string `yaml:"apiKeysFile" env:"API_KEYS_FILE" usage:"file API keys are stored in" restart:"true"`
+	JWT         JWT    `yaml:"jwt"`
+}
+
+type JWT struct {
+	JWKS     string        `yaml:"jwks" env:"JWT_JWKS" usage:"file or URL of the JWKS bearer tokens are verified with"`
+	Issuer   string        `yaml:"issuer" env:"JWT_ISSUER" usage:"issuer bearer tokens must carry, required with jwks"`
+	Audience string        `yaml:"audience" env:"JWT_AUDIENCE" usage:"audience bearer tokens must carry, required with jwks"`
+	Leeway   time.Duration `yaml:"leeway" env:"JWT_LEEWAY" usage:"clock skew allowed when checking token times"`
+}
+
+type Quota struct {
+	File          string       `yaml:"file" env:"QUOTA_FILE" usage:"file quota usage is persisted to" restart:"true"`
+	DefaultPolicy quota.Policy `yaml:"defaultPolicy" env:"QUOTA_DEFAULT_POLICY,json" usage:"quota of callers without their own, as JSON"`
+}
+
+type Tracing struct {
+	// Exporter is none, otlp or stdout.
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" usage:"where spans are exported: none, otlp or stdout" restart:"true"`
+	Endpoint    string  `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" usage:"OTLP/HTTP collector URL spans are exported to" restart:"true"`
+	SampleRatio float64 `yaml:"sampleRatio" env:"TRACING_SAMPLE_RATIO" usage:"share of new traces that are sampled, from 0 to 1" restart:"true"`
+	ServiceName string  `yaml:"serviceName" env:"OTEL_SERVICE_NAME" usage:"service name spans are reported under" restart:"true"`
+}
+
+type Log struct {
+	// Format is text or json.
+	Format string `yaml:"format" env:"LOG_FORMAT" usage:"format of log lines: text or json" restart:"true"`
+	Level  string `yaml:"level" env:"LOG_LEVEL" usage:"lowest level logged: debug, info, warn or error"`
+}
+
+// API tells clients of v1 when it was deprecated and when it goes away, in
+// dates like 2026-10-18. Nothing is announced until v1Deprecated is set.
+type API struct {
+	V1Deprecated string `yaml:"v1Deprecated" env:"API_V1_DEPRECATED" usage:"date v1 was deprecated on, announced in the Deprecation header of its responses when set"`
+	V1Sunset     string `yaml:"v1Sunset" env:"API_V1_SUNSET" usage:"date v1 stops being served, announced in the Sunset header of its responses when set"`
+}
+
+// Watch processes the images dropped into a directory, for integrations that
+// exchange files instead of calling the API. It is off while Input is empty.
+// Pipeline is written like the metadata of POST /v2/process.
+type Watch struct {
+	Input        string        `yaml:"input" env:"WATCH_INPUT_DIR" usage:"directory watched for new images, watching is off when empty" restart:"true"`
+	Output       string        `yaml:"output" env:"WATCH_OUTPUT_DIR" usage:"directory results are written to" restart:"true"`
+	Errors       string        `yaml:"errors" env:"WATCH_ERROR_DIR" usage:"directory images that fail are moved to, with an .error.json file" restart:"true"`
+	Ledger       string        `yaml:"ledger" env:"WATCH_LEDGER_FILE" usage:"file processed images are recorded in, so restarts skip them" restart:"true"`
+	Preset       string        `yaml:"preset" env:"WATCH_PRESET" usage:"preset applied to images, name or name@version"`
+	Pipeline     string        `yaml:"pipeline" env:"WATCH_PIPELINE" usage:"operations and output options applied when no preset is set, as JSON"`
+	NameTemplate string        `yaml:"nameTemplate" env:"WATCH_NAME_TEMPLATE" usage:"path of results in the output directory, from {name}, {ext}, {preset} and {date}"`
+	Interval     time.Duration `yaml:"interval" env:"WATCH_INTERVAL" usage:"time between scans of the input directory" restart:"true"`
+	Workers      int           `yaml:"workers" env:"WATCH_WORKERS" usage:"images processed at once" restart:"true"`
+}
+
+// Default is the configuration the server runs with when nothing is set.
+func Default() Config {
+	return Config{
+		Listen: `:8000`,
+		Limits: Limits{
+			MaxFileSize:    30 * 1024 * 1024,
+			MaxRequestSize: 1024 * 1024,
+			MaxDimension:   2000,
+			JPEGQuality:    85,
+		},
+		Timeouts: Timeouts{
+			Processing: time.Second * 30,
+			Responsive: time.Second * 60,
+			Batch:      time.Minute * 10,
+			Shutdown:   time.Second * 30,
+		},
+		RateLimit: RateLimit{
+			Policy:     `10/30s`,
+			Allowlist:  []string{`127.0.0.1`, `::1`},
+			CostBudget: ratelimit.Budget{Units: 300, WindowSeconds: 60},
+		},
+		Admission: Admission{
+			MemoryMB: 1024,
+			Slots:    runtime.NumCPU(),
+			Queue:    64,
+			MaxWait:  time.Second * 10,
+		},
+		Auth: Auth{
+			APIKeysFile: `api_keys.json`,
+			JWT:         JWT{Leeway: time.Second * 30},
+		},
+		Quota: Quota{File: `quota_usage.json`},
+		Tracing: Tracing{
+			Exporter:    `none`,
+			Endpoint:    `http://localhost:4318`,
+			SampleRatio: 1,
+			ServiceName: `imageProcessorAPI`,
+		},
+		Log: Log{Format: `text`, Level: `info`},
+		Watch: Watch{
+			Ledger:       `watch_ledger.jsonl`,
+			NameTemplate: `{name}.{ext}`,
+			Interval:     time.Second * 5,
+			Workers:      1,
+		},
+		PresetStoreFile: `preset_store.json`,
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+
+	var errs []error
+	check := func(ok bool, message string) {
+		if !ok {
+			errs = append(errs, errors.New(message))
+		}
+	}
+
+	check(c.Listen != ``, `listen must be set.`)
+	check(c.Limits.MaxFileSize > 0, `limits.maxFileSize must be positive.`)
+	check(c.Limits.MaxRequestSize > 0, `limits.maxRequestSize must be positive.`)
+	check(c.Limits.MaxDimension > 0, `limits.maxDimension must be positive.`)
+	check(c.Limits.JPEGQuality >= 1 && c.Limits.JPEGQuality <= 100, `limits.jpegQuality must be between 1 and 100.`)
+	check(c.Timeouts.Processing > 0, `timeouts.processing must be positive.`)
+	check(c.Timeouts.Responsive > 0, `timeouts.responsive must be positive.`)
+	check(c.Timeouts.Batch > 0, `timeouts.batch must be positive.`)
+	check(c.Timeouts.DrainDelay >= 0, `timeouts.drainDelay must not be negative.`)
+	check(c.Timeouts.Shutdown > 0, `timeouts.shutdown must be positive.`)
+	check(c.Admission.MemoryMB > 0, `admission.memoryMB must be positive.`)
+	check(c.Admission.Slots > 0, `admission.slots must be positive.`)
+	check(c.Admission.Queue >= 0, `admission.queue must not be negative.`)
+	check(c.Admission.MaxWait > 0, `admission.maxWait must be positive.`)
+	check(c.Auth.JWT.Leeway >= 0, `auth.jwt.leeway must not be negative.`)
+	// Tokens of other issuers, or meant for other services, are never accepted.
+	check(c.Auth.JWT.JWKS == `` || c.Auth.JWT.Issuer != ``, `auth.jwt.issuer must be set with auth.jwt.jwks.`)
+	check(c.Auth.JWT.JWKS == `` || c.Auth.JWT.Audience != ``, `auth.jwt.audience must be set with auth.jwt.jwks.`)
+
+	check(c.Tracing.Exporter == `none` || c.Tracing.Exporter == `otlp` || c.Tracing.Exporter == `stdout`, `tracing.exporter must be none, otlp or stdout.`)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, `tracing.sampleRatio must be between 0 and 1.`)
+
+	check(c.Log.Format == `text` || c.Log.Format == `json`, `log.format must be text or json.`)
+	_, err := c.Log.ParseLevel()
+	check(err == nil, `log.level must be debug, info, warn or error.`)
+
+	deprecated, sunset, err := c.API.V1Dates()
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		check(sunset.IsZero() || !deprecated.IsZero(), `api.v1Sunset needs api.v1Deprecated to be set.`)
+		check(sunset.IsZero() || deprecated.IsZero() || sunset.After(deprecated), `api.v1Sunset must be after api.v1Deprecated.`)
+	}
+
+	if c.Watch.Input != `` {
+		check(c.Watch.Output != `` && c.Watch.Errors != `` && c.Watch.Ledger != ``, `watch.output, watch.errors and watch.ledger must be set to watch a directory.`)
+		input := filepath.Clean(c.Watch.Input)
+		check(filepath.Clean(c.Watch.Output) != input && filepath.Clean(c.Watch.Errors) != input, `watch.output and watch.errors must differ from watch.input.`)
+		check((c.Watch.Preset == ``) != (c.Watch.Pipeline == ``), `watch.preset or watch.pipeline must be set, not both.`)
+		check(c.Watch.Interval > 0, `watch.interval must be positive.`)
+		check(c.Watch.Workers > 0, `watch.workers must be positive.`)
+	}
+
+	_, _, err = c.RateLimit.Policies()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	_, err = c.RateLimit.PrefixLists()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	err = c.RateLimit.CostBudget.Validate()
+	if err != nil {
+		errs = append(errs, errors.New(`rateLimit.costBudget: `+err.Error()))
+	}
+	err = c.Quota.DefaultPolicy.Validate()
+	if err != nil {
+		errs = append(errs, errors.New(`quota.defaultPolicy: `+err.Error()))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (l Log) ParseLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(l.Level))
+	return level, err
+}
+
+// V1Dates parses the deprecation and sunset dates of v1, either is zero when
+// it is not set.
+func (a API) V1Dates() (time.Time, time.Time, error) {
+
+	var err error
+
+	var deprecated time.Time
+	if a.V1Deprecated != `` {
+		deprecated, err = time.Parse(time.DateOnly, a.V1Deprecated)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New(`api.v1Deprecated must be a date like 2026-10-18.`)
+		}
+	}
+
+	var sunset time.Time
+	if a.V1Sunset != `` {
+		sunset, err = time.Parse(time.DateOnly, a.V1Sunset)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New(`api.v1Sunset must be a date like 2026-10-18.`)
+		}
+	}
+
+	return deprecated, sunset, nil
+}
+
+// Policies parses the global and per route rate limit policies.
+func (r RateLimit) Policies() (ratelimit.Policy, map[string]ratelimit.Policy, error) {
+
+	policy, err := ratelimit.ParsePolicy(r.Policy)
+	if err != nil {
+		return ratelimit.Policy{}, nil, errors.New(`rateLimit.policy: ` + err.Error())
+	}
+
+	routes := map[string]ratelimit.Policy{}
+	for path, routePolicy := range r.Routes {
+		routes[path], err = ratelimit.ParsePolicy(routePolicy)
+		if err != nil {
+			return ratelimit.Policy{}, nil, errors.New(`rateLimit.routes ` + path + `: ` + err.Error())
+		}
+	}
+
+	return policy, routes, nil
+}
+
+type PrefixLists struct {
+	Allowlist      clientip.PrefixList
+	Blocklist      clientip.PrefixList
+	TrustedProxies clientip.PrefixList
+}
+
+func (r RateLimit) PrefixLists() (PrefixLists, error) {
+
+	var lists PrefixLists
+	var err error
+	for _, list := range []struct {
+		name    string
+		entries []string
+		parsed  *clientip.PrefixList
+	}{
+		{`rateLimit.allowlist`, r.Allowlist, &lists.Allowlist},
+		{`rateLimit.blocklist`, r.Blocklist, &lists.Blocklist},
+		{`rateLimit.trustedProxies`, r.TrustedProxies, &lists.TrustedProxies},
+	} {
+		*list.parsed, err = clientip.ParsePrefixes(list.entries)
+		if err != nil {
+			return PrefixLists{}, errors.New(list.name + `: ` + err.Error())
+		}
+	}
+
+	return lists, nil
+}
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Options are the flags that are not settings.
+type Options struct {
+	// File the configuration was read from, if any.
+	File        string
+	PrintConfig bool
+	// PrintOpenAPI and CheckOpenAPI are about the OpenAPI document, the
+	// latter names the file it is compared with.
+	PrintOpenAPI bool
+	CheckOpenAPI string
+}
+
+// setting is one configurable field, found by walking Config.
+type setting struct {
+	path    string
+	env     string
+	asJSON  bool
+	usage   string
+	secret  bool
+	restart bool
+	value   reflect.Value
+}
+
+// Load builds the configuration from the defaults, the file named by -config
+// or CONFIG_FILE, the environment and the flags in args, in that order of
+// precedence, and validates it.
+func Load(args []string) (Config, Options, error) {
+
+	config := Default()
+	settings := settingsOf(&config)
+
+	options := Options{File: os.Getenv(`CONFIG_FILE`)}
+	flags := flag.NewFlagSet(`imageProcessorAPI`, flag.ContinueOnError)
+	flags.StringVar(&options.File, `config`, options.File, `YAML file to read the configuration from`)
+	flags.BoolVar(&options.PrintConfig, `print-config`, false, `print the effective configuration and exit`)
+	flags.BoolVar(&options.PrintOpenAPI, `print-openapi`, false, `print the OpenAPI document and exit`)
+	flags.StringVar(&options.CheckOpenAPI, `check-openapi`, ``, `exit with an error when the OpenAPI document differs from the one in this file`)
+
+	// Flag values are only applied once the file and the environment were.
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+	var flagValues []flagValue
+	for _, s := range settings {
+		flags.Func(s.path, s.usage, func(value string) error {
+			flagValues = append(flagValues, flagValue{setting: s, value: value})
+			return nil
+		})
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return Config{}, options, err
+	}
+
+	if options.File != `` {
+		err = loadFile(&config, options.File)
+		if err != nil {
+			return Config{}, options, err
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.env)
+		if !ok {
+			continue
+		}
+
+		err = s.set(value)
+		if err != nil {
+			return Config{}, options, errors.New(s.env + `: ` + err.Error())
+		}
+	}
+
+	for _, flagValue := range flagValues {
+		err = flagValue.setting.set(flagValue.value)
+		if err != nil {
+			return Config{}, options, errors.New(`-` + flagValue.setting.path + `: ` + err.Error())
+		}
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return Config{}, options, err
+	}
+
+	return config, options, nil
+}
+
+func loadFile(config *Config, path string) error {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Unknown keys are errors, so a typo does not silently keep a default.
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return errors.New(path + `: ` + err.Error())
+	}
+
+	return nil
+}
+
+// Print writes the configuration as YAML, with secrets redacted.
+func (c Config) Print(w io.Writer) error {
+
+	redacted := c
+	for _, s := range settingsOf(&redacted) {
+		if s.secret && s.value.String() != `` {
+			s.value.SetString(`<redacted>`)
+		}
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	err := encoder.Encode(redacted)
+	if err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// RestartRequired lists the settings that differ from previous but only take
+// effect when the server starts.
+func (c Config) RestartRequired(previous Config) []string {
+
+	var paths []string
+	previousSettings := settingsOf(&previous)
+	for i, s := range settingsOf(&c) {
+		if s.restart && !reflect.DeepEqual(s.value.Interface(), previousSettings[i].value.Interface()) {
+			paths = append(paths, s.path)
+		}
+	}
+
+	return paths
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func settingsOf(config *Config) []setting {
+	return collectSettings(reflect.ValueOf(config).Elem(), ``)
+}
+
+func collectSettings(value reflect.Value, prefix string) []setting {
+
+	var settings []setting
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get(`yaml`), `,`)
+		env, options, _ := strings.Cut(field.Tag.Get(`env`), `,`)
+
+		if field.Type.Kind() == reflect.Struct && env == `` {
+			settings = append(settings, collectSettings(value.Field(i), prefix+name+`.`)...)
+			continue
+		}
+
+		settings = append(settings, setting{
+			path:    prefix + name,
+			env:     env,
+			asJSON:  options == `json`,
+			usage:   field.Tag.Get(`usage`),
+			secret:  field.Tag.Get(`secret`) == `true`,
+			restart: field.Tag.Get(`restart`) == `true`,
+			value:   value.Field(i),
+		})
+	}
+
+	return settings
+}
+
+// set parses value the way environment variables and flags are written:
+// lists separated by commas, maps as key=value pairs and structs as JSON.
+func (s setting) set(value string) error {
+
+	if s.asJSON {
+		return json.Unmarshal([]byte(value), s.value.Addr().Interface())
+	}
+
+	switch {
+	case s.value.Type() == durationType:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(duration))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(value)
+	case s.value.Kind() == reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(parsed)
+	case s.value.CanInt():
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(parsed)
+	case s.value.CanFloat():
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetFloat(parsed)
+	case s.value.Kind() == reflect.Slice:
+		list := []string{}
+		for _, entry := range strings.Split(value, `,`) {
+			if entry = strings.TrimSpace(entry); entry != `` {
+				list = append(list, entry)
+			}
+		}
+		s.value.Set(reflect.ValueOf(list))
+	case s.value.Kind() == reflect.Map:
+		pairs := map[string]string{}
+		for _, pair := range strings.Split(value, `,`) {
+			if strings.TrimSpace(pair) == `` {
+				continue
+			}
+			key, pairValue, ok := strings.Cut(pair, `=`)
+			if !ok {
+				return errors.New(`Expected key=value pairs separated by commas.`)
+			}
+			pairs[strings.TrimSpace(key)] = strings.TrimSpace(pairValue)
+		}
+		s.value.Set(reflect.ValueOf(pairs))
+	default:
+		return errors.New(`Unsupported setting type ` + s.value.Type().String() + `.`)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), `config.yaml`)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestFlagsWinOverTheEnvironmentOverTheFile(t *testing.T) {
+
+	path := writeConfig(t, `
+limits:
+  maxDimension: 100
+  jpegQuality: 50
+  maxFileSize: 1000
+timeouts:
+  batch: 1m
+`)
+	t.Setenv(`MAX_DIMENSION`, `200`)
+	t.Setenv(`MAX_FILE_SIZE`, `2000`)
+	t.Setenv(`RATE_LIMIT_ROUTES`, `/batch=2/30s, /responsive=5/1m`)
+	t.Setenv(`QUOTA_DEFAULT_POLICY`, `{"daily":{"requests":7}}`)
+
+	cfg, options, err := Load([]string{`-config`, path, `-limits.maxDimension`, `300`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.File != path {
+		t.Fatalf(`got file %q, want %q`, options.File, path)
+	}
+	settings := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{`file over default`, cfg.Limits.JPEGQuality, 50},
+		{`file over default`, cfg.Timeouts.Batch, time.Minute},
+		{`environment over file`, cfg.Limits.MaxFileSize, int64(2000)},
+		{`flag over environment`, cfg.Limits.MaxDimension, 300},
+		{`default`, cfg.Listen, Default().Listen},
+		{`map from the environment`, cfg.RateLimit.Routes[`/responsive`], `5/1m`},
+		{`JSON from the environment`, cfg.Quota.DefaultPolicy.Daily.Requests, int64(7)},
+	}
+	for _, setting := range settings {
+		if setting.got != setting.want {
+			t.Fatalf(`%s: got %v, want %v`, setting.name, setting.got, setting.want)
+		}
+	}
+}
+
+func TestLoadRejectsBadConfigurations(t *testing.T) {
+
+	configurations := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{`unknown key`, "limits:\n  maxDimensions: 100\n", nil, nil, `maxDimensions`},
+		{`malformed file`, "limits: [\n", nil, nil, `config.yaml`},
+		{`invalid value`, "limits:\n  jpegQuality: 0\n", nil, nil, `limits.jpegQuality`},
+		{`unparsable environment`, ``, map[string]string{`PROCESSING_TIMEOUT`: `soon`}, nil, `PROCESSING_TIMEOUT`},
+		{`unparsable flag`, ``, nil, []string{`-limits.maxDimension`, `wide`}, `-limits.maxDimension`},
+		{`invalid flag`, ``, nil, []string{`-admission.slots`, `0`}, `admission.slots`},
+		{`issuer missing`, "auth:\n  jwt:\n    jwks: jwks.json\n    audience: api\n", nil, nil, `auth.jwt.issuer`},
+		{`sunset without deprecation`, "api:\n  v1Sunset: 2027-01-01\n", nil, nil, `api.v1Deprecated`},
+		{`sunset before deprecation`, "api:\n  v1Deprecated: 2027-01-01\n  v1Sunset: 2026-01-01\n", nil, nil, `api.v1Sunset`},
+	}
+	for _, configuration := range configurations {
+		t.Run(configuration.name, func(t *testing.T) {
+			for name, value := range configuration.env {
+				t.Setenv(name, value)
+			}
+			args := append([]string{`-config`, writeConfig(t, configuration.file)}, configuration.args...)
+
+			_, _, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), configuration.want) {
+				t.Fatalf(`got %v, want an error about %s`, err, configuration.want)
+			}
+		})
+	}
+}
+
+func TestEveryProblemIsReported(t *testing.T) {
+
+	cfg := Default()
+	cfg.Limits.MaxDimension = 0
+	cfg.Log.Format = `xml`
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), `limits.maxDimension`) || !strings.Contains(err.Error(), `log.format`) {
+		t.Fatalf(`got %v, want both problems reported`, err)
+	}
+}
+
+func TestV1IsNotDeprecatedByDefault(t *testing.T) {
+
+	deprecated, sunset, err := Default().API.V1Dates()
+	if err != nil || !deprecated.IsZero() || !sunset.IsZero() {
+		t.Fatalf(`got %v, %v and %v, want no dates`, deprecated, sunset, err)
+	}
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ReloadStats counts the reloads since the server started.
+type ReloadStats struct {
+	Succeeded int64
+	Failed    int64
+	// LastReload is when the last reload was attempted and LastError why it
+	// failed, empty when it did not.
+	LastReload time.Time
+	LastError  string
+}
+
+// Reloader loads the configuration again, from the same arguments, when the
+// process receives SIGHUP or when the config file or a file it names changes.
+// A configuration that does not load, validate or apply is logged and the
+// previous one stays in effect.
+type Reloader struct {
+	args  []string
+	apply func(Config) error
+	// PollInterval is how often the watched files are checked for changes.
+	PollInterval time.Duration
+
+	mu      sync.Mutex
+	current Config
+	options Options
+	stats   ReloadStats
+	// owned are the files the server writes itself, see Own.
+	owned map[string]func() string
+}
+
+// NewReloader reloads into apply, which should only make the new
+// configuration take effect once everything it needs was prepared, so a
+// failure leaves the previous one whole.
+func NewReloader(args []string, current Config, options Options, apply func(Config) error) *Reloader {
+	return &Reloader{
+		args:         args,
+		apply:        apply,
+		PollInterval: time.Second * 2,
+		current:      current,
+		options:      options,
+		owned:        map[string]func() string{},
+	}
+}
+
+// Own tells the reloader that the server writes path itself, written
+// returning the SHA-256 of what it last wrote in hex. A file that changed
+// only by such a write is no reason to reload.
+func (r *Reloader) Own(path string, written func() string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.owned[path] = written
+}
+
+// Run reloads on every signal or file change until ctx is done.
+func (r *Reloader) Run(ctx context.Context) {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	versions := r.fileVersions()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			r.Reload(`SIGHUP`)
+		case <-ticker.C:
+			next := r.fileVersions()
+			if !r.changed(versions, next) {
+				versions = next
+				continue
+			}
+			r.Reload(`file change`)
+		}
+
+		// The reload may have changed which files are watched.
+		versions = r.fileVersions()
+	}
+}
+
+// Reload loads and applies the configuration once, reason is only logged.
+func (r *Reloader) Reload(reason string) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, options, err := Load(r.args)
+	if err == nil {
+		if paths := next.RestartRequired(r.current); len(paths) > 0 {
+			slog.Warn(`Configuration changes to ` + strings.Join(paths, `, `) + ` take effect after a restart.`)
+		}
+		err = r.apply(next)
+	}
+
+	r.stats.LastReload = time.Now()
+	if err != nil {
+		r.stats.Failed++
+		r.stats.LastError = err.Error()
+		slog.Error(`Could not reload the configuration on ` + reason + `, keeping the previous one. Error: ` + err.Error())
+		return err
+	}
+
+	r.current = next
+	r.options = options
+	r.stats.Succeeded++
+	r.stats.LastError = ``
+	slog.Info(`Reloaded the configuration on ` + reason + `.`)
+
+	return nil
+}
+
+// Current is the configuration in effect.
+func (r *Reloader) Current() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current
+}
+
+func (r *Reloader) Stats() ReloadStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats
+}
+
+// changed tells whether a watched file changed from the versions before to
+// the ones after, other than by the server writing a file it owns.
+func (r *Reloader) changed(before map[string]string, after map[string]string) bool {
+
+	r.mu.Lock()
+	owned := maps.Clone(r.owned)
+	r.mu.Unlock()
+
+	if len(before) != len(after) {
+		return true
+	}
+	for path, version := range after {
+		previous, ok := before[path]
+		if !ok {
+			return true
+		}
+		if version == previous {
+			continue
+		}
+		if written, ok := owned[path]; ok && written() == version {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+// fileVersions hashes the config file and the files its settings name, which
+// are small enough to be read on every check. Files that do not exist have
+// an empty version, so creating them counts as a change.
+func (r *Reloader) fileVersions() map[string]string {
+
+	r.mu.Lock()
+	paths := []string{r.options.File, r.current.PresetsFile, r.current.Auth.APIKeysFile}
+	r.mu.Unlock()
+
+	versions := map[string]string{}
+	for _, path := range paths {
+		if path == `` {
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error(`Could not check ` + path + ` for changes. Error: ` + err.Error())
+		}
+		if err != nil {
+			versions[path] = ``
+			continue
+		}
+
+		sum := sha256.Sum256(content)
+		versions[path] = hex.EncodeToString(sum[:])
+	}
+
+	return versions
+}
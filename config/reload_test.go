@@ -0,0 +1,100 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritesOfOwnedFilesAreNoChange(t *testing.T) {
+
+	cfg := Default()
+	cfg.Auth.APIKeysFile = filepath.Join(t.TempDir(), `api_keys.json`)
+	written := ``
+	reloader := NewReloader(nil, cfg, Options{}, func(Config) error { return nil })
+	reloader.Own(cfg.Auth.APIKeysFile, func() string { return written })
+
+	write := func(content string) {
+		t.Helper()
+
+		err := os.WriteFile(cfg.Auth.APIKeysFile, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The server writes the file, as creating a key does.
+	before := reloader.fileVersions()
+	write(`[{"id":"1"}]`)
+	sum := sha256.Sum256([]byte(`[{"id":"1"}]`))
+	written = hex.EncodeToString(sum[:])
+	after := reloader.fileVersions()
+	if reloader.changed(before, after) {
+		t.Fatal(`got a change, want the write of the server ignored`)
+	}
+
+	// Someone else edits it.
+	write(`[{"id":"2"}]`)
+	if !reloader.changed(after, reloader.fileVersions()) {
+		t.Fatal(`got no change, want the edit reloaded`)
+	}
+}
+
+func TestReloadKeepsThePreviousConfiguration(t *testing.T) {
+
+	path := writeConfig(t, "limits:\n  maxDimension: 100\n")
+	args := []string{`-config`, path}
+	cfg, options, err := Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var applied []int
+	var applyErr error
+	reloader := NewReloader(args, cfg, options, func(next Config) error {
+		if applyErr != nil {
+			return applyErr
+		}
+		applied = append(applied, next.Limits.MaxDimension)
+		return nil
+	})
+	write := func(content string) {
+		t.Helper()
+
+		err := os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A configuration that does not validate is never applied.
+	write("limits:\n  maxDimension: -1\n")
+	err = reloader.Reload(`test`)
+	if err == nil || len(applied) != 0 || reloader.Current().Limits.MaxDimension != 100 {
+		t.Fatalf(`got %v applying %v, want the invalid configuration rejected`, err, applied)
+	}
+
+	// Nor is one that fails to apply kept as the current one.
+	write("limits:\n  maxDimension: 200\n")
+	applyErr = errors.New(`Could not apply.`)
+	err = reloader.Reload(`test`)
+	if err == nil || reloader.Current().Limits.MaxDimension != 100 {
+		t.Fatalf(`got %v and %d, want the previous configuration kept`, err, reloader.Current().Limits.MaxDimension)
+	}
+	stats := reloader.Stats()
+	if stats.Failed != 2 || stats.Succeeded != 0 || stats.LastError != applyErr.Error() {
+		t.Fatalf(`got %+v, want both failures counted`, stats)
+	}
+
+	applyErr = nil
+	err = reloader.Reload(`test`)
+	if err != nil || reloader.Current().Limits.MaxDimension != 200 || len(applied) != 1 || applied[0] != 200 {
+		t.Fatalf(`got %v applying %v, want the new configuration in effect`, err, applied)
+	}
+	if stats = reloader.Stats(); stats.Succeeded != 1 || stats.LastError != `` {
+		t.Fatalf(`got %+v, want the reload counted`, stats)
+	}
+}
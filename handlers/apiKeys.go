@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/problem"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateAPIKeyBody = api.CreateAPIKeyBody
+
+// APIKeyResponse is an APIKey without its hash, which never leaves the store.
+type APIKeyResponse = api.APIKeyResponse
+
+func ListAPIKeys(keys *auth.KeyStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		listed := keys.List()
+		response := make([]APIKeyResponse, 0, len(listed))
+		for _, key := range listed {
+			response = append(response, APIKeyResponse{APIKey: key})
+		}
+
+		return c.JSON(response)
+	}
+}
+
+// CreateAPIKey answers with the plain key, the only time it is shown.
+func CreateAPIKey(keys *auth.KeyStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		body := CreateAPIKeyBody{}
+		err := json.Unmarshal(c.Body(), &body)
+		if err != nil {
+			return problem.Send(c, problem.InvalidBody, `Invalid body.`)
+		}
+
+		if body.Name == `` {
+			return problem.Send(c, problem.InvalidBody, `Must set key name.`)
+		}
+
+		plain, key, err := keys.Create(auth.APIKey{Name: body.Name, Scopes: body.Scopes, Quota: body.Quota, CostBudget: body.CostBudget})
+		if err != nil {
+			var scopeErr *auth.ScopeError
+			if errors.As(err, &scopeErr) {
+				return problem.Send(c, problem.InvalidBody, err.Error())
+			}
+
+			logging.FromContext(c.UserContext()).Error(`Could not create API key. Error: ` + err.Error())
+			return problem.Send(c, problem.InternalError, ``)
+		}
+
+		logging.FromContext(c.UserContext()).Info(`Created API key ` + key.ID + ` for ` + key.Name + `.`)
+		return c.Status(fiber.StatusCreated).JSON(APIKeyResponse{APIKey: key, Key: plain})
+	}
+}
+
+func RevokeAPIKey(keys *auth.KeyStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		key, err := keys.Revoke(c.Params(`id`))
+		if err != nil {
+			if errors.Is(err, auth.ErrKeyNotFound) {
+				return problem.Send(c, problem.APIKeyNotFound, err.Error())
+			}
+
+			logging.FromContext(c.UserContext()).Error(`Could not revoke API key. Error: ` + err.Error())
+			return problem.Send(c, problem.InternalError, ``)
+		}
+
+		logging.FromContext(c.UserContext()).Info(`Revoked API key ` + key.ID + `.`)
+		return c.JSON(APIKeyResponse{APIKey: key})
+	}
+}
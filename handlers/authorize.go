@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/problem"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gofiber/fiber/v2"
+)
+
+// authorize checks a request against the caller's API key scopes. Requests
+// without an authenticated caller are allowed, as when authentication is off.
+func authorize(c *fiber.Ctx, operations []string, format string, width int, height int) error {
+	scopes, ok := auth.CallerScopes(c)
+	if !ok {
+		return nil
+	}
+
+	return scopes.Authorize(operations, format, width, height)
+}
+
+func imageFormatName(format imaging.Format) string {
+	return strings.ToLower(format.String())
+}
+
+func operationNames(operations []Operation) []string {
+	names := make([]string, 0, len(operations))
+	for _, operation := range operations {
+		names = append(names, strings.ToLower(operation.Name))
+	}
+
+	return names
+}
+
+func forbidden(c *fiber.Ctx, err error) error {
+	return problem.Send(c, problem.Forbidden, err.Error())
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/middlewares"
+	"imageProcessorAPI/problem"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey signs tokens a verifier reading the JWKS file it returns accepts.
+func signingKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := json.Marshal(map[string]any{`keys`: []map[string]string{{
+		`kty`: `EC`,
+		`kid`: `test`,
+		`crv`: `P-256`,
+		`x`:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		`y`:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), `jwks.json`)
+	err = os.WriteFile(path, set, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, path
+}
+
+func TestUnauthenticatedAndForbiddenRequests(t *testing.T) {
+
+	keys, err := auth.NewKeyStore(``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, _, err := keys.Create(auth.APIKey{Name: `resize`, Scopes: auth.Scopes{Operations: []string{`resize`}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	narrow, _, err := keys.Create(auth.APIKey{Name: `narrow`, Scopes: auth.Scopes{MaxWidth: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, key, err := keys.Create(auth.APIKey{Name: `revoked`})
+	if err == nil {
+		_, err = keys.Revoke(key.ID)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, jwks := signingKey(t)
+	tokens, err := auth.NewTokenVerifier(auth.TokenConfig{JWKS: jwks, Issuer: `issuer`, Audience: `image-api`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(scope string) string {
+		t.Helper()
+
+		claims := auth.TokenClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    `issuer`,
+				Audience:  jwt.ClaimStrings{`image-api`},
+				Subject:   `client`,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			Scope: scope,
+		}
+		unsigned := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		unsigned.Header[`kid`] = `test`
+		signed, err := unsigned.SignedString(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return `Bearer ` + signed
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler})
+	app.Use(middlewares.Authenticate(keys, tokens))
+	app.Post(`/v2/process`, Process(&PresetStore{}))
+
+	resize := `{"operations":[{"name":"resize","metadata":{"width":10}}]}`
+	grayscale := `{"operations":[{"name":"grayscale","metadata":{}}]}`
+	requests := []struct {
+		name     string
+		header   string
+		value    string
+		metadata string
+		status   int
+		code     problem.Code
+	}{
+		{`no credentials`, ``, ``, resize, fiber.StatusUnauthorized, problem.Unauthenticated},
+		{`unknown key`, middlewares.APIKeyHeader, `ipa_0000_0000`, resize, fiber.StatusUnauthorized, problem.InvalidCredentials},
+		{`revoked key`, middlewares.APIKeyHeader, revoked, resize, fiber.StatusUnauthorized, problem.InvalidCredentials},
+		{`malformed token`, fiber.HeaderAuthorization, `Bearer not-a-token`, resize, fiber.StatusUnauthorized, problem.InvalidCredentials},
+		{`token without scopes`, fiber.HeaderAuthorization, token(``), resize, fiber.StatusForbidden, problem.Forbidden},
+		{`operation out of scope`, middlewares.APIKeyHeader, allowed, grayscale, fiber.StatusForbidden, problem.Forbidden},
+		{`image wider than allowed`, middlewares.APIKeyHeader, narrow, resize, fiber.StatusForbidden, problem.Forbidden},
+		{`operation in scope`, middlewares.APIKeyHeader, allowed, resize, fiber.StatusOK, ``},
+		{`token in scope`, fiber.HeaderAuthorization, token(`resize`), resize, fiber.StatusOK, ``},
+	}
+	for _, request := range requests {
+		body, contentType := uploadForm(t, map[string]string{`metadata`: request.metadata})
+		upload := httptest.NewRequest(`POST`, `/v2/process`, body)
+		upload.Header.Set(fiber.HeaderContentType, contentType)
+		if request.header != `` {
+			upload.Header.Set(request.header, request.value)
+		}
+
+		response, err := app.Test(upload, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != request.status {
+			t.Fatalf(`%s: got status %d and %s, want %d`, request.name, response.StatusCode, data, request.status)
+		}
+		if request.code == `` {
+			continue
+		}
+		problemDetails := problem.Problem{}
+		err = json.Unmarshal(data, &problemDetails)
+		if err != nil || problemDetails.Code != request.code {
+			t.Fatalf(`%s: got %s, want %s`, request.name, data, request.code)
+		}
+		if request.status == fiber.StatusUnauthorized && response.Header.Get(fiber.HeaderWWWAuthenticate) == `` {
+			t.Fatalf(`%s: got no %s header, want the scheme to use`, request.name, fiber.HeaderWWWAuthenticate)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// Rows, or columns, transformed between two cancellation checks.
+const bandSize = 128
+
+// forBands calls fn on consecutive [start, end) bands covering size, and stops
+// as soon as ctx is done.
+func forBands(ctx context.Context, size int, fn func(start int, end int)) error {
+	for start := 0; start < size; start += bandSize {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		fn(start, min(start+bandSize, size))
+	}
+
+	return ctx.Err()
+}
+
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// rows returns the rows [start, end) of img, relative to its bounds.
+func rows(img image.Image, start int, end int) image.Image {
+	bounds := img.Bounds()
+	return subImage(img, image.Rect(bounds.Min.X, bounds.Min.Y+start, bounds.Max.X, bounds.Min.Y+end))
+}
+
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(subImager); ok {
+		return sub.SubImage(r)
+	}
+
+	return imaging.Crop(img, r)
+}
+
+// paste copies src into dst with its top left corner at at.
+func paste(dst *image.NRGBA, src *image.NRGBA, at image.Point) {
+	rowSize := src.Rect.Dx() * 4
+	for y := 0; y < src.Rect.Dy(); y++ {
+		d := dst.PixOffset(at.X, at.Y+y)
+		s := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y)
+		copy(dst.Pix[d:d+rowSize], src.Pix[s:s+rowSize])
+	}
+}
+
+func newNRGBA(width int, height int) *image.NRGBA {
+	return image.NewNRGBA(image.Rect(0, 0, width, height))
+}
+
+// bandedRows applies a row preserving transform, like grayscale or a
+// horizontal flip, band by band.
+func bandedRows(ctx context.Context, img image.Image, transform func(image.Image) *image.NRGBA) (*image.NRGBA, error) {
+	dst := newNRGBA(img.Bounds().Dx(), img.Bounds().Dy())
+	err := forBands(ctx, img.Bounds().Dy(), func(start int, end int) {
+		paste(dst, transform(rows(img, start, end)), image.Pt(0, start))
+	})
+
+	return dst, err
+}
+
+func grayscaleContext(ctx context.Context, img image.Image) (*image.NRGBA, error) {
+	return bandedRows(ctx, img, imaging.Grayscale)
+}
+
+func flipHContext(ctx context.Context, img image.Image) (*image.NRGBA, error) {
+	return bandedRows(ctx, img, imaging.FlipH)
+}
+
+func flipVContext(ctx context.Context, img image.Image) (*image.NRGBA, error) {
+	height := img.Bounds().Dy()
+	dst := newNRGBA(img.Bounds().Dx(), height)
+	err := forBands(ctx, height, func(start int, end int) {
+		paste(dst, imaging.FlipV(rows(img, start, end)), image.Pt(0, height-end))
+	})
+
+	return dst, err
+}
+
+func cropContext(ctx context.Context, img image.Image, rect image.Rectangle) (*image.NRGBA, error) {
+	rect = rect.Intersect(img.Bounds())
+	if rect.Empty() {
+		return &image.NRGBA{}, nil
+	}
+
+	return bandedRows(ctx, subImage(img, rect), imaging.Clone)
+}
+
+// resizeContext resizes like imaging.Resize, which filters horizontally and
+// then vertically. The horizontal pass runs in bands of rows and the vertical
+// one in bands of columns, so the result is the same.
+func resizeContext(ctx context.Context, img image.Image, width int, height int, filter imaging.ResampleFilter) (*image.NRGBA, error) {
+
+	srcW, srcH := img.Bounds().Dx(), img.Bounds().Dy()
+	if width < 0 || height < 0 || width == 0 && height == 0 || srcW <= 0 || srcH <= 0 {
+		return &image.NRGBA{}, nil
+	}
+	if width == 0 {
+		width = int(math.Max(1, math.Floor(float64(height)*float64(srcW)/float64(srcH)+0.5)))
+	}
+	if height == 0 {
+		height = int(math.Max(1, math.Floor(float64(width)*float64(srcH)/float64(srcW)+0.5)))
+	}
+
+	if srcW == width && srcH == height {
+		return bandedRows(ctx, img, imaging.Clone)
+	}
+
+	resized := img
+	if srcW != width {
+		horizontal := newNRGBA(width, srcH)
+		err := forBands(ctx, srcH, func(start int, end int) {
+			paste(horizontal, imaging.Resize(rows(img, start, end), width, end-start, filter), image.Pt(0, start))
+		})
+		if err != nil {
+			return nil, err
+		}
+		resized = horizontal
+	}
+
+	if srcH != height {
+		bounds := resized.Bounds()
+		vertical := newNRGBA(width, height)
+		err := forBands(ctx, width, func(start int, end int) {
+			columns := subImage(resized, image.Rect(bounds.Min.X+start, bounds.Min.Y, bounds.Min.X+end, bounds.Max.Y))
+			paste(vertical, imaging.Resize(columns, end-start, height, filter), image.Pt(start, 0))
+		})
+		if err != nil {
+			return nil, err
+		}
+		resized = vertical
+	}
+
+	return resized.(*image.NRGBA), nil
+}
+
+// rotateContext rotates counter-clockwise like imaging.Rotate. Right angles
+// move bands of source rows, other angles are interpolated band by band of
+// destination rows.
+func rotateContext(ctx context.Context, img image.Image, angle float64, background color.Color) (*image.NRGBA, error) {
+
+	angle = angle - math.Floor(angle/360)*360
+	srcW, srcH := img.Bounds().Dx(), img.Bounds().Dy()
+
+	switch angle {
+	case 0:
+		return bandedRows(ctx, img, imaging.Clone)
+	case 90:
+		dst := newNRGBA(srcH, srcW)
+		err := forBands(ctx, srcH, func(start int, end int) {
+			paste(dst, imaging.Rotate90(rows(img, start, end)), image.Pt(start, 0))
+		})
+		return dst, err
+	case 180:
+		dst := newNRGBA(srcW, srcH)
+		err := forBands(ctx, srcH, func(start int, end int) {
+			paste(dst, imaging.Rotate180(rows(img, start, end)), image.Pt(0, srcH-end))
+		})
+		return dst, err
+	case 270:
+		dst := newNRGBA(srcH, srcW)
+		err := forBands(ctx, srcH, func(start int, end int) {
+			paste(dst, imaging.Rotate270(rows(img, start, end)), image.Pt(srcH-end, 0))
+		})
+		return dst, err
+	}
+
+	src, err := bandedRows(ctx, img, imaging.Clone)
+	if err != nil {
+		return nil, err
+	}
+
+	dstW, dstH := rotatedSize(srcW, srcH, angle)
+	dst := newNRGBA(dstW, dstH)
+	if dstW <= 0 || dstH <= 0 {
+		return dst, nil
+	}
+
+	srcXOffset, srcYOffset := float64(srcW)/2-0.5, float64(srcH)/2-0.5
+	dstXOffset, dstYOffset := float64(dstW)/2-0.5, float64(dstH)/2-0.5
+	backgroundNRGBA := color.NRGBAModel.Convert(background).(color.NRGBA)
+	sin, cos := math.Sincos(math.Pi * angle / 180)
+
+	err = forBands(ctx, dstH, func(start int, end int) {
+		parallelRows(start, end, func(dstY int) {
+			for dstX := 0; dstX < dstW; dstX++ {
+				x, y := rotatePoint(float64(dstX)-dstXOffset, float64(dstY)-dstYOffset, sin, cos)
+				interpolatePoint(dst, dstX, dstY, src, x+srcXOffset, y+srcYOffset, backgroundNRGBA)
+			}
+		})
+	})
+
+	return dst, err
+}
+
+// parallelRows calls fn for every row in [start, end), spread over the CPUs,
+// and returns once all of them are done.
+func parallelRows(start int, end int, fn func(y int)) {
+
+	workers := min(runtime.GOMAXPROCS(0), end-start)
+	next := make(chan int, end-start)
+	for y := start; y < end; y++ {
+		next <- y
+	}
+	close(next)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range next {
+				fn(y)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// The rotation helpers below follow imaging's, so results match imaging.Rotate.
+
+func rotatePoint(x float64, y float64, sin float64, cos float64) (float64, float64) {
+	return x*cos - y*sin, x*sin + y*cos
+}
+
+func rotatedSize(w int, h int, angle float64) (int, int) {
+	if w <= 0 || h <= 0 {
+		return 0, 0
+	}
+
+	sin, cos := math.Sincos(math.Pi * angle / 180)
+	x1, y1 := rotatePoint(float64(w-1), 0, sin, cos)
+	x2, y2 := rotatePoint(float64(w-1), float64(h-1), sin, cos)
+	x3, y3 := rotatePoint(0, float64(h-1), sin, cos)
+
+	minX := math.Min(x1, math.Min(x2, math.Min(x3, 0)))
+	maxX := math.Max(x1, math.Max(x2, math.Max(x3, 0)))
+	minY := math.Min(y1, math.Min(y2, math.Min(y3, 0)))
+	maxY := math.Max(y1, math.Max(y2, math.Max(y3, 0)))
+
+	width := maxX - minX + 1
+	if width-math.Floor(width) > 0.1 {
+		width++
+	}
+	height := maxY - minY + 1
+	if height-math.Floor(height) > 0.1 {
+		height++
+	}
+
+	return int(width), int(height)
+}
+
+func interpolatePoint(dst *image.NRGBA, dstX int, dstY int, src *image.NRGBA, x float64, y float64, background color.NRGBA) {
+
+	j := dstY*dst.Stride + dstX*4
+	d := dst.Pix[j : j+4 : j+4]
+
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	bounds := src.Bounds()
+	if !image.Pt(x0, y0).In(image.Rect(bounds.Min.X-1, bounds.Min.Y-1, bounds.Max.X, bounds.Max.Y)) {
+		d[0], d[1], d[2], d[3] = background.R, background.G, background.B, background.A
+		return
+	}
+
+	xq, yq := x-float64(x0), y-float64(y0)
+	points := [4]image.Point{{x0, y0}, {x0 + 1, y0}, {x0, y0 + 1}, {x0 + 1, y0 + 1}}
+	weights := [4]float64{(1 - xq) * (1 - yq), xq * (1 - yq), (1 - xq) * yq, xq * yq}
+
+	var r, g, b, a float64
+	for i, point := range points {
+		c := background
+		if point.In(bounds) {
+			s := src.Pix[src.PixOffset(point.X, point.Y):]
+			c = color.NRGBA{R: s[0], G: s[1], B: s[2], A: s[3]}
+		}
+
+		weightedAlpha := float64(c.A) * weights[i]
+		r += float64(c.R) * weightedAlpha
+		g += float64(c.G) * weightedAlpha
+		b += float64(c.B) * weightedAlpha
+		a += weightedAlpha
+	}
+
+	if a != 0 {
+		aInv := 1 / a
+		d[0], d[1], d[2], d[3] = clamp(r*aInv), clamp(g*aInv), clamp(b*aInv), clamp(a)
+	}
+}
+
+func clamp(x float64) uint8 {
+	v := int64(x + 0.5)
+	if v > 255 {
+		return 255
+	}
+	if v > 0 {
+		return uint8(v)
+	}
+
+	return 0
+}
+
+// contextReader fails reads once ctx is done, which stops a decoder at its
+// next read instead of decoding the rest of the image.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, r.ctx.Err()
+	}
+
+	return r.r.Read(p)
+}
+
+// contextWriter does the same for encoders.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if w.ctx.Err() != nil {
+		return 0, w.ctx.Err()
+	}
+
+	return w.w.Write(p)
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// countdownContext is done once Err was asked checks times, so a transform is
+// cancelled at a band it can be told from.
+type countdownContext struct {
+	context.Context
+	checks atomic.Int64
+}
+
+func newCountdownContext(checks int) *countdownContext {
+	ctx := &countdownContext{Context: context.Background()}
+	ctx.checks.Store(int64(checks))
+	return ctx
+}
+
+func (c *countdownContext) Err() error {
+	if c.checks.Add(-1) < 0 {
+		return context.Canceled
+	}
+
+	return nil
+}
+
+// checkGoroutines fails t when goroutines started during the test are still
+// running once it is done.
+func checkGoroutines(t *testing.T) {
+	t.Helper()
+
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		// Exiting goroutines may take a moment to be gone.
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if after := runtime.NumGoroutine(); after > before {
+			t.Errorf(`got %d goroutines left running, had %d`, after, before)
+		}
+	})
+}
+
+func opaqueImage(width int, height int) *image.NRGBA {
+	return imaging.New(width, height, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+}
+
+// checkFilled fails t unless the pixels of img inside filled are set and the
+// ones outside are left zero.
+func checkFilled(t *testing.T, img *image.NRGBA, filled image.Rectangle) {
+	t.Helper()
+
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			set := img.NRGBAAt(x, y).A != 0
+			if set != image.Pt(x, y).In(filled) {
+				t.Fatalf(`got pixel %d,%d set %t, want only %v set`, x, y, set, filled)
+			}
+		}
+	}
+}
+
+func TestForBandsStopsAtBandBoundary(t *testing.T) {
+
+	var covered [][2]int
+	err := forBands(newCountdownContext(2), 1000, func(start int, end int) {
+		covered = append(covered, [2]int{start, end})
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf(`got error %v, want %v`, err, context.Canceled)
+	}
+	if len(covered) != 2 || covered[0] != [2]int{0, bandSize} || covered[1] != [2]int{bandSize, 2 * bandSize} {
+		t.Fatalf(`got bands %v, want the first two`, covered)
+	}
+}
+
+func TestTransformsStopAtBandBoundary(t *testing.T) {
+
+	// Two bands pass the check before the third one is cancelled.
+	const bands = 2
+	const done = bands * bandSize
+	src := opaqueImage(300, 1000)
+
+	transforms := []struct {
+		name      string
+		transform func(ctx context.Context) (*image.NRGBA, error)
+		filled    image.Rectangle
+	}{
+		{`grayscale`, func(ctx context.Context) (*image.NRGBA, error) {
+			return grayscaleContext(ctx, src)
+		}, image.Rect(0, 0, 300, done)},
+		{`flipH`, func(ctx context.Context) (*image.NRGBA, error) {
+			return flipHContext(ctx, src)
+		}, image.Rect(0, 0, 300, done)},
+		{`flipV`, func(ctx context.Context) (*image.NRGBA, error) {
+			return flipVContext(ctx, src)
+		}, image.Rect(0, 1000-done, 300, 1000)},
+		{`crop`, func(ctx context.Context) (*image.NRGBA, error) {
+			return cropContext(ctx, src, image.Rect(10, 10, 290, 990))
+		}, image.Rect(0, 0, 280, done)},
+		// Resizes return no partial result, only that they stopped.
+		{`resize`, func(ctx context.Context) (*image.NRGBA, error) {
+			return resizeContext(ctx, src, 150, 600, imaging.Lanczos)
+		}, image.Rectangle{}},
+		{`rotate90`, func(ctx context.Context) (*image.NRGBA, error) {
+			return rotateContext(ctx, src, 90, color.Transparent)
+		}, image.Rect(0, 0, done, 300)},
+		{`rotate180`, func(ctx context.Context) (*image.NRGBA, error) {
+			return rotateContext(ctx, src, 180, color.Transparent)
+		}, image.Rect(0, 1000-done, 300, 1000)},
+		{`rotate270`, func(ctx context.Context) (*image.NRGBA, error) {
+			return rotateContext(ctx, src, 270, color.Transparent)
+		}, image.Rect(1000-done, 0, 1000, 300)},
+	}
+
+	for _, tc := range transforms {
+		t.Run(tc.name, func(t *testing.T) {
+			checkGoroutines(t)
+
+			ctx := newCountdownContext(bands)
+			dst, err := tc.transform(ctx)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf(`got error %v, want %v`, err, context.Canceled)
+			}
+			if dst != nil {
+				checkFilled(t, dst, tc.filled)
+			}
+
+			// Cancelled transforms stop checking, they do not run on.
+			if left := ctx.checks.Load(); left < -2 {
+				t.Fatalf(`got the context checked %d times after it was done, want at most 2`, -left)
+			}
+		})
+	}
+}
+
+func TestRotateStopsAtBandBoundary(t *testing.T) {
+	checkGoroutines(t)
+
+	// The copy of the source takes a check per band and one once done, the
+	// interpolation of two bands of destination rows is let through.
+	src := opaqueImage(300, 1000)
+	copyChecks := (1000+bandSize-1)/bandSize + 1
+	ctx := newCountdownContext(copyChecks + 2)
+
+	dst, err := rotateContext(ctx, src, 30, color.Transparent)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf(`got error %v, want %v`, err, context.Canceled)
+	}
+
+	for y := 2 * bandSize; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			if dst.NRGBAAt(x, y).A != 0 {
+				t.Fatalf(`got pixel %d,%d interpolated, want rows from %d left alone`, x, y, 2*bandSize)
+			}
+		}
+	}
+}
+
+func TestApplyOperationsStopsOnDoneContext(t *testing.T) {
+	checkGoroutines(t)
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancelCtx()
+	<-ctx.Done()
+
+	operations := []Operation{{Name: `rotate`, Metadata: []byte(`{"angle":30}`)}}
+	_, _, err := ApplyOperations(ctx, opaqueImage(300, 1000), imaging.PNG, operations)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf(`got error %v, want %v`, err, context.DeadlineExceeded)
+	}
+}
+
+// noisyPNG does not compress, so it takes many reads to decode and many
+// writes to encode.
+func noisyPNG(t *testing.T) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 500, 500))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+
+	encoded := &bytes.Buffer{}
+	err := png.Encode(encoded, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encoded.Bytes()
+}
+
+// cancellingReader cancels its context once after bytes were read, past the
+// header, so decoding is cancelled halfway.
+type cancellingReader struct {
+	*bytes.Reader
+	cancel func()
+	after  int
+	read   int
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	if r.read >= r.after {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestDecodeImageStopsOnCancel(t *testing.T) {
+	checkGoroutines(t)
+
+	data := noisyPNG(t)
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	reader := &cancellingReader{Reader: bytes.NewReader(data), cancel: cancelCtx, after: 64 * 1024}
+
+	_, err := DecodeImage(ctx, reader)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf(`got error %v, want %v`, err, context.Canceled)
+	}
+	if reader.read >= len(data)/2 {
+		t.Fatalf(`got %d of %d bytes read, want decoding stopped`, reader.read, len(data))
+	}
+}
+
+// cancellingWriter cancels its context after the first write.
+type cancellingWriter struct {
+	cancel  func()
+	written int
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	w.cancel()
+	return len(p), nil
+}
+
+func TestEncodeImageStopsOnCancel(t *testing.T) {
+
+	img, err := png.Decode(bytes.NewReader(noisyPNG(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []imaging.Format{imaging.PNG, imaging.JPEG} {
+		t.Run(imageFormatName(format), func(t *testing.T) {
+			checkGoroutines(t)
+
+			ctx, cancelCtx := context.WithCancel(context.Background())
+			defer cancelCtx()
+			writer := &cancellingWriter{cancel: cancelCtx}
+
+			err := EncodeImage(ctx, writer, img, format, 90)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf(`got error %v, want %v`, err, context.Canceled)
+			}
+
+			complete := &bytes.Buffer{}
+			err = EncodeImage(context.Background(), complete, img, format, 90)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if writer.written >= complete.Len() {
+				t.Fatalf(`got %d of %d bytes written, want encoding stopped`, writer.written, complete.Len())
+			}
+		})
+	}
+}
+
+func TestEncodeImageStopsOnDoneContext(t *testing.T) {
+	checkGoroutines(t)
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancelCtx()
+	<-ctx.Done()
+
+	err := EncodeImage(ctx, io.Discard, opaqueImage(10, 10), imaging.PNG, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf(`got error %v, want %v`, err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"imageProcessorAPI/admission"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/middlewares"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/quota"
+	"imageProcessorAPI/ratelimit"
+	"imageProcessorAPI/tracing"
+	"imageProcessorAPI/utilities"
+	"io"
+	"log/slog"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	MaxBatchArchiveSize = 256 * 1024 * 1024
+	MaxBatchEntries     = 500
+	// MaxBatchUncompressedSize bounds the work of an archive. Entries are
+	// read by the workers one at a time each, so it does not bound memory.
+	MaxBatchUncompressedSize = 1024 * 1024 * 1024
+	MaxBatchWorkers          = 8
+
+	// Real photos barely compress, so anything past this ratio is treated as
+	// a zip bomb rather than an image.
+	maxBatchCompressionRatio = 100
+)
+
+type BatchManifestEntry = api.BatchManifestEntry
+
+type BatchManifest = api.BatchManifest
+
+// batchInput is read by the worker processing it, so a batch holds one input
+// per worker in memory rather than all of them.
+type batchInput struct {
+	name    string
+	open    func() (io.ReadCloser, error)
+	skipped bool
+	err     error
+}
+
+type batchResult struct {
+	index int
+	entry BatchManifestEntry
+	data  []byte
+	// cost of the pixel work done, even when the file failed.
+	cost float64
+}
+
+// Batch applies one operation chain to every image of a ZIP `archive` or of
+// several `image` parts and answers with a ZIP of the results and a
+// manifest.json describing each file. Presets are looked up in presets.
+func Batch(presets *PresetStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return batch(c, presets)
+	}
+}
+
+func batch(c *fiber.Ctx, presets *PresetStore) error {
+
+	data, err := decodePipeline(c, presets)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	scopes, _ := auth.CallerScopes(c)
+	err = scopes.Authorize(operationNames(data.Operations), data.Output.Format, 0, 0)
+	if err != nil {
+		return forbidden(c, err)
+	}
+
+	inputs, closeInputs, err := readBatchInputs(c)
+	if err != nil {
+		if IsOperationError(err) {
+			return clientError(c, err)
+		}
+
+		logging.FromContext(c.UserContext()).Error(`Could not read batch inputs. Error: `+err.Error(), slog.String(`operation`, `batch`))
+		return problem.Send(c, problem.InternalError, ``)
+	}
+
+	var megapixels float64
+	for _, input := range inputs {
+		config, err := input.config()
+		if err == nil {
+			megapixels += float64(config.Width) * float64(config.Height) / 1e6
+		}
+	}
+	c.Locals(quota.MegapixelsLocal, megapixels)
+	recordStream, _ := c.Locals(quota.RecordStreamLocal).(func(int64))
+	charge, _ := c.Locals(ratelimit.ChargeLocal).(func(float64))
+	release := func() {}
+	if hold, ok := c.Locals(admission.HoldLocal).(func() func()); ok {
+		release = hold()
+	}
+	// A client that disconnects while the archive is written stops the
+	// workers, like it stops other handlers.
+	stopWatching := func() {}
+	if hold, ok := c.Locals(middlewares.DisconnectHoldLocal).(func() func()); ok {
+		stopWatching = hold()
+	}
+
+	logging.Annotate(c.UserContext(), slog.Int(`images`, len(inputs)), slog.Float64(`megapixels`, megapixels))
+	releaseLog := logging.Hold(c.UserContext())
+
+	timeout := CurrentSettings().BatchTimeout
+	// The archive is written after the handler returned, its spans and logs
+	// still belong to the request.
+	requestCtx := c.UserContext()
+	c.Type(`zip`)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="batch.zip"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer release()
+		defer releaseLog()
+		defer stopWatching()
+		defer closeInputs()
+
+		ctx, cancelCtx := context.WithTimeout(requestCtx, timeout)
+		defer cancelCtx()
+		ctx, span := tracing.Start(ctx, `write batch archive`, attribute.Int(`batch.inputs`, len(inputs)))
+
+		counter := &countingWriter{w: w}
+		cost, err := writeBatchArchive(ctx, counter, inputs, data.Operations, data.Output, scopes)
+		tracing.End(span, &err)
+		if err != nil {
+			logging.FromContext(ctx).Error(`Could not write batch archive. Error: `+err.Error(), slog.String(`operation`, `batch`))
+		}
+
+		if charge != nil {
+			charge(cost)
+		}
+
+		metrics.OutputBytes.Add(float64(counter.written))
+		logging.Annotate(ctx, slog.Int64(`bytesOut`, counter.written))
+		if recordStream != nil {
+			recordStream(counter.written)
+		}
+	})
+
+	return nil
+}
+
+// readBatchInputs lists the uploaded images, which the workers read later.
+// The returned function closes the archive once they are done.
+func readBatchInputs(c *fiber.Ctx) ([]batchInput, func(), error) {
+
+	archive, err := c.FormFile(`archive`)
+	if err == nil {
+		if archive.Size > MaxBatchArchiveSize {
+			return nil, nil, &OperationError{Code: problem.ArchiveTooLarge, Message: `Archive size too big.`}
+		}
+
+		file, err := archive.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		inputs, err := readBatchArchive(file, archive.Size)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		return inputs, func() { file.Close() }, nil
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, nil, &OperationError{Code: problem.MissingImage, Message: `Must upload an archive or images.`}
+	}
+
+	files := form.File[`image`]
+	if len(files) == 0 {
+		return nil, nil, &OperationError{Code: problem.MissingImage, Message: `Must upload an archive or images.`}
+	}
+	if len(files) > MaxBatchEntries {
+		return nil, nil, &OperationError{Code: problem.TooManyImages, Message: `Too many images, the limit is ` + strconv.Itoa(MaxBatchEntries) + `.`}
+	}
+
+	inputs := make([]batchInput, 0, len(files))
+	for _, fileHeader := range files {
+		input := batchInput{
+			name: path.Base(strings.ReplaceAll(fileHeader.Filename, `\`, `/`)),
+			open: func() (io.ReadCloser, error) { return fileHeader.Open() },
+		}
+
+		if fileHeader.Size > utilities.MaxAllowedFileSize() {
+			input.err = &OperationError{Code: problem.FileTooLarge, Message: `File size too big.`}
+		}
+
+		inputs = append(inputs, input)
+	}
+
+	return inputs, func() {}, nil
+}
+
+// readBatchArchive lists the entries of the archive. archive/zip fails reads
+// past the uncompressed size an entry declares, so the declared sizes bound
+// what the workers will read.
+func readBatchArchive(archive io.ReaderAt, size int64) ([]batchInput, error) {
+
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, &OperationError{Code: problem.InvalidArchive, Message: `Invalid zip archive.`}
+	}
+
+	var inputs []batchInput
+	var images int
+	var totalSize uint64
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		// Listed for the caller to see why they are missing from the results.
+		if isArchiveMetadataEntry(entry.Name) {
+			inputs = append(inputs, batchInput{name: entry.Name, skipped: true})
+			continue
+		}
+
+		if images == MaxBatchEntries {
+			return nil, &OperationError{Code: problem.TooManyImages, Message: `Too many images, the limit is ` + strconv.Itoa(MaxBatchEntries) + `.`}
+		}
+		images++
+
+		name, ok := sanitizeArchiveEntryName(entry.Name)
+		if !ok {
+			inputs = append(inputs, batchInput{name: entry.Name, err: &OperationError{Code: problem.InvalidArchive, Message: `Unsafe entry path.`}})
+			continue
+		}
+
+		input := batchInput{name: name, open: entry.Open}
+		if entry.UncompressedSize64 > uint64(utilities.MaxAllowedFileSize()) {
+			input.err = &OperationError{Code: problem.FileTooLarge, Message: `File size too big.`}
+			inputs = append(inputs, input)
+			continue
+		}
+		// Content declared to take no compressed bytes at all is past any
+		// ratio, it cannot be divided by.
+		if entry.UncompressedSize64 > 0 && (entry.CompressedSize64 == 0 || entry.UncompressedSize64/entry.CompressedSize64 > maxBatchCompressionRatio) {
+			input.err = &OperationError{Code: problem.InvalidArchive, Message: `Suspicious compression ratio.`}
+			inputs = append(inputs, input)
+			continue
+		}
+
+		totalSize += entry.UncompressedSize64
+		if totalSize > MaxBatchUncompressedSize {
+			return nil, &OperationError{Code: problem.ArchiveTooLarge, Message: `Archive content too big.`}
+		}
+
+		inputs = append(inputs, input)
+	}
+
+	if images == 0 {
+		return nil, &OperationError{Code: problem.InvalidArchive, Message: `Archive has no images.`}
+	}
+
+	return inputs, nil
+}
+
+// read returns the content of the input, which must not be bigger than the
+// allowed file size whatever its upload declared.
+func (input batchInput) read() ([]byte, error) {
+
+	file, err := input.open()
+	if err != nil {
+		return nil, &OperationError{Code: problem.InvalidArchive, Message: `Could not open entry.`}
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, utilities.MaxAllowedFileSize()+1))
+	if err != nil {
+		return nil, &OperationError{Code: problem.InvalidArchive, Message: `Could not read entry.`}
+	}
+	if int64(len(data)) > utilities.MaxAllowedFileSize() {
+		return nil, &OperationError{Code: problem.FileTooLarge, Message: `File size too big.`}
+	}
+
+	return data, nil
+}
+
+// config reads the dimensions of the input from its header.
+func (input batchInput) config() (image.Config, error) {
+
+	if input.err != nil || input.skipped {
+		return image.Config{}, errors.New(`Input is not processed.`)
+	}
+
+	file, err := input.open()
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	return config, err
+}
+
+// sanitizeArchiveEntryName rejects absolute paths and anything escaping the
+// archive root, so entry names are safe to reuse as output names.
+func sanitizeArchiveEntryName(name string) (string, bool) {
+
+	name = strings.ReplaceAll(name, `\`, `/`)
+	if name == `` || strings.HasPrefix(name, `/`) || strings.Contains(name, `:`) {
+		return ``, false
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == `.` || cleaned == `..` || strings.HasPrefix(cleaned, `../`) {
+		return ``, false
+	}
+
+	return cleaned, true
+}
+
+func isArchiveMetadataEntry(name string) bool {
+	return strings.HasPrefix(name, `__MACOSX/`) || strings.HasPrefix(path.Base(name), `.`)
+}
+
+// BatchWorkers is how many images of a batch are processed at once.
+func BatchWorkers() int {
+	return min(runtime.NumCPU(), MaxBatchWorkers)
+}
+
+// writeBatchArchive returns the cost of the work done so far, also when
+// writing the archive failed.
+func writeBatchArchive(ctx context.Context, w io.Writer, inputs []batchInput, operations []Operation, output OutputOptions, scopes auth.Scopes) (float64, error) {
+
+	// Returning early, like when the client stops reading the archive, makes
+	// the workers fail the remaining inputs at once so they all exit.
+	ctx, cancelCtx := context.WithCancel(ctx)
+	defer cancelCtx()
+
+	jobs := make(chan int)
+	results := make(chan batchResult, len(inputs))
+
+	workers := min(BatchWorkers(), len(inputs))
+	for range workers {
+		go func() {
+			for index := range jobs {
+				results <- processBatchInput(ctx, index, inputs[index], operations, output, scopes)
+			}
+		}()
+	}
+	go func() {
+		for index := range inputs {
+			jobs <- index
+		}
+		close(jobs)
+	}()
+
+	zipWriter := zip.NewWriter(w)
+	manifest := BatchManifest{Files: make([]BatchManifestEntry, len(inputs))}
+	usedNames := map[string]bool{`manifest.json`: true}
+
+	var cost float64
+	for range inputs {
+		result := <-results
+		cost += result.cost
+
+		if result.entry.Success {
+			result.entry.Output = uniqueArchiveName(result.entry.Output, usedNames)
+
+			entryWriter, err := zipWriter.Create(result.entry.Output)
+			if err != nil {
+				return cost, err
+			}
+			_, err = entryWriter.Write(result.data)
+			if err != nil {
+				return cost, err
+			}
+		}
+
+		manifest.Files[result.index] = result.entry
+	}
+
+	manifestWriter, err := zipWriter.Create(`manifest.json`)
+	if err != nil {
+		return cost, err
+	}
+	err = json.NewEncoder(manifestWriter).Encode(manifest)
+	if err != nil {
+		return cost, err
+	}
+
+	return cost, zipWriter.Close()
+}
+
+// Scopes are checked per file, a file the caller may not process fails on its
+// own without failing the batch.
+func processBatchInput(ctx context.Context, index int, input batchInput, operations []Operation, output OutputOptions, scopes auth.Scopes) batchResult {
+
+	result := batchResult{index: index, entry: BatchManifestEntry{Name: input.name}}
+	fail := func(err error) batchResult {
+		var operationErr *OperationError
+		var scopeErr *auth.ScopeError
+		switch {
+		case errors.As(err, &operationErr):
+			result.entry.Code = operationErr.Code
+			result.entry.Error = operationErr.Message
+		case errors.As(err, &scopeErr):
+			result.entry.Code = problem.Forbidden
+			result.entry.Error = err.Error()
+		case errors.Is(err, context.DeadlineExceeded):
+			metrics.Timeouts.WithLabelValues(`batch`).Inc()
+			result.entry.Code = problem.ProcessingTimeout
+			result.entry.Error = `Timeout.`
+		default:
+			logging.FromContext(ctx).Error(`Could not process batch image. Error: `+err.Error(), slog.String(`operation`, `batch`), slog.String(`file`, input.name))
+			result.entry.Code = problem.InternalError
+			result.entry.Error = `Could not process image.`
+		}
+		return result
+	}
+
+	if input.skipped {
+		result.entry.Skipped = true
+		result.entry.Error = `Hidden files and macOS metadata are not processed.`
+		return result
+	}
+	if input.err != nil {
+		return fail(input.err)
+	}
+
+	format, err := ImageFormat(input.name)
+	if err != nil {
+		return fail(err)
+	}
+
+	if ctx.Err() != nil {
+		return fail(ctx.Err())
+	}
+
+	data, err := input.read()
+	if err != nil {
+		return fail(err)
+	}
+
+	decodedImage, err := DecodeImage(ctx, bytes.NewReader(data))
+	if err != nil {
+		return fail(err)
+	}
+
+	err = scopes.Authorize(nil, ``, decodedImage.Bounds().Dx(), decodedImage.Bounds().Dy())
+	if err != nil {
+		return fail(err)
+	}
+
+	processedImage, format, err := ApplyOperations(ctx, decodedImage, format, operations)
+	if err != nil {
+		return fail(err)
+	}
+	result.cost = workCost(operationNames(operations), decodedImage, processedImage)
+	metrics.ObserveWork(megapixels(decodedImage), megapixels(processedImage))
+
+	format = ResolveFormat(output, format)
+	err = scopes.Authorize(nil, imageFormatName(format), processedImage.Bounds().Dx(), processedImage.Bounds().Dy())
+	if err != nil {
+		return fail(err)
+	}
+
+	var buffer bytes.Buffer
+	err = EncodeImage(ctx, &buffer, processedImage, format, output.Quality)
+	if err != nil {
+		return fail(err)
+	}
+
+	result.data = buffer.Bytes()
+	result.entry.Success = true
+	result.entry.Output = strings.TrimSuffix(input.name, path.Ext(input.name)) + FormatExtension(format)
+	result.entry.Width = processedImage.Bounds().Dx()
+	result.entry.Height = processedImage.Bounds().Dy()
+
+	return result
+}
+
+func uniqueArchiveName(name string, used map[string]bool) string {
+
+	candidate := name
+	extension := path.Ext(name)
+	for i := 1; used[candidate]; i++ {
+		candidate = strings.TrimSuffix(name, extension) + `_` + strconv.Itoa(i) + extension
+	}
+	used[candidate] = true
+
+	return candidate
+}
+
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+
+	return n, err
+}
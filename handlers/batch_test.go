@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"imageProcessorAPI/problem"
+	"testing"
+)
+
+func TestReadBatchArchiveRejectsSuspiciousRatios(t *testing.T) {
+
+	archive := &bytes.Buffer{}
+	writer := zip.NewWriter(archive)
+	entries := []struct {
+		name         string
+		compressed   []byte
+		uncompressed uint64
+	}{
+		{`photo.png`, bytes.Repeat([]byte{1}, 100), 1000},
+		{`bomb.png`, bytes.Repeat([]byte{1}, 10), 1 << 20},
+		// No compressed bytes at all, there is nothing to divide by.
+		{`empty-bomb.png`, nil, 1 << 20},
+		{`empty.png`, nil, 0},
+	}
+	for _, entry := range entries {
+		raw, err := writer.CreateRaw(&zip.FileHeader{
+			Name:               entry.name,
+			Method:             zip.Deflate,
+			CompressedSize64:   uint64(len(entry.compressed)),
+			UncompressedSize64: entry.uncompressed,
+		})
+		if err == nil {
+			_, err = raw.Write(entry.compressed)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := readBatchArchive(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{`photo.png`: false, `bomb.png`: true, `empty-bomb.png`: true, `empty.png`: false}
+	for _, input := range inputs {
+		var operationErr *OperationError
+		suspicious := errors.As(input.err, &operationErr) && operationErr.Code == problem.InvalidArchive
+		if suspicious != want[input.name] {
+			t.Fatalf(`got %s rejected %t, want %t`, input.name, suspicious, want[input.name])
+		}
+	}
+	if len(inputs) != len(want) {
+		t.Fatalf(`got %d inputs, want %d`, len(inputs), len(want))
+	}
+}
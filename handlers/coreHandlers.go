@@ -3,736 +3,154 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"image"
-	"image/color"
-	"imageProcessorAPI/utilities"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/validation"
 	"log/slog"
-	"path/filepath"
 	"strings"
-	"time"
 
-	"github.com/disintegration/imaging"
 	"github.com/gofiber/fiber/v2"
 )
 
-type RotateBody struct {
-	Angle *int `json:"angle"`
-}
+type RotateBody = api.RotateBody
 
 func Rotate(c *fiber.Ctx) error {
-
-	ctx, cancelCtx := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancelCtx()
-
-	body := c.FormValue(`metadata`)
-	if body == `` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Angle must be set.`})
-	}
-	bodyData := RotateBody{}
-	err := json.Unmarshal([]byte(body), &bodyData)
-	if err != nil {
-		slog.Error(`Could not unmarshal rotate body. Error: ` + err.Error())
-		return fiber.NewError(500, `Something went wrong.`)
-	}
-
-	if bodyData.Angle == nil {
-		slog.Info(`Angle is nil in rotate handler.`)
-		return c.Status(400).JSON(fiber.Map{`message`: `Angle must be set.`})
-	}
-
-	file, err := c.FormFile(`image`)
-	if err != nil {
-		return fiber.NewError(500, `Could not open image.`)
-	}
-	mimeType := file.Header.Get("Content-Type")
-
-	if mimeType != "image/jpeg" && mimeType != "image/png" {
-		return c.Status(fiber.StatusBadRequest).SendString("Unsupported file type: " + mimeType)
-	}
-
-	fileExt := strings.ToLower(filepath.Ext(file.Filename))
-	if fileExt != `png` && fileExt != `jpeg` && fileExt != `jpg` {
-		return fiber.NewError(400, `Expected png or jpg image.`)
-	}
-
-	fileStream, err := file.Open()
-	if err != nil {
-		return fiber.NewError(500, `Could not open image.`)
-	}
-	defer fileStream.Close()
-
-	var errChan = make(chan error)
-	var imageChan = make(chan image.Image)
-	go func() {
-		decodedImage, err := imaging.Decode(fileStream)
-
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		imageChan <- decodedImage
-	}()
-
-	var decodedImage image.Image
-	select {
-	case img := <-imageChan:
-		decodedImage = img
-	case err := <-errChan:
-		slog.Error(`Could not decode image. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	case <-ctx.Done():
-		slog.Info(`Context canceled in rotate handler.`)
-		return c.Status(fiber.ErrRequestTimeout.Code).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	validImageBounds := utilities.CheckImageBounds(&decodedImage)
-
-	if !validImageBounds {
-		return c.Status(400).JSON(fiber.Map{`message`: `Image bound too big.`})
-	}
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	rotatedImage := imaging.Rotate(decodedImage, float64(*bodyData.Angle), color.White)
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	writer := c.Response().BodyWriter()
-	if fileExt == `png` {
-		c.Type(`image/png`)
-		err = imaging.Encode(writer, rotatedImage, imaging.PNG)
-	} else {
-		c.Type(`image/jpeg`)
-		err = imaging.Encode(writer, rotatedImage, imaging.JPEG, imaging.JPEGQuality(85))
-	}
-
-	if err != nil {
-		if ctx.Err() != nil{
-			slog.Error(`Encode failed due to context timeout. Error: ` + err.Error());
-			return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`:`Timeout.`});
-		}
-
-		slog.Error(`Could not encode image in rotate handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
-
-
-	return nil
+	return processUpload(c, Operation{Name: `rotate`, Metadata: json.RawMessage(c.FormValue(`metadata`))})
 }
 
-type CropMetaData struct {
-	MinX *int `json:"minX"`
-	MinY *int `json:"minY"`
-
-	MaxX *int `json:"maxX"`
-	MaxY *int `json:"maxY"`
-}
+// CropMetaData is the rectangle kept, from MinX, MinY included to MaxX, MaxY
+// excluded.
+type CropMetaData = api.CropMetaData
 
 func Crop(c *fiber.Ctx) error {
-
-	ctx, cancelCtx := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancelCtx()
-
-	metadata := c.FormValue(`metadata`)
-	if metadata == `` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Must set bounds to crop image.`})
-	}
-	data := CropMetaData{}
-	err := json.Unmarshal([]byte(metadata), &data)
-	if err != nil {
-		slog.Error(`Could not unmarshal metadata in crop handler. Error: ` + err.Error())
-		return fiber.NewError(500, `Something went wrong.`)
-	}
-
-	if data.MaxX == nil || data.MinX == nil || data.MinY == nil || data.MaxY == nil {
-		return c.Status(400).JSON(fiber.Map{`message`: `Must set bounds to crop the image.`})
-	}
-
-	if *data.MaxX < *data.MinX || *data.MaxY < *data.MinY {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid bounds.`})
-	}
-
-	if *data.MaxX < 0 || *data.MinX < 0 || *data.MaxY < 0 || *data.MinY < 0 {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid bounds.`})
-	}
-
-	rec := image.Rectangle{
-		Min: image.Point{
-			X: *data.MinX,
-			Y: *data.MinY,
-		},
-		Max: image.Point{
-			X: *data.MaxX,
-			Y: *data.MaxY,
-		},
-	}
-
-	file, err := c.FormFile(`image`)
-	if err != nil {
-		return fiber.NewError(500, `Could not open image.`)
-	}
-
-	mimeType := file.Header.Get("Content-Type")
-
-	if mimeType != "image/jpeg" && mimeType != "image/png" {
-		return c.Status(fiber.StatusBadRequest).SendString("Unsupported file type: " + mimeType)
-	}
-
-	fileExt := strings.ToLower(filepath.Ext(file.Filename))
-	if fileExt != `png` && fileExt != `jpeg` && fileExt != `jpg` {
-		return fiber.NewError(400, `Expected png or jpg image.`)
-	}
-
-	fileStream, err := file.Open()
-	if err != nil {
-		return fiber.NewError(500, `Could not open image.`)
-	}
-	defer fileStream.Close()
-
-	var errChan = make(chan error)
-	var imageChan = make(chan image.Image)
-	go func() {
-		decodedImage, err := imaging.Decode(fileStream)
-
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		imageChan <- decodedImage
-	}()
-
-	var decodedImage image.Image
-	select {
-	case img := <-imageChan:
-		decodedImage = img
-	case err := <-errChan:
-		slog.Error(`Could not decode image. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	case <-ctx.Done():
-		slog.Info(`Context canceled in rotate handler.`)
-		return c.Status(fiber.ErrRequestTimeout.Code).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	validImageBounds := utilities.CheckImageBounds(&decodedImage)
-
-	if !validImageBounds {
-		return c.Status(400).JSON(fiber.Map{`message`: `Image bound too big.`})
-	}
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	if !rec.In(decodedImage.Bounds()) {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid bounds.`})
-	}
-
-	croppedImage := imaging.Crop(decodedImage, rec)
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	bodyWriter := c.Response().BodyWriter()
-
-	if fileExt == `png` {
-		c.Type(`image/png`)
-		err = imaging.Encode(bodyWriter, croppedImage, imaging.PNG)
-	} else {
-		c.Type(`image/jpeg`)
-		err = imaging.Encode(bodyWriter, croppedImage, imaging.JPEG, imaging.JPEGQuality(85))
-	}
-if err != nil {
-		if ctx.Err() != nil{
-			slog.Error(`Encode failed due to context timeout. Error: ` + err.Error());
-			return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`:`Timeout.`});
-		}
-
-		slog.Error(`Could not encode image in crop handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
-
-	return nil
+	return processUpload(c, Operation{Name: `crop`, Metadata: json.RawMessage(c.FormValue(`metadata`))})
 }
 
-type ResizeMetaData struct {
-	Height *int `json:"height"`
-	Width  *int `json:"width"`
-}
+// ResizeMetaData keeps the aspect ratio when only one of Width and Height is
+// set.
+type ResizeMetaData = api.ResizeMetaData
 
 func Resize(c *fiber.Ctx) error {
+	return processUpload(c, Operation{Name: `resize`, Metadata: json.RawMessage(c.FormValue(`metadata`))})
+}
 
-	ctx, cancelCtx := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancelCtx()
-
-	metadata := c.FormValue(`metadata`)
+type ChangeFormatMetadata = api.ChangeFormatMetadata
 
-	if metadata == `` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Must set height or width to resize image.`})
-	}
+func ChangeFormat(c *fiber.Ctx) error {
 
-	data := ResizeMetaData{}
-	err := json.Unmarshal([]byte(metadata), &data)
+	operation := Operation{Name: `changeformat`, Metadata: json.RawMessage(c.FormValue(`metadata`))}
+	metadata, err := decodeMetadata(operation)
 	if err != nil {
-		slog.Error(`Could not unmarshal metadata in resize handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
-
-	if data.Width == nil && data.Height == nil {
-		return c.Status(400).JSON(fiber.Map{`message`: `Must set at least width or height to resize image.`})
+		return clientError(c, err)
 	}
 
+	// Converting an image to the format it already has is a client mistake.
 	fileHeader, err := c.FormFile(`image`)
-	if err != nil {
-		slog.Error(`Could not get form file in resize handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
-
-	mimeType := fileHeader.Header.Get("Content-Type")
-
-	if mimeType != "image/jpeg" && mimeType != "image/png" {
-		return c.Status(fiber.StatusBadRequest).SendString("Unsupported file type: " + mimeType)
-	}
-
-	extension := strings.ToLower(filepath.Ext(fileHeader.Filename))
-
-	if extension != `png` && extension != `jpeg` && extension != `jpg` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid extension.`})
-	}
-
-	fileStream, err := fileHeader.Open()
-	if err != nil {
-		slog.Error(`Could not open image in resize handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
-	defer fileStream.Close()
-
-	var errChan = make(chan error)
-	var imageChan = make(chan image.Image)
-	go func() {
-		decodedImage, err := imaging.Decode(fileStream)
-
-		if err != nil {
-			errChan <- err
-			return
+	if err == nil {
+		format, err := ImageFormat(fileHeader.Filename)
+		if err == nil && imageFormatName(format) == normalizedFormatName(*metadata.(*ChangeFormatMetadata).FormatName) {
+			return problem.SendFields(c, problem.InvalidParameters, `Image is already in this format.`, validation.Errors{
+				{Field: `formatName`, Reason: `must differ from the format of the image`},
+			})
 		}
-
-		imageChan <- decodedImage
-	}()
-
-	var decodedImage image.Image
-	select {
-	case img := <-imageChan:
-		decodedImage = img
-	case err := <-errChan:
-		slog.Error(`Could not decode image. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	case <-ctx.Done():
-		slog.Info(`Context canceled in rotate handler.`)
-		return c.Status(fiber.ErrRequestTimeout.Code).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	validImageBounds := utilities.CheckImageBounds(&decodedImage)
-
-	if !validImageBounds {
-		return c.Status(400).JSON(fiber.Map{`message`: `Image bound too big.`})
-	}
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	var resizedImage *image.NRGBA
-	if data.Width == nil && data.Height != nil && *data.Height > 0 && *data.Height < 2000 {
-		resizedImage = imaging.Resize(decodedImage, 0, *data.Height, imaging.Lanczos)
-	} else if data.Height == nil && data.Width != nil && *data.Width > 0 && *data.Width < 2000 {
-		resizedImage = imaging.Resize(decodedImage, *data.Width, 0, imaging.Lanczos)
-	} else if data.Height != nil && *data.Height > 0 && data.Width != nil && *data.Width > 0 && *data.Height < 2000 && *data.Width < 2000 {
-		resizedImage = imaging.Resize(decodedImage, *data.Width, *data.Height, imaging.Lanczos)
-	} else {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid parameters.`})
-	}
-
-	writer := c.Request().BodyWriter()
-
-	if extension == `png` {
-		c.Type(`image/png`)
-
-		err = imaging.Encode(writer, resizedImage, imaging.PNG)
-	} else {
-		c.Type(`image/jpeg`)
-
-		err = imaging.Encode(writer, resizedImage, imaging.JPEG, imaging.JPEGQuality(85))
 	}
 
-	if err != nil {
-		if ctx.Err() != nil{
-			slog.Error(`Encode failed due to context timeout. Error: ` + err.Error());
-			return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`:`Timeout.`});
-		}
+	return processUpload(c, operation)
+}
 
-		slog.Error(`Could not encode image in resize handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
+type FlipMetadata = api.FlipMetadata
 
-	return nil
+func Flip(c *fiber.Ctx) error {
+	return processUpload(c, Operation{Name: `flip`, Metadata: json.RawMessage(c.FormValue(`metadata`))})
 }
 
-type ChangeFormatMetadata struct {
-	FormatName *string `json:"formatName"`
+func GrayScale(c *fiber.Ctx) error {
+	return processUpload(c, Operation{Name: `grayscale`})
 }
 
-func ChangeFormat(c *fiber.Ctx) error {
-
-	ctx, cancelCtx := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancelCtx()
+// processUpload runs one operation on the `image` upload and answers with the
+// result, in the format the operation produced.
+func processUpload(c *fiber.Ctx, operation Operation) error {
 
-	jsonPayload := c.FormValue(`metadata`)
-	var metadata = ChangeFormatMetadata{}
-	err := json.Unmarshal([]byte(jsonPayload), &metadata)
+	// The metadata is checked before the image is decoded.
+	_, err := decodeMetadata(operation)
 	if err != nil {
-		slog.Error(`Could not unmarshal in change format handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
+		return clientError(c, err)
 	}
 
-	if metadata.FormatName == nil {
-		return c.Status(400).JSON(fiber.Map{`message`: `Must set format name.`})
-	}
+	return processChain(c, operation.Name, []Operation{operation}, OutputOptions{})
+}
 
-	formatName := strings.ToLower(*metadata.FormatName)
+// processChain runs a valid operation chain on the `image` upload and answers
+// with the result encoded with the output options, handler names the route
+// in logs and metrics. Decoding, the chain and encoding all stop once the
+// request is cancelled or times out.
+func processChain(c *fiber.Ctx, handler string, operations []Operation, output OutputOptions) error {
 
-	if formatName != `png` && formatName != `jpeg` && formatName != `jpg` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid format name.`})
-	}
+	ctx, cancelCtx := context.WithTimeout(c.UserContext(), CurrentSettings().ProcessingTimeout)
+	defer cancelCtx()
 
-	fileheader, err := c.FormFile(`image`)
+	fileHeader, err := c.FormFile(`image`)
 	if err != nil {
-		slog.Error(`Could not get form file image in change format handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
+		return problem.Send(c, problem.MissingImage, `Must upload an image.`)
 	}
 
-	mimeType := fileheader.Header.Get("Content-Type")
-
+	mimeType := fileHeader.Header.Get("Content-Type")
 	if mimeType != "image/jpeg" && mimeType != "image/png" {
-		return c.Status(fiber.StatusBadRequest).SendString("Unsupported file type: " + mimeType)
-	}
-
-	extension := strings.ToLower(filepath.Ext(fileheader.Filename))
-	if extension != `png` && extension != `jpeg` || extension != `jpeg` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid file type.`})
+		return problem.Send(c, problem.UnsupportedFormat, `Unsupported file type: `+mimeType)
 	}
 
-	if extension == formatName {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid request.`})
+	format, err := ImageFormat(fileHeader.Filename)
+	if err != nil {
+		return clientError(c, err)
 	}
 
-	file, err := fileheader.Open()
+	file, err := fileHeader.Open()
 	if err != nil {
-		slog.Error(`Could not open file in change format handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
+		logging.FromContext(c.UserContext()).Error(`Could not open image. Error: `+err.Error(), slog.String(`operation`, handler))
+		return problem.Send(c, problem.InternalError, ``)
 	}
 	defer file.Close()
 
-	var errChan = make(chan error)
-	var imageChan = make(chan image.Image)
-	go func() {
-		decodedImage, err := imaging.Decode(file)
-
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		imageChan <- decodedImage
-	}()
-
-	var image image.Image
-	select {
-	case img := <-imageChan:
-		image = img
-	case err := <-errChan:
-		slog.Error(`Could not decode image. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	case <-ctx.Done():
-		slog.Info(`Context canceled in rotate handler.`)
-		return c.Status(fiber.ErrRequestTimeout.Code).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	validImageBounds := utilities.CheckImageBounds(&image)
-	if !validImageBounds {
-		return c.Status(400).JSON(fiber.Map{`message`: `Image bound too big.`})
-	}
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	responseWriter := c.Response().BodyWriter()
-
-	if formatName == `png` {
-		c.Type(`image/png`)
-		err = imaging.Encode(responseWriter, image, imaging.PNG)
-	} else {
-		c.Type(`image/jpeg`)
-
-		err = imaging.Encode(responseWriter, image, imaging.JPEG, imaging.JPEGQuality(85))
-	}
+	decodedImage, err := DecodeImage(ctx, file)
 	if err != nil {
-		if ctx.Err() != nil {
-			slog.Error(`Encode failed due to context timeout. Error: ` + err.Error())
-			return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-		}
-
-		slog.Error(`Could not encode image in change format handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
+		return processingError(c, err, handler)
 	}
 
-	return nil
-}
-
-type FlipMetadata struct {
-	Direction *string `json:"direction"`
-}
-
-func Flip(c *fiber.Ctx) error {
-
-	ctx, cancelCtx := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancelCtx()
-
-	metadata := c.FormValue(`metadata`)
-	if metadata == `` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Must give direction to flip image.`})
-	}
-
-	data := FlipMetadata{}
-
-	err := json.Unmarshal([]byte(metadata), &data)
+	names := operationNames(operations)
+	err = authorize(c, names, imageFormatName(format), decodedImage.Bounds().Dx(), decodedImage.Bounds().Dy())
 	if err != nil {
-		slog.Error(`Could not unmarshal in flip handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
-
-	if data.Direction == nil {
-		return c.Status(400).JSON(fiber.Map{`message`: `Must set direction to flip image.`})
-	}
-
-	direction := strings.ToLower(*data.Direction)
-	if direction != `horizontal` && direction != `vertical` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Direction of flip must be either horizontal or vertical.`})
+		return forbidden(c, err)
 	}
 
-	fileheader, err := c.FormFile(`image`)
+	processedImage, format, err := ApplyOperations(ctx, decodedImage, format, operations)
 	if err != nil {
-		slog.Error(`Could not get form file in flip handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
+		return processingError(c, err, handler)
 	}
+	recordWork(c, names, decodedImage, processedImage)
 
-	mimeType := fileheader.Header.Get("Content-Type")
-
-	if mimeType != "image/jpeg" && mimeType != "image/png" {
-		return c.Status(fiber.StatusBadRequest).SendString("Unsupported file type: " + mimeType)
-	}
-
-	ext := strings.ToLower(filepath.Ext(fileheader.Filename))
-	if ext != `png` && ext != `jpeg` && ext != `jpg` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid file type.`})
-	}
-
-	file, err := fileheader.Open()
+	format = ResolveFormat(output, format)
+	err = authorize(c, nil, imageFormatName(format), processedImage.Bounds().Dx(), processedImage.Bounds().Dy())
 	if err != nil {
-		slog.Error(`Could not open file in flip handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
-	defer file.Close()
-
-	var errChan = make(chan error)
-	var imageChan = make(chan image.Image)
-	go func() {
-		decodedImage, err := imaging.Decode(file)
-
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		imageChan <- decodedImage
-	}()
-
-	var image image.Image
-	select {
-	case img := <-imageChan:
-		image = img
-	case err := <-errChan:
-		slog.Error(`Could not decode image. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	case <-ctx.Done():
-		slog.Info(`Context canceled in rotate handler.`)
-		return c.Status(fiber.ErrRequestTimeout.Code).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	validImageBounds := utilities.CheckImageBounds(&image)
-
-	if !validImageBounds {
-		return c.Status(400).JSON(fiber.Map{`message`: `Image bound too big.`})
+		return forbidden(c, err)
 	}
 
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-	responseWriter := c.Response().BodyWriter()
-
-	if direction == `horizontal` {
-
-		flippedImage := imaging.FlipH(image)
-
-		if ext == `png` {
-			c.Type(`image/png`)
-
-			err = imaging.Encode(responseWriter, flippedImage, imaging.PNG)
-		} else {
-			c.Type(`image/jpeg`)
-
-			err = imaging.Encode(responseWriter, flippedImage, imaging.JPEG, imaging.JPEGQuality(85))
-		}
-		if err != nil {
-			if ctx.Err() != nil {
-				slog.Error(`Encode failed due to context timeout. Error: ` + err.Error())
-				return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-			}
-
-			slog.Error(`Could not encode image in flip handler. Error: ` + err.Error())
-			return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-		}
-
-	} else if direction == `vertical` {
-		flippedImage := imaging.FlipV(image)
-		if ext == `png` {
-			c.Type(`image/png`)
-
-			err = imaging.Encode(responseWriter, flippedImage, imaging.PNG)
-		} else {
-			c.Type(`image/jpeg`)
-
-			err = imaging.Encode(responseWriter, flippedImage, imaging.JPEG, imaging.JPEGQuality(85))
-		}
-
-		if err != nil {
-			if ctx.Err() != nil {
-				slog.Error(`Encode failed due to context timeout. Error: ` + err.Error())
-				return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-			}
-
-			slog.Error(`Could not encode image in flip handler. Error: ` + err.Error())
-			return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-		}
-
+	c.Set(fiber.HeaderContentType, FormatContentType(format))
+	err = EncodeImage(ctx, c.Response().BodyWriter(), processedImage, format, output.Quality)
+	if err != nil {
+		c.Response().ResetBody()
+		return processingError(c, err, handler)
 	}
 
 	return nil
 }
 
-func GrayScale(c *fiber.Ctx) error {
-
-	ctx, cancelCtx := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancelCtx()
-
-	fileheader, err := c.FormFile(`image`)
-	if err != nil {
-		slog.Error(`Could not get form file in gray scale handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
+func normalizedFormatName(format string) string {
+	format = strings.ToLower(format)
+	if format == `jpg` {
+		return `jpeg`
 	}
 
-	mimeType := fileheader.Header.Get("Content-Type")
-
-	if mimeType != "image/jpeg" && mimeType != "image/png" {
-		return c.Status(fiber.StatusBadRequest).SendString("Unsupported file type: " + mimeType)
-	}
-
-	ext := strings.ToLower(filepath.Ext(fileheader.Filename))
-
-	if ext != `png` && ext != `jpeg` && ext != `jpg` {
-		return c.Status(400).JSON(fiber.Map{`message`: `Invalid file format.`})
-	}
-
-	file, err := fileheader.Open()
-	if err != nil {
-		slog.Error(`Could not open file in gray scale handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
-	defer file.Close()
-
-	var errChan = make(chan error)
-	var imageChan = make(chan image.Image)
-	go func() {
-		decodedImage, err := imaging.Decode(file)
-
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		imageChan <- decodedImage
-	}()
-
-	var image image.Image
-	select {
-	case img := <-imageChan:
-		image = img
-	case err := <-errChan:
-		slog.Error(`Could not decode image. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	case <-ctx.Done():
-		slog.Info(`Context canceled in rotate handler.`)
-		return c.Status(fiber.ErrRequestTimeout.Code).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	validImageBounds := utilities.CheckImageBounds(&image)
-
-	if !validImageBounds {
-		return c.Status(400).JSON(fiber.Map{`message`: `Image bound too big.`})
-	}
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-	grayImage := imaging.Grayscale(image)
-	responseWriter := c.Response().BodyWriter()
-
-	if ctx.Err() != nil {
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-	}
-
-	if ext == `png` {
-		c.Type(`image/png`)
-		err = imaging.Encode(responseWriter, grayImage, imaging.PNG)
-	} else {
-		c.Type(`image/jpeg`)
-		err = imaging.Encode(responseWriter, grayImage, imaging.JPEG, imaging.JPEGQuality(85))
-	}
-
-	if err != nil {
-		if ctx.Err() != nil {
-			slog.Error(`Encode failed due to context timeout. Error: ` + err.Error())
-			return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{`message`: `Timeout.`})
-		}
-
-		slog.Error(`Could not encode image in gray  scale handler. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`: `Something went wrong.`})
-	}
-
-	return nil
+	return format
 }
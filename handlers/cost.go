@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"image"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/ratelimit"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// recordWork adds the cost of turning input into output to the request's
+// cost budget charge, when a cost limit is enforced.
+func recordWork(c *fiber.Ctx, operations []string, input image.Image, output image.Image) {
+	metrics.ObserveWork(megapixels(input), megapixels(output))
+	logging.Annotate(c.UserContext(),
+		slog.String(`operations`, strings.Join(operations, `,`)),
+		slog.String(`input`, dimensions(input)),
+		slog.String(`output`, dimensions(output)),
+	)
+
+	cost, ok := c.Locals(ratelimit.CostLocal).(*float64)
+	if !ok {
+		return
+	}
+
+	*cost += workCost(operations, input, output)
+}
+
+func workCost(operations []string, input image.Image, output image.Image) float64 {
+	return ratelimit.Cost(operations, megapixels(input), megapixels(output))
+}
+
+func megapixels(img image.Image) float64 {
+	if img == nil {
+		return 0
+	}
+
+	return float64(img.Bounds().Dx()) * float64(img.Bounds().Dy()) / 1e6
+}
+
+// dimensions are logged like 800x600.
+func dimensions(img image.Image) string {
+	return strconv.Itoa(img.Bounds().Dx()) + `x` + strconv.Itoa(img.Bounds().Dy())
+}
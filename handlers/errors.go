@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// StatusClientClosedRequest is logged for requests whose client went away
+// before the response was ready. Nobody is left to read it.
+const StatusClientClosedRequest = 499
+
+// processingError answers for an error from decoding, transforming or
+// encoding an image in the named handler.
+func processingError(c *fiber.Ctx, err error, handler string) error {
+	logger := logging.FromContext(c.UserContext()).With(slog.String(`operation`, handler))
+
+	switch {
+	case IsOperationError(err):
+		return clientError(c, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		metrics.Timeouts.WithLabelValues(handler).Inc()
+		return problem.Send(c, problem.ProcessingTimeout, `Timeout.`)
+	case errors.Is(err, context.Canceled):
+		logger.Info(`Client went away before the image was processed.`)
+		return c.SendStatus(StatusClientClosedRequest)
+	}
+
+	logger.Error(`Could not process image. Error: ` + err.Error())
+	return problem.Send(c, problem.InternalError, ``)
+}
+
+// clientError answers for a mistake of the client with the code of its
+// OperationError.
+func clientError(c *fiber.Ctx, err error) error {
+	var operationErr *OperationError
+	if errors.As(err, &operationErr) {
+		return problem.SendFields(c, operationErr.Code, operationErr.Message, operationErr.Fields)
+	}
+
+	return problem.Send(c, problem.InvalidRequest, err.Error())
+}
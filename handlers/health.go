@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"imageProcessorAPI/health"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReadinessResponse struct {
+	Status string `json:"status"`
+	// Checks holds the error of every dependency that is not ready.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Liveness answers as long as the server can serve requests at all, it does
+// not look at dependencies so their outages do not get the server restarted.
+func Liveness(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{`status`: `ok`})
+}
+
+// Readiness fails while the server drains or a dependency checked by checker
+// is down, so load balancers send requests elsewhere.
+func Readiness(checker *health.Checker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		failures := checker.Ready(c.UserContext())
+		if len(failures) == 0 {
+			return c.JSON(ReadinessResponse{Status: `ready`})
+		}
+
+		checks := map[string]string{}
+		for name, err := range failures {
+			checks[name] = err.Error()
+		}
+
+		status := `unavailable`
+		if checker.Draining() {
+			status = `draining`
+		}
+
+		return c.Status(fiber.StatusServiceUnavailable).JSON(ReadinessResponse{Status: status, Checks: checks})
+	}
+}
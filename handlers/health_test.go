@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"imageProcessorAPI/health"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+
+	checker := health.NewChecker()
+
+	app := fiber.New()
+	app.Get(`/healthz`, Liveness)
+	app.Get(`/readyz`, Readiness(checker))
+
+	probe := func(path string) (int, ReadinessResponse) {
+		t.Helper()
+
+		response, err := app.Test(httptest.NewRequest(`GET`, path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		readiness := ReadinessResponse{}
+		err = json.NewDecoder(response.Body).Decode(&readiness)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return response.StatusCode, readiness
+	}
+
+	status, readiness := probe(`/readyz`)
+	if status != fiber.StatusOK || readiness.Status != `ready` {
+		t.Fatalf(`got status %d and %+v, want ready`, status, readiness)
+	}
+
+	down := errors.New(`Connection refused.`)
+	healthy := true
+	checker.Add(`redis`, func(ctx context.Context) error {
+		if healthy {
+			return nil
+		}
+		return down
+	})
+	healthy = false
+	status, readiness = probe(`/readyz`)
+	if status != fiber.StatusServiceUnavailable || readiness.Status != `unavailable` || readiness.Checks[`redis`] != down.Error() {
+		t.Fatalf(`got status %d and %+v, want the failing check reported`, status, readiness)
+	}
+
+	// Draining fails readiness for good, even with every dependency up, while
+	// the server stays alive.
+	healthy = true
+	checker.Drain()
+	status, readiness = probe(`/readyz`)
+	if status != fiber.StatusServiceUnavailable || readiness.Status != `draining` || readiness.Checks[`draining`] != health.ErrDraining.Error() {
+		t.Fatalf(`got status %d and %+v, want draining`, status, readiness)
+	}
+	status, readiness = probe(`/healthz`)
+	if status != fiber.StatusOK || readiness.Status != `ok` {
+		t.Fatalf(`got status %d and %+v, want the server alive`, status, readiness)
+	}
+}
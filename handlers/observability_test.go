@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/middlewares"
+	"imageProcessorAPI/problem"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// recordLogs makes the default logger write JSON lines to the returned
+// buffer until the test is done.
+func recordLogs(t *testing.T) *bytes.Buffer {
+
+	lines := &bytes.Buffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(lines, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return lines
+}
+
+func TestProcessIsMeasuredAndLogged(t *testing.T) {
+
+	lines := recordLogs(t)
+
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler})
+	app.Use(middlewares.Instrument(app))
+	app.Get(`/metrics`, metrics.Handler())
+	app.Use(middlewares.RequestID)
+	app.Use(middlewares.AccessLog(app))
+	app.Post(`/v2/process`, Process(&PresetStore{}))
+
+	body, contentType := uploadForm(t, map[string]string{`metadata`: `{"operations":[{"name":"resize","metadata":{"width":20}}]}`})
+	request := httptest.NewRequest(`POST`, `/v2/process`, body)
+	request.Header.Set(fiber.HeaderContentType, contentType)
+	request.Header.Set(fiber.HeaderXRequestID, `observed-1`)
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != fiber.StatusOK || response.Header.Get(fiber.HeaderXRequestID) != `observed-1` {
+		t.Fatalf(`got status %d and request ID %q, want the image processed`, response.StatusCode, response.Header.Get(fiber.HeaderXRequestID))
+	}
+
+	// One access log line, with the request, the caller and the phases of
+	// the image.
+	var entry map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(lines.Bytes()), []byte("\n")) {
+		logged := map[string]any{}
+		err = json.Unmarshal(line, &logged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logged[`msg`] == `Handled request.` {
+			if entry != nil {
+				t.Fatalf(`got %s, want one access log line`, lines)
+			}
+			entry = logged
+		}
+	}
+	fields := map[string]any{
+		`requestID`: `observed-1`,
+		`method`:    `POST`,
+		`route`:     `/v2/process`,
+		`path`:      `/v2/process`,
+		`status`:    float64(fiber.StatusOK),
+	}
+	for field, want := range fields {
+		if entry[field] != want {
+			t.Fatalf(`got %s %v in %v, want %v`, field, entry[field], entry, want)
+		}
+	}
+	for _, field := range []string{`client`, `duration`, `bytesIn`, `bytesOut`} {
+		if _, ok := entry[field]; !ok {
+			t.Fatalf(`got %v, want the %s field`, entry, field)
+		}
+	}
+	phases, _ := entry[`phases`].(map[string]any)
+	for _, phase := range []string{`decode`, `transform`, `encode`} {
+		if _, ok := phases[phase]; !ok {
+			t.Fatalf(`got phases %v, want %s`, entry[`phases`], phase)
+		}
+	}
+
+	// The scrape has the request and what processing it took.
+	response, err = app.Test(httptest.NewRequest(`GET`, `/metrics`, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	scraped, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, series := range []string{
+		`imageprocessor_http_requests_total{method="POST",route="/v2/process",status="200"}`,
+		`imageprocessor_http_request_duration_seconds_count{method="POST",route="/v2/process",status="200"}`,
+		`imageprocessor_http_requests_in_flight`,
+		`imageprocessor_phase_duration_seconds_count{phase="decode"}`,
+		`imageprocessor_phase_duration_seconds_count{phase="transform"}`,
+		`imageprocessor_phase_duration_seconds_count{phase="encode"}`,
+		`imageprocessor_megapixels_total{direction="input"}`,
+		`imageprocessor_megapixels_total{direction="output"}`,
+		`imageprocessor_input_bytes_total`,
+		`imageprocessor_output_bytes_total`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(string(scraped), "\n"+series+" ") {
+			t.Fatalf(`got no %s series, want it scraped`, series)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"imageProcessorAPI/middlewares"
+	"imageProcessorAPI/openapi"
+	"imageProcessorAPI/problem"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// routeDoc documents a route, the operation it returns is completed with the
+// parameters of the path and the security of the route.
+type routeDoc func(g *openapi.Generator) *openapi.Operation
+
+// routeDocs are keyed by method and path, like `GET /usage`. Image routes are
+// documented from operationDefinitions instead.
+var routeDocs = map[string]routeDoc{
+	`GET /healthz`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `liveness`,
+			Summary:     `Tells whether the server can serve requests at all.`,
+			Tags:        []string{`probes`},
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The server is alive.`, &openapi.Schema{Type: `object`, Properties: map[string]*openapi.Schema{`status`: {Type: `string`, Const: `ok`}}})},
+		}
+	},
+	`GET /readyz`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `readiness`,
+			Summary:     `Tells whether the server and its dependencies are ready, it is not while draining.`,
+			Tags:        []string{`probes`},
+			Responses: map[string]*openapi.Response{
+				`200`: jsonResponse(`The server is ready.`, g.Schema(reflect.TypeOf(ReadinessResponse{}))),
+				`503`: jsonResponse(`The server drains or a dependency is down.`, g.Schema(reflect.TypeOf(ReadinessResponse{}))),
+			},
+		}
+	},
+	`GET /metrics`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `metrics`,
+			Summary:     `Prometheus metrics of the server.`,
+			Tags:        []string{`probes`},
+			Responses:   map[string]*openapi.Response{`200`: {Description: `Metrics in the Prometheus text format.`, Content: map[string]openapi.MediaType{`text/plain`: {Schema: &openapi.Schema{Type: `string`}}}}},
+		}
+	},
+	`GET /problems`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `listProblems`,
+			Summary:     `Lists the codes error responses are answered with.`,
+			Tags:        []string{`documentation`},
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The catalog of codes.`, g.Schema(reflect.TypeOf([]problem.Definition{})))},
+		}
+	},
+	`GET /problems/:code`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `getProblem`,
+			Summary:     `The catalog entry the type of an error response links to.`,
+			Tags:        []string{`documentation`},
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The catalog entry of the code.`, g.Schema(reflect.TypeOf(problem.Definition{})))},
+		}
+	},
+	`GET /openapi.json`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `openAPI`,
+			Summary:     `This document.`,
+			Tags:        []string{`documentation`},
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The OpenAPI document of the API.`, &openapi.Schema{Type: `object`})},
+		}
+	},
+	`GET /docs`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `docs`,
+			Summary:     `A page documenting the API from this document, which can send test requests.`,
+			Tags:        []string{`documentation`},
+			Responses:   map[string]*openapi.Response{`200`: {Description: `The docs page.`, Content: map[string]openapi.MediaType{fiber.MIMETextHTML: {Schema: &openapi.Schema{Type: `string`}}}}},
+		}
+	},
+	`POST /process`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `process`,
+			Summary:     `Runs an operation chain, or a preset, on the image and answers with the result encoded with the output options.`,
+			Description: `Either metadata or a preset must be set.`,
+			Tags:        []string{`images`},
+			Parameters:  []openapi.Parameter{presetParameter()},
+			RequestBody: uploadBody(map[string]*openapi.Schema{
+				`image`:    {Type: `string`, Format: `binary`, Description: `A PNG or JPEG image.`},
+				`metadata`: jsonField(g.Schema(reflect.TypeOf(PipelineMetadata{}))),
+				`preset`:   presetField(),
+			}, `image`),
+			Responses: map[string]*openapi.Response{
+				`200`: imageResponse(),
+			},
+		}
+	},
+	`GET /usage`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `getUsage`,
+			Summary:     `The caller's usage in the current day and month, with the limits that apply to it. Zero limits are unlimited.`,
+			Tags:        []string{`account`},
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The usage of the caller.`, g.Schema(reflect.TypeOf(UsageResponse{})))},
+		}
+	},
+	`POST /batch`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `batch`,
+			Summary:     `Applies one operation chain, or a preset, to every image of a ZIP archive or of several image parts.`,
+			Description: `Either metadata or a preset must be set. Results are streamed as a ZIP holding every processed image and a manifest.json describing each file, files that failed are listed there with their code and hidden files or macOS metadata as skipped.`,
+			Tags:        []string{`images`},
+			Parameters:  []openapi.Parameter{presetParameter()},
+			RequestBody: uploadBody(map[string]*openapi.Schema{
+				`metadata`: jsonField(g.Schema(reflect.TypeOf(PipelineMetadata{}))),
+				`preset`:   presetField(),
+				`image`:    {Type: `array`, Items: &openapi.Schema{Type: `string`, Format: `binary`}, Description: `The images, unless an archive is uploaded.`},
+				`archive`:  {Type: `string`, Format: `binary`, Description: `A ZIP archive of images.`},
+			}),
+			Responses: map[string]*openapi.Response{
+				`200`: {Description: `A ZIP of the results and their manifest.`, Content: map[string]openapi.MediaType{`application/zip`: {Schema: &openapi.Schema{Type: `string`, Format: `binary`}}}},
+			},
+		}
+	},
+	`POST /responsive`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `responsive`,
+			Summary:     `Produces a set of widths in one or more formats from a single upload, with a manifest and a ready to use <picture> snippet.`,
+			Tags:        []string{`images`},
+			RequestBody: uploadBody(map[string]*openapi.Schema{
+				`image`:    {Type: `string`, Format: `binary`},
+				`metadata`: jsonField(g.Schema(reflect.TypeOf(ResponsiveMetaData{}))),
+				`preset`:   presetField(),
+			}, `image`, `metadata`),
+			Responses: map[string]*openapi.Response{
+				`200`: {Description: `A ZIP of the set and its manifest.`, Content: map[string]openapi.MediaType{`application/zip`: {Schema: &openapi.Schema{Type: `string`, Format: `binary`}}}},
+				`201`: jsonResponse(`The set was stored, its files are served under /generated.`, g.Schema(reflect.TypeOf(ResponsiveManifest{}))),
+			},
+		}
+	},
+	`GET /admin/presets`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `listPresets`,
+			Summary:     `Lists the latest version of every preset.`,
+			Tags:        []string{`admin`},
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The presets.`, g.Schema(reflect.TypeOf([]Preset{})))},
+		}
+	},
+	`GET /admin/presets/:name`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `getPreset`,
+			Summary:     `Lists every version of a preset, oldest first.`,
+			Tags:        []string{`admin`},
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The versions of the preset.`, g.Schema(reflect.TypeOf([]Preset{})))},
+		}
+	},
+	`PUT /admin/presets/:name`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `putPreset`,
+			Summary:     `Stores a preset as the next version of its name, an unchanged definition keeps its version.`,
+			Description: `The name, version and updatedAt of the body are ignored.`,
+			Tags:        []string{`admin`},
+			RequestBody: jsonBody(g.Schema(reflect.TypeOf(Preset{}))),
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The stored version.`, g.Schema(reflect.TypeOf(Preset{})))},
+		}
+	},
+	`DELETE /admin/presets/:name`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `deletePreset`,
+			Summary:     `Deletes every version of a preset.`,
+			Tags:        []string{`admin`},
+			Responses:   map[string]*openapi.Response{`204`: {Description: `The preset was deleted.`}},
+		}
+	},
+	`GET /admin/keys`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `listAPIKeys`,
+			Summary:     `Lists the API keys, revoked ones included.`,
+			Tags:        []string{`admin`},
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The keys, without their hash.`, g.Schema(reflect.TypeOf([]APIKeyResponse{})))},
+		}
+	},
+	`POST /admin/keys`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `createAPIKey`,
+			Summary:     `Creates an API key.`,
+			Tags:        []string{`admin`},
+			RequestBody: jsonBody(g.Schema(reflect.TypeOf(CreateAPIKeyBody{}))),
+			Responses:   map[string]*openapi.Response{`201`: jsonResponse(`The key, with the plain key the only time it is shown.`, g.Schema(reflect.TypeOf(APIKeyResponse{})))},
+		}
+	},
+	`DELETE /admin/keys/:id`: func(g *openapi.Generator) *openapi.Operation {
+		return &openapi.Operation{
+			OperationID: `revokeAPIKey`,
+			Summary:     `Revokes an API key.`,
+			Tags:        []string{`admin`},
+			Responses:   map[string]*openapi.Response{`200`: jsonResponse(`The revoked key.`, g.Schema(reflect.TypeOf(APIKeyResponse{})))},
+		}
+	},
+}
+
+// publicRoutes are answered without credentials.
+var publicRoutes = []string{`/healthz`, `/readyz`, `/metrics`, `/problems`, `/problems/:code`, `/openapi.json`, `/docs`}
+
+var pathParameterPattern = regexp.MustCompile(`:(\w+)`)
+
+// BuildOpenAPI documents routes, the routes the app registered. Routes
+// nothing documents are still listed, so that adding one changes the document.
+func BuildOpenAPI(routes []fiber.Route) openapi.Document {
+
+	g := openapi.NewGenerator()
+	g.Define(reflect.TypeOf(problem.Code(``)), `Code`, codeSchema())
+	g.Define(reflect.TypeOf(Operation{}), `Operation`, chainOperationSchema(g))
+
+	document := openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       `Image Processor API`,
+			Version:     `1.0.0`,
+			Description: `Image routes take a multipart upload of an image and a metadata field holding JSON. Errors are answered with RFC 7807 problem details, see /problems for their codes.`,
+		},
+		Paths: map[string]openapi.PathItem{},
+	}
+
+	problemResponse := &openapi.Response{
+		Description: `The request failed, code tells why.`,
+		Content:     map[string]openapi.MediaType{problem.ContentType: {Schema: g.Schema(reflect.TypeOf(problem.Problem{}))}},
+	}
+
+	registered := map[string]bool{}
+	for _, route := range routes {
+		registered[route.Method+` `+route.Path] = true
+	}
+
+	for _, route := range routes {
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+
+		operation := documentRoute(g, route)
+		versionRoute(operation, route, registered[route.Method+` `+middlewares.APIv1+route.Path])
+		operation.Parameters = append(operation.Parameters, pathParameters(route.Path)...)
+		operation.Security = routeSecurity(route.Path)
+		if operation.Responses == nil {
+			operation.Responses = map[string]*openapi.Response{}
+		}
+		operation.Responses[`default`] = problemResponse
+
+		path := pathParameterPattern.ReplaceAllString(strings.ReplaceAll(route.Path, `*`, `{path}`), `{$1}`)
+		if document.Paths[path] == nil {
+			document.Paths[path] = openapi.PathItem{}
+		}
+		document.Paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	document.Components = openapi.Components{
+		Schemas: g.Components(),
+		SecuritySchemes: map[string]openapi.SecurityScheme{
+			`apiKey`:     {Type: `apiKey`, Name: middlewares.APIKeyHeader, In: `header`},
+			`bearer`:     {Type: `http`, Scheme: `bearer`, BearerFormat: `JWT`},
+			`adminToken`: {Type: `http`, Scheme: `bearer`, Description: `The admin token of the server configuration.`},
+		},
+	}
+
+	return document
+}
+
+// OpenAPIJSON is the document of routes, indented so that it can be kept
+// in the repository and diffed.
+func OpenAPIJSON(routes []fiber.Route) ([]byte, error) {
+
+	data, err := json.MarshalIndent(BuildOpenAPI(routes), ``, `  `)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// OpenAPI serves the document of the routes of app. It is built on every
+// request, limits in it may change with the configuration.
+func OpenAPI(app *fiber.App) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		data, err := OpenAPIJSON(app.GetRoutes(true))
+		if err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(data)
+	}
+}
+
+// Docs is a page rendering /openapi.json, which can submit test requests.
+func Docs(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Send(docsPage)
+}
+
+// documentRoute documents route like the route of its path in every version.
+func documentRoute(g *openapi.Generator, route fiber.Route) *openapi.Operation {
+
+	path := middlewares.UnversionedPath(route.Path)
+	if document, ok := routeDocs[route.Method+` `+path]; ok {
+		return document(g)
+	}
+
+	name := strings.TrimPrefix(path, `/`)
+	if definition, ok := operationDefinitions[name]; ok && route.Method == fiber.MethodPost {
+		return imageRouteOperation(g, name, definition)
+	}
+
+	return &openapi.Operation{Summary: `Undocumented.`}
+}
+
+// versionRoute tells the versions of the API apart by their operation IDs
+// and tags, and marks v1 deprecated. Unversioned routes with a v1 twin are the
+// v1 routes from before versioning.
+func versionRoute(operation *openapi.Operation, route fiber.Route, v1Twin bool) {
+
+	version := ``
+	for _, prefix := range []string{middlewares.APIv1, middlewares.APIv2} {
+		if strings.HasPrefix(route.Path, prefix+`/`) {
+			version = strings.TrimPrefix(prefix, `/`)
+		}
+	}
+
+	switch {
+	case version != ``:
+		operation.OperationID = version + `.` + operation.OperationID
+	case v1Twin:
+		version = `unversioned`
+	default:
+		return
+	}
+
+	operation.Deprecated = version != `v2`
+	for i, tag := range operation.Tags {
+		operation.Tags[i] = tag + ` (` + version + `)`
+	}
+}
+
+// imageRouteOperation documents the route of an operation, which takes the
+// metadata of the operation or a preset.
+func imageRouteOperation(g *openapi.Generator, name string, definition operationDefinition) *openapi.Operation {
+
+	fields := map[string]*openapi.Schema{
+		`image`:  {Type: `string`, Format: `binary`, Description: `A PNG or JPEG image.`},
+		`preset`: presetField(),
+	}
+	if metadata := metadataSchema(g, definition); metadata != nil {
+		fields[`metadata`] = jsonField(metadata)
+	}
+
+	return &openapi.Operation{
+		OperationID: name,
+		Summary:     definition.summary,
+		Description: `The preset's chain and output options replace the operation when preset is set.`,
+		Tags:        []string{`images`},
+		Parameters:  []openapi.Parameter{presetParameter()},
+		RequestBody: uploadBody(fields, `image`),
+		Responses: map[string]*openapi.Response{
+			`200`: imageResponse(),
+		},
+	}
+}
+
+// metadataSchema is the schema of the metadata of an operation, or nil for
+// operations that take none.
+func metadataSchema(g *openapi.Generator, definition operationDefinition) *openapi.Schema {
+	metadataType := reflect.TypeOf(definition.newMetadata()).Elem()
+	if metadataType.NumField() == 0 {
+		return nil
+	}
+
+	return g.Schema(metadataType)
+}
+
+// chainOperationSchema is one of the steps of an operation chain, the schema
+// of the metadata follows from the name.
+func chainOperationSchema(g *openapi.Generator) *openapi.Schema {
+
+	names := make([]string, 0, len(operationDefinitions))
+	for name := range operationDefinitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	schema := &openapi.Schema{Description: `A step of an operation chain, metadata is what the route of the operation takes.`}
+	for _, name := range names {
+		step := &openapi.Schema{
+			Type:        `object`,
+			Description: operationDefinitions[name].summary,
+			Properties:  map[string]*openapi.Schema{`name`: {Type: `string`, Const: name}},
+			Required:    []string{`name`},
+		}
+		if metadata := metadataSchema(g, operationDefinitions[name]); metadata != nil {
+			step.Properties[`metadata`] = metadata
+			step.Required = append(step.Required, `metadata`)
+		}
+		schema.OneOf = append(schema.OneOf, step)
+	}
+
+	return schema
+}
+
+func codeSchema() *openapi.Schema {
+	schema := &openapi.Schema{Type: `string`, Description: `The machine-readable kind of a problem, see /problems.`}
+	for _, definition := range problem.Catalog() {
+		schema.Enum = append(schema.Enum, string(definition.Code))
+	}
+
+	return schema
+}
+
+func routeSecurity(path string) []openapi.SecurityRequirement {
+	switch {
+	case slices.Contains(publicRoutes, path):
+		return nil
+	case strings.HasPrefix(path, `/admin/`):
+		return []openapi.SecurityRequirement{{`adminToken`: {}}}
+	}
+
+	return []openapi.SecurityRequirement{{`apiKey`: {}}, {`bearer`: {}}}
+}
+
+func pathParameters(path string) []openapi.Parameter {
+
+	var parameters []openapi.Parameter
+	for _, match := range pathParameterPattern.FindAllStringSubmatch(path, -1) {
+		parameters = append(parameters, openapi.Parameter{Name: match[1], In: `path`, Required: true, Schema: &openapi.Schema{Type: `string`}})
+	}
+	if strings.Contains(path, `*`) {
+		parameters = append(parameters, openapi.Parameter{Name: `path`, In: `path`, Required: true, Schema: &openapi.Schema{Type: `string`}})
+	}
+
+	return parameters
+}
+
+func imageResponse() *openapi.Response {
+	return &openapi.Response{Description: `The processed image.`, Content: map[string]openapi.MediaType{
+		`image/png`:  {Schema: &openapi.Schema{Type: `string`, Format: `binary`}},
+		`image/jpeg`: {Schema: &openapi.Schema{Type: `string`, Format: `binary`}},
+	}}
+}
+
+func jsonResponse(description string, schema *openapi.Schema) *openapi.Response {
+	return &openapi.Response{Description: description, Content: map[string]openapi.MediaType{fiber.MIMEApplicationJSON: {Schema: schema}}}
+}
+
+func jsonBody(schema *openapi.Schema) *openapi.RequestBody {
+	return &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{fiber.MIMEApplicationJSON: {Schema: schema}}}
+}
+
+// uploadBody is a multipart body of fields, of which required must be set.
+func uploadBody(fields map[string]*openapi.Schema, required ...string) *openapi.RequestBody {
+
+	encoding := map[string]openapi.Encoding{}
+	for name, field := range fields {
+		if field.ContentMediaType != `` {
+			encoding[name] = openapi.Encoding{ContentType: field.ContentMediaType}
+		}
+	}
+
+	return &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+		fiber.MIMEMultipartForm: {
+			Schema:   &openapi.Schema{Type: `object`, Properties: fields, Required: required},
+			Encoding: encoding,
+		},
+	}}
+}
+
+// jsonField is a form field holding JSON of schema.
+func jsonField(schema *openapi.Schema) *openapi.Schema {
+	return &openapi.Schema{Type: `string`, ContentMediaType: fiber.MIMEApplicationJSON, ContentSchema: schema}
+}
+
+func presetField() *openapi.Schema {
+	return &openapi.Schema{Type: `string`, Description: `A preset reference, name or name@version, applied instead of the metadata.`}
+}
+
+// presetParameter is the query parameter taking the place of presetField.
+func presetParameter() openapi.Parameter {
+	return openapi.Parameter{Name: `preset`, In: `query`, Description: presetField().Description, Schema: &openapi.Schema{Type: `string`}}
+}
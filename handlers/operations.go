@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/tracing"
+	"imageProcessorAPI/utilities"
+	"imageProcessorAPI/validation"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Operation is one step of an operation chain. Metadata is the same JSON the
+// matching single image route accepts in its `metadata` form field.
+type Operation = api.Operation
+
+// OperationError is returned for operation chains the client got wrong, as
+// opposed to failures while processing a valid chain. Code is what the client
+// is answered with.
+type OperationError struct {
+	Code    problem.Code
+	Message string
+	// Fields lists the fields of the metadata that broke a rule.
+	Fields validation.Errors
+}
+
+func (e *OperationError) Error() string {
+	return e.Message
+}
+
+// operationDefinition is an operation with the type of its metadata, whose
+// validate tags are checked before the operation runs.
+type operationDefinition struct {
+	// newMetadata returns a pointer to a new value of the metadata type.
+	newMetadata func() any
+	// code answers metadata that breaks the rules of its type.
+	code problem.Code
+	// summary describes the operation in the OpenAPI document.
+	summary string
+	apply   func(ctx context.Context, img image.Image, format imaging.Format, metadata any) (image.Image, imaging.Format, error)
+}
+
+func defineOperation[M any](code problem.Code, summary string, apply func(ctx context.Context, img image.Image, format imaging.Format, metadata *M) (image.Image, imaging.Format, error)) operationDefinition {
+	return operationDefinition{
+		newMetadata: func() any { return new(M) },
+		code:        code,
+		summary:     summary,
+		apply: func(ctx context.Context, img image.Image, format imaging.Format, metadata any) (image.Image, imaging.Format, error) {
+			return apply(ctx, img, format, metadata.(*M))
+		},
+	}
+}
+
+var operationDefinitions = map[string]operationDefinition{
+	`resize`:       defineOperation(problem.InvalidParameters, `Resizes the image, keeping its aspect ratio when only one of width and height is set.`, resizeOperation),
+	`crop`:         defineOperation(problem.InvalidBounds, `Keeps the rectangle from minX, minY included to maxX, maxY excluded.`, cropOperation),
+	`rotate`:       defineOperation(problem.InvalidParameters, `Rotates the image counter-clockwise by angle degrees.`, rotateOperation),
+	`flip`:         defineOperation(problem.InvalidParameters, `Mirrors the image horizontally or vertically.`, flipOperation),
+	`grayscale`:    defineOperation(problem.InvalidParameters, `Turns the image to shades of gray.`, grayScaleOperation),
+	`changeformat`: defineOperation(problem.InvalidParameters, `Converts the image to another format.`, changeFormatOperation),
+}
+
+func init() {
+	validation.SetLimit(`maxDimension`, func() float64 { return float64(utilities.MaxAllowedDimension()) })
+}
+
+// ValidateOperations checks that every step of the chain names a known
+// operation with valid metadata, without touching any image. Fields of the
+// metadata are reported for the whole chain at once, like
+// operations[1].metadata.width.
+func ValidateOperations(operations []Operation) error {
+	if len(operations) == 0 {
+		return &OperationError{Code: problem.UnknownOperation, Message: `Must set at least one operation.`}
+	}
+
+	for _, operation := range operations {
+		if _, ok := operationDefinitions[strings.ToLower(operation.Name)]; !ok {
+			return &OperationError{Code: problem.UnknownOperation, Message: `Unknown operation: ` + operation.Name}
+		}
+	}
+
+	var code problem.Code
+	var fields validation.Errors
+	for i, operation := range operations {
+		_, err := decodeMetadata(operation)
+		var operationErr *OperationError
+		if !errors.As(err, &operationErr) {
+			continue
+		}
+
+		prefix := `operations[` + strconv.Itoa(i) + `].metadata`
+		if len(operationErr.Fields) == 0 {
+			return &OperationError{Code: operationErr.Code, Message: prefix + `: ` + operationErr.Message}
+		}
+		if code == `` {
+			code = operationErr.Code
+		}
+		fields = append(fields, operationErr.Fields.Prefix(prefix+`.`)...)
+	}
+
+	return invalidFields(code, fields)
+}
+
+// decodeMetadata reads the metadata of a known operation into its type and
+// checks its rules.
+func decodeMetadata(operation Operation) (any, error) {
+
+	definition := operationDefinitions[strings.ToLower(operation.Name)]
+	metadata := definition.newMetadata()
+
+	err := validation.Decode(operation.Metadata, metadata)
+	var fields validation.Errors
+	if errors.As(err, &fields) {
+		return nil, invalidFields(definition.code, fields)
+	}
+	if err != nil {
+		return nil, &OperationError{Code: problem.InvalidMetadata, Message: `Invalid metadata: ` + err.Error()}
+	}
+
+	return metadata, nil
+}
+
+// ApplyOperations runs the chain on img in order and returns the result with
+// the format it should be encoded in.
+func ApplyOperations(ctx context.Context, img image.Image, format imaging.Format, operations []Operation) (image.Image, imaging.Format, error) {
+
+	err := ValidateOperations(operations)
+	if err != nil {
+		return nil, format, err
+	}
+
+	defer metrics.ObservePhase(`transform`, time.Now())
+	defer logging.ObservePhase(ctx, `transform`, time.Now())
+	for _, operation := range operations {
+		if ctx.Err() != nil {
+			return nil, format, ctx.Err()
+		}
+
+		img, format, err = applyOperation(ctx, img, format, operation)
+		if err != nil {
+			return nil, format, err
+		}
+	}
+
+	return img, format, nil
+}
+
+func applyOperation(ctx context.Context, img image.Image, format imaging.Format, operation Operation) (_ image.Image, _ imaging.Format, err error) {
+	name := strings.ToLower(operation.Name)
+	ctx, span := tracing.Start(ctx, name, attribute.String(`operation`, name))
+	defer tracing.End(span, &err)
+
+	metadata, err := decodeMetadata(operation)
+	if err != nil {
+		return nil, format, err
+	}
+
+	return operationDefinitions[name].apply(ctx, img, format, metadata)
+}
+
+// ImageFormat maps a file name to one of the formats the API accepts.
+func ImageFormat(filename string) (imaging.Format, error) {
+	format, err := imaging.FormatFromFilename(filename)
+	if err != nil || (format != imaging.JPEG && format != imaging.PNG) {
+		return format, &OperationError{Code: problem.UnsupportedFormat, Message: `Expected png or jpg image.`}
+	}
+
+	return format, nil
+}
+
+// DecodeImage reads the header first so oversized images are rejected before
+// their pixels are allocated. Decoding stops once ctx is done.
+func DecodeImage(ctx context.Context, r io.ReadSeeker) (_ image.Image, err error) {
+	defer metrics.ObservePhase(`decode`, time.Now())
+	defer logging.ObservePhase(ctx, `decode`, time.Now())
+	ctx, span := tracing.Start(ctx, `decode`)
+	defer tracing.End(span, &err)
+
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, &OperationError{Code: problem.InvalidImage, Message: `Could not decode image.`}
+	}
+	span.SetAttributes(
+		attribute.String(`image.format`, format),
+		attribute.Int(`image.width`, config.Width),
+		attribute.Int(`image.height`, config.Height),
+	)
+
+	if !utilities.CheckImageConfigBounds(config) {
+		return nil, &OperationError{Code: problem.ImageTooLarge, Message: `Image bound too big.`}
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := imaging.Decode(&contextReader{ctx: ctx, r: r})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &OperationError{Code: problem.InvalidImage, Message: `Could not decode image.`}
+	}
+
+	return decoded, nil
+}
+
+// OutputOptions controls how a processed image is encoded. Zero values keep
+// the format the chain produced and the default JPEG quality.
+type OutputOptions = api.OutputOptions
+
+// ResolveFormat returns the format to encode in, the format of output
+// overriding the one the chain produced.
+func ResolveFormat(output OutputOptions, format imaging.Format) imaging.Format {
+	switch strings.ToLower(output.Format) {
+	case `png`:
+		return imaging.PNG
+	case `jpeg`, `jpg`:
+		return imaging.JPEG
+	}
+
+	return format
+}
+
+// EncodeImage stops writing once ctx is done.
+func EncodeImage(ctx context.Context, w io.Writer, img image.Image, format imaging.Format, quality int) (err error) {
+	defer metrics.ObservePhase(`encode`, time.Now())
+	defer logging.ObservePhase(ctx, `encode`, time.Now())
+	ctx, span := tracing.Start(ctx, `encode`, attribute.String(`image.format`, imageFormatName(format)))
+	defer tracing.End(span, &err)
+
+	w = &contextWriter{ctx: ctx, w: w}
+	if format == imaging.PNG {
+		return imaging.Encode(w, img, imaging.PNG)
+	}
+
+	if quality == 0 {
+		quality = CurrentSettings().JPEGQuality
+	}
+	span.SetAttributes(attribute.Int(`image.quality`, quality))
+
+	return imaging.Encode(w, img, imaging.JPEG, imaging.JPEGQuality(quality))
+}
+
+func FormatContentType(format imaging.Format) string {
+	if format == imaging.PNG {
+		return `image/png`
+	}
+
+	return `image/jpeg`
+}
+
+func FormatExtension(format imaging.Format) string {
+	if format == imaging.PNG {
+		return `.png`
+	}
+
+	return `.jpg`
+}
+
+func resizeOperation(ctx context.Context, img image.Image, format imaging.Format, data *ResizeMetaData) (image.Image, imaging.Format, error) {
+	width, height := 0, 0
+	if data.Width != nil {
+		width = *data.Width
+	}
+	if data.Height != nil {
+		height = *data.Height
+	}
+
+	resized, err := resizeContext(ctx, img, width, height, imaging.Lanczos)
+	return resized, format, err
+}
+
+func cropOperation(ctx context.Context, img image.Image, format imaging.Format, data *CropMetaData) (image.Image, imaging.Format, error) {
+	// Only the image tells how far the bounds may go.
+	var fields validation.Errors
+	if *data.MaxX > img.Bounds().Dx() {
+		fields = append(fields, validation.FieldError{Field: `maxX`, Reason: `must be at most ` + strconv.Itoa(img.Bounds().Dx()) + `, the image width`})
+	}
+	if *data.MaxY > img.Bounds().Dy() {
+		fields = append(fields, validation.FieldError{Field: `maxY`, Reason: `must be at most ` + strconv.Itoa(img.Bounds().Dy()) + `, the image height`})
+	}
+	if len(fields) > 0 {
+		return nil, format, invalidFields(problem.InvalidBounds, fields)
+	}
+
+	rec := image.Rect(*data.MinX, *data.MinY, *data.MaxX, *data.MaxY)
+	cropped, err := cropContext(ctx, img, rec)
+	return cropped, format, err
+}
+
+func rotateOperation(ctx context.Context, img image.Image, format imaging.Format, data *RotateBody) (image.Image, imaging.Format, error) {
+	rotated, err := rotateContext(ctx, img, float64(*data.Angle), color.White)
+	return rotated, format, err
+}
+
+func flipOperation(ctx context.Context, img image.Image, format imaging.Format, data *FlipMetadata) (image.Image, imaging.Format, error) {
+	if strings.EqualFold(*data.Direction, `horizontal`) {
+		flipped, err := flipHContext(ctx, img)
+		return flipped, format, err
+	}
+
+	flipped, err := flipVContext(ctx, img)
+	return flipped, format, err
+}
+
+func grayScaleOperation(ctx context.Context, img image.Image, format imaging.Format, _ *struct{}) (image.Image, imaging.Format, error) {
+	gray, err := grayscaleContext(ctx, img)
+	return gray, format, err
+}
+
+func changeFormatOperation(ctx context.Context, img image.Image, format imaging.Format, data *ChangeFormatMetadata) (image.Image, imaging.Format, error) {
+	if strings.EqualFold(*data.FormatName, `png`) {
+		return img, imaging.PNG, nil
+	}
+
+	return img, imaging.JPEG, nil
+}
+
+// invalidFields is the OperationError of fields that broke a rule, or nil
+// when there are none.
+func invalidFields(code problem.Code, fields validation.Errors) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return &OperationError{Code: code, Message: fields.Error(), Fields: fields}
+}
+
+// IsOperationError reports whether err is a client error from the chain.
+func IsOperationError(err error) bool {
+	var operationErr *OperationError
+	return errors.As(err, &operationErr)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/validation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PipelineMetadata takes either an operation chain or a preset reference, for
+// the images of a batch or the one image of a process request.
+type PipelineMetadata = api.PipelineMetadata
+
+// ValidatePipeline checks the chain and the output options of pipeline without
+// touching any image, like stored presets are checked. Rules the chain breaks
+// outside of metadata fields, like an unknown operation, are reported as
+// operations.
+func ValidatePipeline(pipeline PipelineMetadata) validation.Errors {
+	_, fields := validatePipeline(pipeline)
+	return fields
+}
+
+// validatePipeline also returns the code of the problem answering the chain.
+func validatePipeline(pipeline PipelineMetadata) (problem.Code, validation.Errors) {
+
+	code := problem.InvalidParameters
+	var fields validation.Errors
+	var operationErr *OperationError
+	if errors.As(ValidateOperations(pipeline.Operations), &operationErr) {
+		code = operationErr.Code
+		fields = operationErr.Fields
+		if len(fields) == 0 {
+			fields = validation.Errors{{Field: `operations`, Reason: operationErr.Message}}
+		}
+	}
+
+	return code, append(fields, validation.Struct(pipeline.Output).Prefix(`output.`)...)
+}
+
+// decodePipeline reads the `metadata` field, replacing the chain and output
+// options with those of the preset it or the request references. Requests
+// referencing a preset with `preset=` need no metadata. Broken rules of the
+// metadata and of its chain are reported together.
+func decodePipeline(c *fiber.Ctx, presets *PresetStore) (PipelineMetadata, error) {
+
+	data := PipelineMetadata{Preset: presetReference(c)}
+	metadata := c.FormValue(`metadata`)
+	if metadata == `` && data.Preset == `` {
+		return PipelineMetadata{}, &OperationError{Code: problem.InvalidMetadata, Message: `Must set operations or a preset to process images.`}
+	}
+
+	var fields validation.Errors
+	if metadata != `` {
+		reference := data.Preset
+		err := validation.Decode([]byte(metadata), &data)
+		if err != nil && !errors.As(err, &fields) {
+			return PipelineMetadata{}, &OperationError{Code: problem.InvalidMetadata, Message: `Invalid metadata: ` + err.Error()}
+		}
+		if data.Preset == `` {
+			data.Preset = reference
+		}
+	}
+	if data.Preset != `` {
+		preset, err := presets.Resolve(data.Preset)
+		if err != nil {
+			return PipelineMetadata{}, err
+		}
+
+		data.Operations = preset.Operations
+		data.Output = preset.Output
+		c.Set(`X-Preset`, preset.Reference())
+	}
+
+	code := problem.InvalidParameters
+	err := ValidateOperations(data.Operations)
+	var operationErr *OperationError
+	if errors.As(err, &operationErr) && len(operationErr.Fields) > 0 {
+		code = operationErr.Code
+		fields = append(fields, operationErr.Fields...)
+	} else if err != nil {
+		return PipelineMetadata{}, err
+	}
+	if len(fields) > 0 {
+		return PipelineMetadata{}, invalidFields(code, fields)
+	}
+
+	return data, nil
+}
+
+// Process runs an operation chain, or a preset, on the `image` upload and
+// answers with the result encoded with the output options. In v2 it replaces
+// the route of each operation. Presets are looked up in presets.
+func Process(presets *PresetStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		data, err := decodePipeline(c, presets)
+		if err != nil {
+			return clientError(c, err)
+		}
+
+		return processChain(c, `process`, data.Operations, data.Output)
+	}
+}
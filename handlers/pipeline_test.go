@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"imageProcessorAPI/problem"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadForm is a multipart body of a PNG image part and the given fields.
+func uploadForm(t *testing.T, fields map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set(`Content-Disposition`, `form-data; name="image"; filename="upload.png"`)
+	header.Set(`Content-Type`, `image/png`)
+	part, err := form.CreatePart(header)
+	if err == nil {
+		err = png.Encode(part, opaqueImage(40, 30))
+	}
+	for name, value := range fields {
+		if err == nil {
+			err = form.WriteField(name, value)
+		}
+	}
+	if err == nil {
+		err = form.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body, form.FormDataContentType()
+}
+
+func TestPresetReferencesNeedNoMetadata(t *testing.T) {
+
+	presets, _ := NewPresetStore(``)
+	_, err := presets.Put(presetOf(`thumb-20`, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler})
+	app.Post(`/v2/process`, Process(presets))
+	app.Post(`/v2/batch`, Batch(presets))
+
+	send := func(path string, fields map[string]string) (int, []byte, string) {
+		t.Helper()
+
+		body, contentType := uploadForm(t, fields)
+		request := httptest.NewRequest(`POST`, path, body)
+		request.Header.Set(fiber.HeaderContentType, contentType)
+		response, err := app.Test(request, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		data, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return response.StatusCode, data, response.Header.Get(`X-Preset`)
+	}
+
+	status, data, preset := send(`/v2/process?preset=thumb-20`, nil)
+	if status != fiber.StatusOK || preset != `thumb-20@1` {
+		t.Fatalf(`got status %d of preset %q, want the preset applied`, status, preset)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil || img.Bounds().Dx() != 20 {
+		t.Fatalf(`got %v, want the 20 wide result of the preset`, err)
+	}
+
+	status, data, _ = send(`/v2/batch?preset=thumb-20`, nil)
+	if status != fiber.StatusOK {
+		t.Fatalf(`got status %d and %s, want the batch processed with the preset`, status, data)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := BatchManifest{}
+	for _, file := range archive.File {
+		if file.Name != `manifest.json` {
+			continue
+		}
+		reader, err := file.Open()
+		if err == nil {
+			err = json.NewDecoder(reader).Decode(&manifest)
+			reader.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(manifest.Files) != 1 || !manifest.Files[0].Success {
+		t.Fatalf(`got manifest %+v, want the image processed`, manifest)
+	}
+
+	// Without either, there is nothing to run.
+	status, data, _ = send(`/v2/process`, nil)
+	problemDetails := problem.Problem{}
+	err = json.Unmarshal(data, &problemDetails)
+	if err != nil || status != fiber.StatusBadRequest || problemDetails.Code != problem.InvalidMetadata {
+		t.Fatalf(`got status %d and %s, want invalid_metadata`, status, data)
+	}
+}
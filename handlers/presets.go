@@ -0,0 +1,558 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Preset is a named operation chain with output options. Every change to a
+// preset is stored as a new version so results produced by an older version
+// stay reproducible and distinguishable.
+type Preset = api.Preset
+
+// samePresetDefinition compares the operations and output options of two
+// presets. Metadata is compared regardless of its formatting, which the
+// store indents when it persists presets.
+func samePresetDefinition(preset Preset, other Preset) bool {
+	if len(preset.Operations) != len(other.Operations) || preset.Output != other.Output {
+		return false
+	}
+
+	for i, operation := range preset.Operations {
+		if operation.Name != other.Operations[i].Name || !sameJSON(operation.Metadata, other.Operations[i].Metadata) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sameJSON(value []byte, other []byte) bool {
+	compactValue, compactOther := &bytes.Buffer{}, &bytes.Buffer{}
+	if json.Compact(compactValue, value) != nil || json.Compact(compactOther, other) != nil {
+		return bytes.Equal(value, other)
+	}
+
+	return bytes.Equal(compactValue.Bytes(), compactOther.Bytes())
+}
+
+var presetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+func ValidatePreset(preset Preset) error {
+	if !presetNamePattern.MatchString(preset.Name) {
+		return &OperationError{Code: problem.InvalidPreset, Message: `Preset name must be lowercase letters, digits, dashes or underscores.`}
+	}
+
+	return invalidFields(validatePipeline(PipelineMetadata{Operations: preset.Operations, Output: preset.Output}))
+}
+
+type PresetStore struct {
+	mu sync.RWMutex
+	// Every version of a preset, oldest first.
+	versions map[string][]Preset
+	// Last version number of every name ever stored, deleted ones included,
+	// so a name stored again does not reuse the versions of the deleted one.
+	last map[string]int
+	// Definitions last loaded from the presets file by name, so loading it
+	// again only stores the presets that changed in it.
+	fromFile map[string]Preset
+	// File the presets are persisted to, nothing is persisted when empty.
+	path string
+}
+
+// persistedPresets is the content of the file of a PresetStore.
+type persistedPresets struct {
+	Presets      []Preset          `json:"presets"`
+	LastVersions map[string]int    `json:"lastVersions"`
+	FromFile     map[string]Preset `json:"fromFile,omitempty"`
+}
+
+// NewPresetStore loads the presets persisted at path, if any.
+func NewPresetStore(path string) (*PresetStore, error) {
+
+	s := &PresetStore{versions: map[string][]Preset{}, last: map[string]int{}, fromFile: map[string]Preset{}, path: path}
+	if path == `` {
+		return s, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	persisted := persistedPresets{}
+	err = json.Unmarshal(content, &persisted)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, preset := range persisted.Presets {
+		err = ValidatePreset(preset)
+		if err != nil {
+			return nil, errors.New(`Invalid preset ` + preset.Reference() + `: ` + err.Error())
+		}
+		s.versions[preset.Name] = append(s.versions[preset.Name], preset)
+		s.last[preset.Name] = max(s.last[preset.Name], preset.Version)
+	}
+	for name, version := range persisted.LastVersions {
+		s.last[name] = max(s.last[name], version)
+	}
+	for name, preset := range persisted.FromFile {
+		s.fromFile[name] = preset
+	}
+	for _, versions := range s.versions {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	}
+
+	return s, nil
+}
+
+// Get returns the given version of a preset, or the latest one for version 0.
+func (s *PresetStore) Get(name string, version int) (Preset, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := s.versions[name]
+	if len(versions) == 0 {
+		return Preset{}, false
+	}
+
+	if version == 0 {
+		return versions[len(versions)-1], true
+	}
+
+	for _, preset := range versions {
+		if preset.Version == version {
+			return preset, true
+		}
+	}
+
+	return Preset{}, false
+}
+
+// Put stores preset as the next version of its name. Putting a definition
+// identical to the latest version keeps that version.
+func (s *PresetStore) Put(preset Preset) (Preset, error) {
+
+	err := ValidatePreset(preset)
+	if err != nil {
+		return Preset{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, last := s.versions[preset.Name], s.last[preset.Name]
+	stored := s.store(preset)
+	if len(s.versions[preset.Name]) == len(versions) {
+		return stored, nil
+	}
+	err = s.persist()
+	if err != nil {
+		s.versions[preset.Name] = versions
+		s.last[preset.Name] = last
+		if len(versions) == 0 {
+			delete(s.versions, preset.Name)
+		}
+		return Preset{}, err
+	}
+
+	return stored, nil
+}
+
+// PutFile stores the presets of the presets file that changed in it since it
+// was last loaded, so the versions the admin API stored of the others are
+// kept. Every preset is checked before any is stored, and either all changed
+// ones are stored or, when they cannot be persisted, none.
+func (s *PresetStore) PutFile(presets []Preset) error {
+
+	for _, preset := range presets {
+		err := ValidatePreset(preset)
+		if err != nil {
+			return &OperationError{Code: problem.InvalidPreset, Message: `Invalid preset ` + preset.Name + `: ` + err.Error()}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, last, fromFile := maps.Clone(s.versions), maps.Clone(s.last), maps.Clone(s.fromFile)
+
+	changed := false
+	inFile := map[string]bool{}
+	for _, preset := range presets {
+		inFile[preset.Name] = true
+		loaded, ok := s.fromFile[preset.Name]
+		if ok && samePresetDefinition(loaded, preset) {
+			continue
+		}
+		s.store(preset)
+		s.fromFile[preset.Name] = preset
+		changed = true
+	}
+	// Presets removed from the file are stored again when they come back.
+	for name := range s.fromFile {
+		if !inFile[name] {
+			delete(s.fromFile, name)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	err := s.persist()
+	if err != nil {
+		s.versions, s.last, s.fromFile = versions, last, fromFile
+		return err
+	}
+
+	return nil
+}
+
+// store adds preset as the next version of its name without persisting it,
+// unless it is identical to the latest version, and returns what is stored.
+// The slices of versions it replaces are left untouched.
+func (s *PresetStore) store(preset Preset) Preset {
+
+	versions := s.versions[preset.Name]
+	if len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		if samePresetDefinition(latest, preset) {
+			return latest
+		}
+	}
+	// The presets file may give the first version of a name.
+	if len(versions) > 0 || preset.Version <= s.last[preset.Name] {
+		preset.Version = s.last[preset.Name] + 1
+	}
+
+	if preset.UpdatedAt.IsZero() {
+		preset.UpdatedAt = time.Now().UTC()
+	}
+
+	s.versions[preset.Name] = append(slices.Clip(versions), preset)
+	s.last[preset.Name] = preset.Version
+
+	return preset
+}
+
+// Delete removes every version of a preset. Its version numbers are not
+// reused when the name is stored again.
+func (s *PresetStore) Delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, ok := s.versions[name]
+	if !ok {
+		return false, nil
+	}
+
+	delete(s.versions, name)
+	err := s.persist()
+	if err != nil {
+		s.versions[name] = versions
+		return false, err
+	}
+
+	return true, nil
+}
+
+// List returns the latest version of every preset, sorted by name.
+func (s *PresetStore) List() []Preset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	presets := make([]Preset, 0, len(s.versions))
+	for _, versions := range s.versions {
+		presets = append(presets, versions[len(versions)-1])
+	}
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+
+	return presets
+}
+
+// History returns every stored version of a preset, oldest first.
+func (s *PresetStore) History(name string) []Preset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Preset(nil), s.versions[name]...)
+}
+
+func (s *PresetStore) persist() error {
+
+	if s.path == `` {
+		return nil
+	}
+
+	persisted := persistedPresets{Presets: []Preset{}, LastVersions: s.last, FromFile: s.fromFile}
+	names := make([]string, 0, len(s.versions))
+	for name := range s.versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		persisted.Presets = append(persisted.Presets, s.versions[name]...)
+	}
+
+	content, err := json.MarshalIndent(persisted, ``, `  `)
+	if err != nil {
+		return err
+	}
+
+	// Written next to the target and renamed so a crash never leaves a
+	// truncated preset file behind.
+	temporaryPath := s.path + `.tmp`
+	err = os.WriteFile(temporaryPath, content, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryPath, s.path)
+}
+
+// LoadPresetsFile reads a JSON array of presets, as written by the admin API,
+// and checks every one of them so they can all be stored.
+func LoadPresetsFile(path string) ([]Preset, error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var presets []Preset
+	err = json.Unmarshal(content, &presets)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, preset := range presets {
+		err = ValidatePreset(preset)
+		if err != nil {
+			return nil, &OperationError{Code: problem.InvalidPreset, Message: `Invalid preset ` + preset.Name + `: ` + err.Error()}
+		}
+	}
+
+	return presets, nil
+}
+
+// Resolve looks up a `name` or `name@version` reference.
+func (s *PresetStore) Resolve(reference string) (Preset, error) {
+
+	name, versionText, pinned := strings.Cut(reference, `@`)
+
+	version := 0
+	if pinned {
+		var err error
+		version, err = strconv.Atoi(versionText)
+		if err != nil || version <= 0 {
+			return Preset{}, &OperationError{Code: problem.UnknownPreset, Message: `Invalid preset version: ` + versionText}
+		}
+	}
+
+	preset, ok := s.Get(name, version)
+	if !ok {
+		return Preset{}, &OperationError{Code: problem.UnknownPreset, Message: `Unknown preset: ` + reference}
+	}
+
+	return preset, nil
+}
+
+// presetReference reads `preset` from the query string or the form.
+func presetReference(c *fiber.Ctx) string {
+	reference := c.Query(`preset`)
+	if reference == `` {
+		reference = c.FormValue(`preset`)
+	}
+
+	return reference
+}
+
+// ApplyPreset lets any image route be called with `preset=name[@version]`,
+// in which case the preset's chain and output options replace the route's
+// own operation.
+func ApplyPreset(presets *PresetStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return applyPreset(c, presets)
+	}
+}
+
+func applyPreset(c *fiber.Ctx, presets *PresetStore) error {
+
+	reference := presetReference(c)
+	if reference == `` {
+		return c.Next()
+	}
+
+	preset, err := presets.Resolve(reference)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	// Callers without the scopes of the preset learn nothing about cached
+	// results, its dimensions are checked once the image is decoded.
+	err = authorize(c, operationNames(preset.Operations), preset.Output.Format, 0, 0)
+	if err != nil {
+		return forbidden(c, err)
+	}
+
+	ctx, cancelCtx := context.WithTimeout(c.UserContext(), CurrentSettings().ProcessingTimeout)
+	defer cancelCtx()
+
+	fileHeader, err := c.FormFile(`image`)
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error(`Could not get form file. Error: `+err.Error(), slog.String(`operation`, `preset`))
+		return problem.Send(c, problem.InternalError, ``)
+	}
+
+	format, err := ImageFormat(fileHeader.Filename)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error(`Could not open file. Error: `+err.Error(), slog.String(`operation`, `preset`))
+		return problem.Send(c, problem.InternalError, ``)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error(`Could not read file. Error: `+err.Error(), slog.String(`operation`, `preset`))
+		return problem.Send(c, problem.InternalError, ``)
+	}
+
+	// The ETag changes with the preset version, so caches never serve a result
+	// produced by an older definition of the preset.
+	etag := `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `-` + preset.Reference() + `"`
+	c.Set(`X-Preset`, preset.Reference())
+	c.Set(fiber.HeaderETag, etag)
+	cached := c.Get(fiber.HeaderIfNoneMatch) == etag
+	metrics.ObserveCache(metrics.CachePresetETag, cached)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool(`preset.cache_hit`, cached))
+	if cached {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	decodedImage, err := DecodeImage(ctx, file)
+	if err != nil {
+		return processingError(c, err, `preset`)
+	}
+
+	err = authorize(c, nil, ``, decodedImage.Bounds().Dx(), decodedImage.Bounds().Dy())
+	if err != nil {
+		return forbidden(c, err)
+	}
+
+	processedImage, format, err := ApplyOperations(ctx, decodedImage, format, preset.Operations)
+	if err != nil {
+		return processingError(c, err, `preset`)
+	}
+	recordWork(c, operationNames(preset.Operations), decodedImage, processedImage)
+
+	format = ResolveFormat(preset.Output, format)
+	err = authorize(c, nil, imageFormatName(format), processedImage.Bounds().Dx(), processedImage.Bounds().Dy())
+	if err != nil {
+		return forbidden(c, err)
+	}
+	c.Set(fiber.HeaderContentType, FormatContentType(format))
+	err = EncodeImage(ctx, c.Response().BodyWriter(), processedImage, format, preset.Output.Quality)
+	if err != nil {
+		c.Response().ResetBody()
+		return processingError(c, err, `preset`)
+	}
+
+	return nil
+}
+
+// ListPresets returns the latest version of every preset.
+func ListPresets(presets *PresetStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(presets.List())
+	}
+}
+
+// GetPreset returns every version of one preset, oldest first.
+func GetPreset(presets *PresetStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		history := presets.History(c.Params(`name`))
+		if len(history) == 0 {
+			return problem.Send(c, problem.PresetNotFound, `Preset not found.`)
+		}
+
+		return c.JSON(history)
+	}
+}
+
+// PutPreset creates a preset or stores a new version of it.
+func PutPreset(presets *PresetStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		preset := Preset{}
+		err := json.Unmarshal(c.Body(), &preset)
+		if err != nil {
+			return problem.Send(c, problem.InvalidBody, `Invalid preset.`)
+		}
+
+		preset.Name = c.Params(`name`)
+		preset.Version = 0
+		preset.UpdatedAt = time.Time{}
+
+		stored, err := presets.Put(preset)
+		if err != nil {
+			return clientError(c, err)
+		}
+
+		logging.FromContext(c.UserContext()).Info(`Stored preset ` + stored.Reference() + `.`)
+		return c.JSON(stored)
+	}
+}
+
+func DeletePreset(presets *PresetStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		deleted, err := presets.Delete(c.Params(`name`))
+		if err != nil {
+			logging.FromContext(c.UserContext()).Error(`Could not delete preset. Error: ` + err.Error())
+			return problem.Send(c, problem.InternalError, ``)
+		}
+		if !deleted {
+			return problem.Send(c, problem.PresetNotFound, `Preset not found.`)
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
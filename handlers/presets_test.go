@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func presetOf(name string, width int) Preset {
+	return Preset{Name: name, Operations: []Operation{{Name: `resize`, Metadata: json.RawMessage(`{"width":` + strconv.Itoa(width) + `}`)}}}
+}
+
+func TestPutFileKeepsAdminEditsOfUnchangedPresets(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `preset_store.json`)
+	store, err := NewPresetStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := []Preset{presetOf(`small`, 1), presetOf(`large`, 9)}
+	err = store.PutFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Put(presetOf(`small`, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading the same file again, after a restart too, keeps the edit.
+	store, err = NewPresetStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.PutFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, _ := store.Get(`small`, 0)
+	if small.Version != 2 || !samePresetDefinition(small, presetOf(`small`, 2)) {
+		t.Fatalf(`got %+v, want the edit of the admin API kept`, small)
+	}
+
+	// A preset that changed in the file replaces the edit.
+	file[0] = presetOf(`small`, 3)
+	err = store.PutFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, _ = store.Get(`small`, 0)
+	large, _ := store.Get(`large`, 0)
+	if small.Version != 3 || !samePresetDefinition(small, file[0]) || large.Version != 1 {
+		t.Fatalf(`got %+v and %+v, want the changed preset stored and the other kept`, small, large)
+	}
+}
+
+func TestPutFileStoresAllOrNone(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, `preset_store.json`)
+	store, err := NewPresetStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid preset in the middle stores none of the others.
+	err = store.PutFile([]Preset{presetOf(`first`, 1), {Name: `Invalid name`}, presetOf(`last`, 1)})
+	if err == nil {
+		t.Fatal(`got no error, want the invalid preset rejected`)
+	}
+	if presets := store.List(); len(presets) != 0 {
+		t.Fatalf(`got %+v stored, want none`, presets)
+	}
+
+	// So does a store that cannot be persisted.
+	err = os.Mkdir(path+`.tmp`, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.PutFile([]Preset{presetOf(`first`, 1), presetOf(`last`, 1)})
+	if err == nil {
+		t.Fatal(`got no error, want persisting to fail`)
+	}
+	if presets := store.List(); len(presets) != 0 {
+		t.Fatalf(`got %+v stored, want none`, presets)
+	}
+
+	// And once it can, all are stored, as if the failed attempt never was.
+	err = os.Remove(path + `.tmp`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.PutFile([]Preset{presetOf(`first`, 1), presetOf(`last`, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if presets := store.List(); len(presets) != 2 || presets[0].Version != 1 || presets[1].Version != 1 {
+		t.Fatalf(`got %+v stored, want both as their first version`, presets)
+	}
+}
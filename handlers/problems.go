@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"imageProcessorAPI/problem"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Problems lists the codes error responses are answered with.
+func Problems(c *fiber.Ctx) error {
+	return c.JSON(problem.Catalog())
+}
+
+// Problem is the catalog entry the type of an error response links to.
+func Problem(c *fiber.Ctx) error {
+	definition, ok := problem.Lookup(problem.Code(c.Params(`code`)))
+	if !ok {
+		return problem.Send(c, problem.NotFound, `Unknown problem code.`)
+	}
+
+	return c.JSON(definition)
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html"
+	"image"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/tracing"
+	"imageProcessorAPI/validation"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	MaxResponsiveWidths = 12
+
+	defaultResponsiveMinWidth = 320
+	// Candidate widths grow by this factor while looking for breakpoints.
+	responsiveWidthStep = 1.1
+)
+
+func init() {
+	validation.SetLimit(`maxResponsiveWidths`, func() float64 { return MaxResponsiveWidths })
+}
+
+// Upload names end up in file names, URLs and headers, so anything but a
+// conservative set of characters is replaced.
+var responsiveFileNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ResponsiveMetaData asks for either explicit Widths or breakpoints derived
+// from SizeBudget, the minimum growth in bytes between two widths. Store
+// writes the set to the storage directory instead of returning a ZIP.
+type ResponsiveMetaData = api.ResponsiveMetaData
+
+type ResponsiveImage = api.ResponsiveImage
+
+type ResponsiveManifest = api.ResponsiveManifest
+
+type responsiveFile struct {
+	image ResponsiveImage
+	data  []byte
+}
+
+// Responsive produces a set of widths in one or more formats from a single
+// upload, with a manifest and a ready to use <picture> snippet. Presets are
+// looked up in presets. Stored sets are written to storageDir, to be served
+// from under /generated, storing is disabled while it is empty.
+func Responsive(presets *PresetStore, storageDir string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return responsive(c, presets, storageDir)
+	}
+}
+
+func responsive(c *fiber.Ctx, presets *PresetStore, storageDir string) error {
+
+	ctx, cancelCtx := context.WithTimeout(c.UserContext(), CurrentSettings().ResponsiveTimeout)
+	defer cancelCtx()
+
+	data := ResponsiveMetaData{}
+	err := validation.Decode([]byte(c.FormValue(`metadata`)), &data)
+	var fields validation.Errors
+	if errors.As(err, &fields) {
+		return clientError(c, invalidFields(problem.InvalidParameters, fields))
+	}
+	if err != nil {
+		return problem.Send(c, problem.InvalidMetadata, `Invalid metadata.`)
+	}
+	formats := responsiveFormats(data)
+
+	if data.Store && storageDir == `` {
+		return problem.Send(c, problem.StorageDisabled, `Storing responsive sets is not enabled.`)
+	}
+
+	fileHeader, err := c.FormFile(`image`)
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error(`Could not get form file. Error: `+err.Error(), slog.String(`operation`, `responsive`))
+		return problem.Send(c, problem.InternalError, ``)
+	}
+
+	format, err := ImageFormat(fileHeader.Filename)
+	if err != nil {
+		return clientError(c, err)
+	}
+	if len(formats) == 0 {
+		formats = []imaging.Format{format}
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error(`Could not open file. Error: `+err.Error(), slog.String(`operation`, `responsive`))
+		return problem.Send(c, problem.InternalError, ``)
+	}
+	defer file.Close()
+
+	sourceImage, err := DecodeImage(ctx, file)
+	if err != nil {
+		return processingError(c, err, `responsive`)
+	}
+
+	for _, outputFormat := range formats {
+		err = authorize(c, []string{`resize`}, imageFormatName(outputFormat), sourceImage.Bounds().Dx(), sourceImage.Bounds().Dy())
+		if err != nil {
+			return forbidden(c, err)
+		}
+	}
+
+	// A preset prepares the source, e.g. crops it, before the set is generated.
+	if reference := presetReference(c); reference != `` {
+		preset, err := presets.Resolve(reference)
+		if err != nil {
+			return clientError(c, err)
+		}
+
+		err = authorize(c, operationNames(preset.Operations), ``, 0, 0)
+		if err != nil {
+			return forbidden(c, err)
+		}
+
+		presetImage, _, err := ApplyOperations(ctx, sourceImage, format, preset.Operations)
+		if err != nil {
+			return processingError(c, err, `responsive`)
+		}
+		recordWork(c, operationNames(preset.Operations), sourceImage, presetImage)
+		sourceImage = presetImage
+		c.Set(`X-Preset`, preset.Reference())
+	}
+
+	widths := data.Widths
+	if len(widths) == 0 {
+		widths, err = responsiveBreakpoints(ctx, sourceImage, formats[0], data, func(probe image.Image) {
+			recordWork(c, []string{`resize`}, sourceImage, probe)
+		})
+		if err != nil {
+			return processingError(c, err, `responsive`)
+		}
+	}
+	widths = clampResponsiveWidths(widths, sourceImage.Bounds().Dx())
+
+	name := responsiveFileNamePattern.ReplaceAllString(strings.TrimSuffix(path.Base(filepath.ToSlash(fileHeader.Filename)), path.Ext(fileHeader.Filename)), `-`)
+	baseURL := data.BaseURL
+	var storeID string
+	if data.Store {
+		storeID, err = newResponsiveSetID()
+		if err != nil {
+			logging.FromContext(c.UserContext()).Error(`Could not create responsive set id. Error: `+err.Error(), slog.String(`operation`, `responsive`))
+			return problem.Send(c, problem.InternalError, ``)
+		}
+		baseURL = `/generated/` + storeID + `/`
+	}
+
+	manifest := ResponsiveManifest{
+		Name:   name,
+		Width:  sourceImage.Bounds().Dx(),
+		Height: sourceImage.Bounds().Dy(),
+		SrcSet: map[string]string{},
+	}
+	var files []responsiveFile
+	for _, width := range widths {
+		resizedImage, err := tracedResize(ctx, sourceImage, width)
+		if err != nil {
+			return processingError(c, err, `responsive`)
+		}
+		recordWork(c, []string{`resize`}, sourceImage, resizedImage)
+
+		for _, outputFormat := range formats {
+			var buffer bytes.Buffer
+			err = EncodeImage(ctx, &buffer, resizedImage, outputFormat, data.Quality)
+			if err != nil {
+				return processingError(c, err, `responsive`)
+			}
+
+			fileName := name + `-` + strconv.Itoa(width) + FormatExtension(outputFormat)
+			files = append(files, responsiveFile{
+				image: ResponsiveImage{
+					File:   fileName,
+					URL:    baseURL + fileName,
+					Format: strings.ToLower(outputFormat.String()),
+					Width:  resizedImage.Bounds().Dx(),
+					Height: resizedImage.Bounds().Dy(),
+					Bytes:  buffer.Len(),
+				},
+				data: buffer.Bytes(),
+			})
+		}
+	}
+
+	for _, file := range files {
+		manifest.Images = append(manifest.Images, file.image)
+	}
+	logging.Annotate(c.UserContext(), slog.Int(`images`, len(files)))
+	manifest.SrcSet, manifest.Picture = responsiveMarkup(manifest.Images, formats, data.Sizes, data.Alt)
+
+	if data.Store {
+		err = storeResponsiveSet(ctx, storageDir, storeID, files, manifest)
+		if err != nil {
+			logging.FromContext(c.UserContext()).Error(`Could not store responsive set. Error: `+err.Error(), slog.String(`operation`, `responsive`))
+			return problem.Send(c, problem.InternalError, ``)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(manifest)
+	}
+
+	var archive bytes.Buffer
+	err = writeResponsiveArchive(&archive, files, manifest)
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error(`Could not write responsive archive. Error: `+err.Error(), slog.String(`operation`, `responsive`))
+		return problem.Send(c, problem.InternalError, ``)
+	}
+
+	c.Type(`zip`)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+name+`-responsive.zip"`)
+	return c.Send(archive.Bytes())
+}
+
+// responsiveFormats resolves the names in Formats, which were checked when the
+// metadata was decoded.
+func responsiveFormats(data ResponsiveMetaData) []imaging.Format {
+
+	var formats []imaging.Format
+	for _, name := range data.Formats {
+		format := ResolveFormat(OutputOptions{Format: name}, imaging.JPEG)
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+
+	return formats
+}
+
+// responsiveBreakpoints picks widths between the min and max width so that
+// each one is at least SizeBudget bytes bigger than the previous when encoded.
+func responsiveBreakpoints(ctx context.Context, img image.Image, format imaging.Format, data ResponsiveMetaData, recordProbe func(image.Image)) ([]int, error) {
+
+	minWidth, maxWidth := defaultResponsiveMinWidth, img.Bounds().Dx()
+	if data.MinWidth != nil {
+		minWidth = *data.MinWidth
+	}
+	if data.MaxWidth != nil && *data.MaxWidth < maxWidth {
+		maxWidth = *data.MaxWidth
+	}
+	minWidth = min(minWidth, maxWidth)
+
+	widths := []int{minWidth}
+	lastSize := -1
+	for width := float64(minWidth); int(width) < maxWidth && len(widths) < MaxResponsiveWidths-1; width *= responsiveWidthStep {
+		probe, err := tracedResize(ctx, img, int(width))
+		if err != nil {
+			return nil, err
+		}
+		recordProbe(probe)
+
+		counter := &countingWriter{w: io.Discard}
+		err = EncodeImage(ctx, counter, probe, format, data.Quality)
+		if err != nil {
+			return nil, err
+		}
+
+		size := int(counter.written)
+		if lastSize < 0 {
+			lastSize = size
+			continue
+		}
+		if size-lastSize >= *data.SizeBudget {
+			widths = append(widths, int(width))
+			lastSize = size
+		}
+	}
+
+	if widths[len(widths)-1] != maxWidth {
+		widths = append(widths, maxWidth)
+	}
+
+	return widths, nil
+}
+
+// tracedResize is the resize of the responsive widths, which do not go
+// through ApplyOperations.
+func tracedResize(ctx context.Context, img image.Image, width int) (_ image.Image, err error) {
+	ctx, span := tracing.Start(ctx, `resize`, attribute.String(`operation`, `resize`), attribute.Int(`image.width`, width))
+	defer tracing.End(span, &err)
+
+	return resizeContext(ctx, img, width, 0, imaging.Lanczos)
+}
+
+// clampResponsiveWidths clamps widths larger than the source to its width,
+// as upscaling only adds bytes, and returns them sorted without duplicates.
+func clampResponsiveWidths(widths []int, sourceWidth int) []int {
+
+	var clamped []int
+	for _, width := range widths {
+		clamped = append(clamped, min(width, sourceWidth))
+	}
+	slices.Sort(clamped)
+
+	return slices.Compact(clamped)
+}
+
+func responsiveMarkup(images []ResponsiveImage, formats []imaging.Format, sizes string, alt string) (map[string]string, string) {
+
+	srcSets := map[string]string{}
+	for _, format := range formats {
+		var candidates []string
+		for _, img := range images {
+			if img.Format == strings.ToLower(format.String()) {
+				candidates = append(candidates, img.URL+` `+strconv.Itoa(img.Width)+`w`)
+			}
+		}
+		srcSets[strings.ToLower(format.String())] = strings.Join(candidates, `, `)
+	}
+
+	// JPEG is the most widely supported, so it is the <img> fallback when asked for.
+	fallback := formats[0]
+	if slices.Contains(formats, imaging.JPEG) {
+		fallback = imaging.JPEG
+	}
+
+	sizesAttribute := ``
+	if sizes != `` {
+		sizesAttribute = ` sizes="` + html.EscapeString(sizes) + `"`
+	}
+
+	var picture strings.Builder
+	picture.WriteString("<picture>\n")
+	for _, format := range formats {
+		if format == fallback {
+			continue
+		}
+		picture.WriteString(`  <source type="` + FormatContentType(format) + `" srcset="` + html.EscapeString(srcSets[strings.ToLower(format.String())]) + `"` + sizesAttribute + ">\n")
+	}
+
+	var largest ResponsiveImage
+	for _, img := range images {
+		if img.Format == strings.ToLower(fallback.String()) && img.Width > largest.Width {
+			largest = img
+		}
+	}
+	picture.WriteString(`  <img src="` + html.EscapeString(largest.URL) + `" srcset="` + html.EscapeString(srcSets[strings.ToLower(fallback.String())]) + `"` + sizesAttribute +
+		` width="` + strconv.Itoa(largest.Width) + `" height="` + strconv.Itoa(largest.Height) + `" alt="` + html.EscapeString(alt) + "\">\n")
+	picture.WriteString(`</picture>`)
+
+	return srcSets, picture.String()
+}
+
+func writeResponsiveArchive(w *bytes.Buffer, files []responsiveFile, manifest ResponsiveManifest) error {
+
+	zipWriter := zip.NewWriter(w)
+	for _, file := range files {
+		entryWriter, err := zipWriter.Create(file.image.File)
+		if err != nil {
+			return err
+		}
+		_, err = entryWriter.Write(file.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifestWriter, err := zipWriter.Create(`manifest.json`)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(manifestWriter).Encode(manifest)
+	if err != nil {
+		return err
+	}
+
+	pictureWriter, err := zipWriter.Create(`picture.html`)
+	if err != nil {
+		return err
+	}
+	_, err = pictureWriter.Write([]byte(manifest.Picture + "\n"))
+	if err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+func storeResponsiveSet(ctx context.Context, storageDir string, id string, files []responsiveFile, manifest ResponsiveManifest) (err error) {
+	_, span := tracing.Start(ctx, `store responsive set`, attribute.String(`responsive.id`, id), attribute.Int(`responsive.files`, len(files)))
+	defer tracing.End(span, &err)
+
+	dir := filepath.Join(storageDir, id)
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err = os.WriteFile(filepath.Join(dir, file.image.File), file.data, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, `manifest.json`), manifestData, 0o644)
+}
+
+func newResponsiveSetID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return ``, err
+	}
+
+	return hex.EncodeToString(id), nil
+}
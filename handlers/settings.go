@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"imageProcessorAPI/quota"
+	"sync/atomic"
+	"time"
+)
+
+// Settings are the handler settings that come from the configuration. They
+// are replaced as a whole when it is reloaded, a request keeps the settings
+// it started with.
+type Settings struct {
+	JPEGQuality int
+	// ProcessingTimeout bounds the processing of one request's image.
+	ProcessingTimeout time.Duration
+	// ResponsiveTimeout bounds the generation of one responsive set.
+	ResponsiveTimeout time.Duration
+	// BatchTimeout bounds the processing of a whole batch.
+	BatchTimeout time.Duration
+	// DefaultQuota is what GET /usage reports for callers without their own.
+	DefaultQuota quota.Policy
+}
+
+var settings atomic.Pointer[Settings]
+
+func init() {
+	SetSettings(Settings{
+		JPEGQuality:       85,
+		ProcessingTimeout: time.Second * 30,
+		ResponsiveTimeout: time.Second * 60,
+		BatchTimeout:      time.Minute * 10,
+	})
+}
+
+func SetSettings(s Settings) {
+	settings.Store(&s)
+}
+
+func CurrentSettings() Settings {
+	return *settings.Load()
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"image/png"
+	"imageProcessorAPI/middlewares"
+	"imageProcessorAPI/problem"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider recording every span, like
+// tracing.Setup does with an exporter, until the test is done.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+		provider.Shutdown(t.Context())
+	})
+
+	return recorder
+}
+
+func TestProcessContinuesIncomingTrace(t *testing.T) {
+
+	recorder := recordSpans(t)
+
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler})
+	app.Use(middlewares.Trace(app))
+	app.Post(`/v2/process`, Process(&PresetStore{}))
+
+	encoded := &bytes.Buffer{}
+	err := png.Encode(encoded, opaqueImage(40, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set(`Content-Disposition`, `form-data; name="image"; filename="upload.png"`)
+	header.Set(`Content-Type`, `image/png`)
+	part, err := form.CreatePart(header)
+	if err == nil {
+		_, err = part.Write(encoded.Bytes())
+	}
+	if err == nil {
+		err = form.WriteField(`metadata`, `{"operations":[{"name":"grayscale"},{"name":"flip","metadata":{"direction":"horizontal"}}]}`)
+	}
+	if err == nil {
+		err = form.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traceID, _ := trace.TraceIDFromHex(`4bf92f3577b34da6a3ce929d0e0e4736`)
+	parentID, _ := trace.SpanIDFromHex(`00f067aa0ba902b7`)
+	request := httptest.NewRequest(`POST`, `/v2/process`, body)
+	request.Header.Set(fiber.HeaderContentType, form.FormDataContentType())
+	request.Header.Set(`traceparent`, `00-`+traceID.String()+`-`+parentID.String()+`-01`)
+
+	response, err := app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != fiber.StatusOK {
+		t.Fatalf(`got status %d, want %d`, response.StatusCode, fiber.StatusOK)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	requestSpan, ok := spans[`POST /v2/process`]
+	if !ok {
+		t.Fatalf(`got spans %v, want one for the request`, spanNames(recorder.Ended()))
+	}
+	if requestSpan.SpanContext().TraceID() != traceID || requestSpan.Parent().SpanID() != parentID || !requestSpan.Parent().IsRemote() {
+		t.Fatalf(`got the request span in trace %s under %s, want the trace of the traceparent header continued`, requestSpan.SpanContext().TraceID(), requestSpan.Parent().SpanID())
+	}
+
+	// The transform runs a span per operation.
+	for _, name := range []string{`decode`, `grayscale`, `flip`, `encode`} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf(`got spans %v, want %s`, spanNames(recorder.Ended()), name)
+		}
+		if span.Parent().SpanID() != requestSpan.SpanContext().SpanID() || span.SpanContext().TraceID() != traceID {
+			t.Fatalf(`got %s under span %s, want it a child of the request span %s`, name, span.Parent().SpanID(), requestSpan.SpanContext().SpanID())
+		}
+	}
+}
+
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+
+	return names
+}
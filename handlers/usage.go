@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/quota"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type UsageResponse = api.UsageResponse
+
+// Usage reports the caller's usage in quotas in the current day and month,
+// with the limits that apply to it. Zero limits are unlimited.
+func Usage(quotas *quota.Tracker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		client := auth.ClientID(c)
+		principal, _ := auth.Caller(c)
+		policy := principal.QuotaPolicy(CurrentSettings().DefaultQuota)
+
+		usage := quotas.Usage(client)
+		dailyReset, monthlyReset := quotas.ResetTimes()
+
+		return c.JSON(UsageResponse{
+			Client:  client,
+			Daily:   api.UsageWindow{Period: usage.Daily.Period, Used: usage.Daily.Usage, Limits: policy.Daily, ResetAt: dailyReset},
+			Monthly: api.UsageWindow{Period: usage.Monthly.Period, Used: usage.Monthly.Usage, Limits: policy.Monthly, ResetAt: monthlyReset},
+		})
+	}
+}
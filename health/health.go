@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDraining is reported by readiness once the server is shutting down.
+var ErrDraining = errors.New(`Server is shutting down.`)
+
+// checkTimeout bounds each readiness check, so one hanging dependency does
+// not hang the probe.
+const checkTimeout = time.Second * 2
+
+// Check reports whether a dependency can be used.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker answers readiness probes from the checks of the dependencies the
+// server needs and from whether it is draining.
+type Checker struct {
+	draining atomic.Bool
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add registers the check of a dependency under name.
+func (h *Checker) Add(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// Drain makes the server unready for good, so load balancers stop sending it
+// requests before it stops accepting them.
+func (h *Checker) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *Checker) Draining() bool {
+	return h.draining.Load()
+}
+
+// Ready runs every check at once and returns the error of each failed one by
+// name. The server is ready when nothing failed.
+func (h *Checker) Ready(ctx context.Context) map[string]error {
+
+	failures := map[string]error{}
+	if h.Draining() {
+		failures[`draining`] = ErrDraining
+	}
+
+	h.mu.RLock()
+	checks := h.checks
+	h.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := check.check(ctx)
+			if err != nil {
+				mu.Lock()
+				failures[check.name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return failures
+}
+
+// WritableDir checks that files can be created in dir.
+func WritableDir(dir string) Check {
+	return func(ctx context.Context) error {
+		file, err := os.CreateTemp(dir, `.readyz-*`)
+		if err != nil {
+			return err
+		}
+
+		file.Close()
+		return os.Remove(file.Name())
+	}
+}
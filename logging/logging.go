@@ -0,0 +1,164 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// RequestIDLocal is where the request ID middleware stores the ID.
+const RequestIDLocal = `requestID`
+
+// Level is the lowest level logged, it can be changed while the server runs.
+var Level = new(slog.LevelVar)
+
+// Setup makes the default logger write text or json lines to stderr.
+func Setup(format string) {
+
+	options := &slog.HandlerOptions{Level: Level}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if format == `json` {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
+type loggerKey struct{}
+
+type entryKey struct{}
+
+// NewContext carries the logger of a request, and the entry of its access
+// log line when it has one.
+func NewContext(ctx context.Context, logger *slog.Logger, entry *Entry) context.Context {
+	ctx = context.WithValue(ctx, loggerKey{}, logger)
+	if entry != nil {
+		ctx = context.WithValue(ctx, entryKey{}, entry)
+	}
+
+	return ctx
+}
+
+// FromContext is the logger of the request ctx belongs to, which tags every
+// line with the request ID, or the default logger outside of requests.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+// Annotate adds attributes to the access log line of the request ctx belongs
+// to. An attribute replaces an earlier one with the same key.
+func Annotate(ctx context.Context, attributes ...slog.Attr) {
+	if entry, ok := ctx.Value(entryKey{}).(*Entry); ok {
+		entry.Add(attributes...)
+	}
+}
+
+// ObservePhase adds the time since start to the phase in the access log line
+// of the request ctx belongs to, it is meant to be deferred. Phases that run
+// more than once add up.
+func ObservePhase(ctx context.Context, phase string, start time.Time) {
+	if entry, ok := ctx.Value(entryKey{}).(*Entry); ok {
+		entry.AddPhase(phase, time.Since(start))
+	}
+}
+
+// Hold keeps the access log line of the request ctx belongs to from being
+// written until the returned function is called, for work that goes on after
+// the handler returned, like streamed responses.
+func Hold(ctx context.Context) func() {
+	if entry, ok := ctx.Value(entryKey{}).(*Entry); ok {
+		return entry.Hold()
+	}
+
+	return func() {}
+}
+
+// Entry collects the attributes of the access log line of a request, which
+// is written once the request and whatever held it are done.
+type Entry struct {
+	mu         sync.Mutex
+	attributes []slog.Attr
+	phases     []string
+	durations  map[string]time.Duration
+	holds      int
+	write      func(attributes []slog.Attr)
+}
+
+// NewEntry writes the line with write once every hold was released.
+func NewEntry(write func(attributes []slog.Attr)) *Entry {
+	return &Entry{write: write, durations: map[string]time.Duration{}}
+}
+
+func (e *Entry) Add(attributes ...slog.Attr) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, attribute := range attributes {
+		replaced := false
+		for i, existing := range e.attributes {
+			if existing.Key == attribute.Key {
+				e.attributes[i] = attribute
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			e.attributes = append(e.attributes, attribute)
+		}
+	}
+}
+
+func (e *Entry) AddPhase(phase string, duration time.Duration) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.durations[phase]; !ok {
+		e.phases = append(e.phases, phase)
+	}
+	e.durations[phase] += duration
+}
+
+// Hold delays the line until the returned function is called, which may be
+// called more than once.
+func (e *Entry) Hold() func() {
+
+	e.mu.Lock()
+	e.holds++
+	e.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(e.release)
+	}
+}
+
+func (e *Entry) release() {
+
+	e.mu.Lock()
+	e.holds--
+	if e.holds > 0 {
+		e.mu.Unlock()
+		return
+	}
+
+	attributes := e.attributes
+	if len(e.phases) > 0 {
+		phases := make([]any, 0, len(e.phases))
+		for _, phase := range e.phases {
+			phases = append(phases, slog.Duration(phase, e.durations[phase]))
+		}
+		attributes = append(attributes, slog.Group(`phases`, phases...))
+	}
+	e.mu.Unlock()
+
+	e.write(attributes)
+}
@@ -1,47 +1,14 @@
 package main
 
 import (
-	"imageProcessorAPI/handlers"
-	"imageProcessorAPI/middlewares"
-	"log"
-	"time"
-
-	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"imageProcessorAPI/server"
+	"os"
 )
 
+// The OpenAPI document is kept in openapi.json, built with the default
+// configuration since limits show up in it.
+//go:generate sh -c "go run . -print-openapi > openapi.json"
 
 func main(){
-	
-	app := fiber.New();
-
-	app.Use(limiter.New(limiter.Config{
-		Next: func(c *fiber.Ctx) bool{
-			return c.IP() == `127.0.0.1`;
-		},
-		KeyGenerator: func(c *fiber.Ctx) string{
-			return c.IP();
-		},
-		Max: 10,
-		Expiration: time.Second * 30,
-		LimitReached: func (c *fiber.Ctx) error{
-			return c.Status(fiber.StatusTooManyRequests).SendString(`Limit reached.`);
-		},
-	}))
-
-
-	app.Use(middlewares.CheckImageSize);
-
-	app.Post(`/resize`, handlers.Resize);
-	app.Post(`/crop`, handlers.Crop);
-	app.Post(`/flip`, handlers.Flip);
-	app.Post(`/grayscale`, handlers.GrayScale);
-	app.Post(`/changeformat`, handlers.ChangeFormat);
-	app.Post(`/rotate`, handlers.Rotate);
-
-
-	err := app.Listen(`:8000`);
-	if err != nil {
-		log.Fatal(err.Error());
-	}
-}
\ No newline at end of file
+	server.Run(os.Args[1:]);
+}
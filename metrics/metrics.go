@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = `imageprocessor`
+
+// Registry holds every metric of the server, with the Go runtime and process
+// metrics, goroutine counts included.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+// Request durations range from probes answered in microseconds to batches
+// running for minutes.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+var (
+	Requests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `http_requests_total`,
+		Help:      `Requests by route, method and status.`,
+	}, []string{`route`, `method`, `status`})
+
+	RequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      `http_request_duration_seconds`,
+		Help:      `Time to answer a request by route, method and status. Streamed bodies are not included.`,
+		Buckets:   durationBuckets,
+	}, []string{`route`, `method`, `status`})
+
+	InFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      `http_requests_in_flight`,
+		Help:      `Requests being answered.`,
+	})
+
+	PhaseDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      `phase_duration_seconds`,
+		Help:      `Time spent decoding, transforming and encoding images.`,
+		Buckets:   durationBuckets,
+	}, []string{`phase`})
+
+	InputBytes = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `input_bytes_total`,
+		Help:      `Request body bytes read.`,
+	})
+
+	OutputBytes = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `output_bytes_total`,
+		Help:      `Response body bytes written, streamed ones included.`,
+	})
+
+	Megapixels = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `megapixels_total`,
+		Help:      `Megapixels of the images processed, by whether they were an input or an output.`,
+	}, []string{`direction`})
+
+	Rejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `rejections_total`,
+		Help:      `Requests turned away by a limiter, by limiter.`,
+	}, []string{`limiter`})
+
+	Timeouts = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `timeouts_total`,
+		Help:      `Images whose processing ran out of time, by handler.`,
+	}, []string{`handler`})
+
+	DeprecatedRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `deprecated_requests_total`,
+		Help:      `Requests to deprecated API versions by version, route and authentication method, api_key, jwt or anonymous.`,
+	}, []string{`version`, `route`, `authentication`})
+
+	WatchedFiles = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `watched_files_total`,
+		Help:      `Files of the watched directory by result: processed, failed, or skipped as already processed.`,
+	}, []string{`result`})
+
+	CacheLookups = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `cache_lookups_total`,
+		Help:      `Cache lookups by cache and result, hit or miss.`,
+	}, []string{`cache`, `result`})
+)
+
+// The limiters Reject is called with.
+const (
+	LimiterBlocklist = `blocklist`
+	LimiterBodySize  = `bodySize`
+	LimiterRate      = `rate`
+	LimiterQuota     = `quota`
+	LimiterCost      = `cost`
+	LimiterAdmission = `admission`
+)
+
+// The caches ObserveCache is called with.
+const (
+	CacheJWKS       = `jwks`
+	CachePresetETag = `presetETag`
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ObservePhase records a phase that began at start, it is meant to be
+// deferred.
+func ObservePhase(phase string, start time.Time) {
+	PhaseDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+func ObserveWork(inputMegapixels float64, outputMegapixels float64) {
+	Megapixels.WithLabelValues(`input`).Add(inputMegapixels)
+	Megapixels.WithLabelValues(`output`).Add(outputMegapixels)
+}
+
+func Reject(limiter string) {
+	Rejections.WithLabelValues(limiter).Inc()
+}
+
+func ObserveCache(cache string, hit bool) {
+	result := `miss`
+	if hit {
+		result = `hit`
+	}
+
+	CacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// WatchReloads exposes the configuration reload counts stats returns.
+func WatchReloads(stats func() (succeeded int64, failed int64)) {
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `config_reloads_total`,
+		Help:      `Configuration reloads that took effect.`,
+	}, func() float64 {
+		succeeded, _ := stats()
+		return float64(succeeded)
+	})
+
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `config_reload_failures_total`,
+		Help:      `Configuration reloads rejected, the previous configuration was kept.`,
+	}, func() float64 {
+		_, failed := stats()
+		return float64(failed)
+	})
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
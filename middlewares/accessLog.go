@@ -0,0 +1,57 @@
+package middlewares
+
+import (
+	"context"
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/logging"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+
+// AccessLog writes one line per request with its route, status, duration and
+// sizes, followed by what the handlers added, like image dimensions and the
+// time spent in each phase. Streamed responses are logged once handed over
+// work is done, see logging.Hold.
+func AccessLog(app *fiber.App) fiber.Handler{
+
+	routeOf := routeNamer(app);
+
+	return func(c *fiber.Ctx) error{
+
+		start := time.Now();
+		logger := logging.FromContext(c.UserContext());
+
+		var request []slog.Attr;
+		entry := logging.NewEntry(func(attributes []slog.Attr){
+			request = append(request, slog.Duration(`duration`, time.Since(start)));
+			logger.LogAttrs(context.Background(), slog.LevelInfo, `Handled request.`, append(request, attributes...)...);
+		});
+		release := entry.Hold();
+		defer release();
+		c.SetUserContext(logging.NewContext(c.UserContext(), logger, entry));
+
+		err := c.Next();
+
+		request = []slog.Attr{
+			slog.String(`method`, utils.CopyString(c.Method())),
+			slog.String(`route`, routeOf(c)),
+			slog.String(`path`, utils.CopyString(c.Path())),
+			slog.Int(`status`, responseStatus(c, err)),
+			slog.String(`client`, auth.ClientID(c)),
+		};
+		// Bodies still streaming were not read, streamed responses add their
+		// own size.
+		if c.Context().RequestBodyStream() == nil {
+			request = append(request, slog.Int(`bytesIn`, len(c.Request().Body())));
+		}
+		if !c.Response().IsBodyStream() {
+			request = append(request, slog.Int(`bytesOut`, len(c.Response().Body())));
+		}
+
+		return err;
+	}
+}
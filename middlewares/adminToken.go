@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"imageProcessorAPI/problem"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+// RequireAdminToken guards the admin routes with a static bearer token. An
+// empty token disables the admin routes altogether.
+func RequireAdminToken(token string) fiber.Handler{
+
+	return func(c *fiber.Ctx) error{
+
+		if token == `` {
+			return problem.Send(c, problem.AdminAPIDisabled, `Admin API is disabled.`);
+		}
+
+		given := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), `Bearer `);
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			return problem.Send(c, problem.InvalidCredentials, `Invalid admin token.`);
+		}
+
+		return c.Next();
+	}
+}
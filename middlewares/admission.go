@@ -0,0 +1,114 @@
+package middlewares
+
+import (
+	"imageProcessorAPI/admission"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/utilities"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+type AdmitConfig struct {
+	Controller *admission.Controller;
+	// BatchWorkers process the images of a batch in parallel, each holding
+	// one image at a time.
+	BatchWorkers int;
+	// BodyLimits are the limits LimitBody reads bodies up to.
+	BodyLimits BodyLimitConfig;
+}
+
+// admissionLocal holds the *admission.Admission of a request.
+const admissionLocal = `middlewares.admission`;
+
+// Admit runs a request only once the controller has room for the memory it
+// may take, before its body is read so that uploads waiting for admission
+// are not held in memory. That is its body, by its Content-Length or the
+// limit of its route when it is sent in chunks, and the largest images the
+// route may hold at once. Once LimitBody read the body, LowerAdmission lowers
+// the admission to what the uploaded images are estimated to take.
+func Admit(config AdmitConfig) fiber.Handler{
+
+	controller := config.Controller;
+
+	return func(c *fiber.Ctx) error{
+
+		largest := admission.EstimateMemory(utilities.MaxAllowedDimension(), utilities.MaxAllowedDimension());
+		if UnversionedPath(c.Path()) == `/batch` {
+			largest *= int64(max(config.BatchWorkers, 1));
+		}
+		body := config.BodyLimits.limitOf(c);
+		if length := int64(c.Request().Header.ContentLength()); length >= 0 {
+			body = min(body, length);
+		}
+
+		admitted, err := controller.Acquire(c.UserContext(), body + largest);
+		if err != nil {
+			metrics.Reject(metrics.LimiterAdmission);
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(controller.RetryAfter().Seconds())));
+			return problem.Send(c, problem.ServerBusy, admission.ErrSaturated.Error());
+		}
+
+		held := false;
+		c.Locals(admissionLocal, admitted);
+		c.Locals(admission.HoldLocal, func() func(){
+			held = true;
+			return admitted.Release;
+		});
+
+		err = c.Next();
+
+		if !held {
+			admitted.Release();
+		}
+
+		return err;
+	}
+}
+
+// LowerAdmission lowers the admission of the request to the memory its body
+// and the images in it are estimated to take, from their headers. Register it
+// once LimitBody read the body.
+func LowerAdmission(batchWorkers int) fiber.Handler{
+
+	return func(c *fiber.Ctx) error{
+
+		if admitted, ok := c.Locals(admissionLocal).(*admission.Admission); ok {
+			admitted.Lower(estimateMemory(c, batchWorkers));
+		}
+
+		return c.Next();
+	}
+}
+
+// estimateMemory adds up the body of the request, which is held until it is
+// done, and its images. Batches hold the image of every worker at once.
+func estimateMemory(c *fiber.Ctx, batchWorkers int) int64{
+
+	configs := uploadedImageConfigs(c);
+	largest := admission.EstimateMemory(utilities.MaxAllowedDimension(), utilities.MaxAllowedDimension());
+
+	if UnversionedPath(c.Path()) != `/batch` {
+		var memory int64;
+		for _, config := range configs {
+			memory += admission.EstimateMemory(config.Width, config.Height);
+		}
+		if memory == 0 {
+			memory = largest;
+		}
+		return memory + int64(len(c.Request().Body()));
+	}
+
+	workers := batchWorkers;
+	if len(configs) > 0 {
+		workers = min(workers, len(configs));
+		largest = 0;
+		for _, config := range configs {
+			largest = max(largest, admission.EstimateMemory(config.Width, config.Height));
+		}
+	}
+
+	return int64(max(workers, 1)) * largest + int64(len(c.Request().Body()));
+}
@@ -0,0 +1,131 @@
+package middlewares
+
+import (
+	"bufio"
+	"context"
+	"image"
+	"imageProcessorAPI/admission"
+	"imageProcessorAPI/problem"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+func TestAdmitEstimatesBatchesPerWorker(t *testing.T){
+
+	small := admission.EstimateMemory(100, 100);
+	large := admission.EstimateMemory(300, 200);
+	sizes := []image.Point{image.Pt(100, 100), image.Pt(300, 200), image.Pt(100, 100)};
+
+	requests := []struct{
+		path string;
+		workers int;
+		// want is the estimate without the upload, which every request holds.
+		want int64;
+	}{
+		// Single image requests hold every image at once.
+		{`/v2/process`, 2, 2 * small + large},
+		// Each worker may hold the largest image of a batch.
+		{`/v2/batch`, 2, 2 * large},
+		{`/batch`, 8, 3 * large},
+	};
+	for _, request := range requests {
+		var got int64;
+		app := fiber.New();
+		app.Post(`/*`, func(c *fiber.Ctx) error{
+			got = estimateMemory(c, request.workers);
+			return c.SendStatus(fiber.StatusNoContent);
+		});
+
+		upload := newUploadRequest(t, request.path, sizes...);
+		want := request.want + upload.ContentLength;
+
+		response, err := app.Test(upload);
+		if err != nil {
+			t.Fatal(err);
+		}
+		if response.StatusCode != http.StatusNoContent || got != want {
+			t.Fatalf(`%s with %d workers: got status %d estimating %d bytes, want %d`, request.path, request.workers, response.StatusCode, got, want);
+		}
+	}
+}
+
+func TestAdmitChargesBodiesBeforeReadingThem(t *testing.T){
+
+	// Two batch workers with images of the largest allowed size take more
+	// than the whole budget, so a request is admitted with all of it until
+	// its upload is read.
+	controller := admission.NewController(admission.Config{
+		MemoryBudget: 64 << 20,
+		Slots: 4,
+		MaxWait: time.Second,
+	});
+	bodyLimits := BodyLimitConfig{Limit: 1 << 20, Routes: map[string]int64{`/batch`: 256 << 20}};
+
+	app := fiber.New(fiber.Config{
+		StreamRequestBody: true,
+		DisablePreParseMultipartForm: true,
+		ErrorHandler: problem.ErrorHandler,
+	});
+	app.Use(Admit(AdmitConfig{Controller: controller, BatchWorkers: 2, BodyLimits: bodyLimits}));
+	app.Use(LimitBody(bodyLimits));
+	app.Use(LowerAdmission(2));
+	app.Post(`/v2/batch`, func(c *fiber.Ctx) error{
+
+		// Once the upload was read, the admission holds what its small images
+		// take and leaves room for others.
+		other, err := controller.Acquire(c.UserContext(), 32 << 20);
+		if err != nil {
+			return err;
+		}
+		other.Release();
+
+		return c.SendStatus(fiber.StatusNoContent);
+	});
+
+	listener, err := net.Listen(`tcp`, `127.0.0.1:0`);
+	if err != nil {
+		t.Fatal(err);
+	}
+	go app.Listener(listener);
+	t.Cleanup(func(){
+		app.Shutdown();
+	});
+
+	// A chunked upload that does not fit is rejected without any of it sent.
+	held, err := controller.Acquire(context.Background(), 0);
+	if err != nil {
+		t.Fatal(err);
+	}
+	conn, err := net.Dial(`tcp`, listener.Addr().String());
+	if err != nil {
+		t.Fatal(err);
+	}
+	defer conn.Close();
+	_, err = conn.Write([]byte("POST /v2/batch HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\nContent-Type: multipart/form-data; boundary=x\r\n\r\n"));
+	if err != nil {
+		t.Fatal(err);
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second));
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil);
+	if err != nil {
+		t.Fatalf(`got %v, want the upload rejected before it was read`, err);
+	}
+	response.Body.Close();
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf(`got status %d, want %d`, response.StatusCode, http.StatusServiceUnavailable);
+	}
+	held.Release();
+
+	response, err = app.Test(newUploadRequest(t, `/v2/batch`, image.Pt(10, 10), image.Pt(20, 10)), -1);
+	if err != nil {
+		t.Fatal(err);
+	}
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf(`got status %d, want the admission lowered once the upload was read`, response.StatusCode);
+	}
+}
@@ -0,0 +1,94 @@
+package middlewares
+
+import (
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/metrics"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+// Versions of the API are mounted under their prefix. The unversioned paths
+// of the routes from before versioning answer like v1.
+const (
+	APIv1 = api.APIv1;
+	APIv2 = api.APIv2;
+)
+
+// UnversionedPath strips the version prefix off path. Limits are configured
+// by unversioned path, so that every version of a route shares them.
+func UnversionedPath(path string) string{
+
+	for _, prefix := range []string{APIv1, APIv2} {
+		if strings.HasPrefix(path, prefix + `/`) {
+			return strings.TrimPrefix(path, prefix);
+		}
+	}
+
+	return path;
+}
+
+type DeprecationConfig struct {
+	// Version is what requests are counted under.
+	Version string
+	// Deprecated is announced in the Deprecation header, Sunset in the Sunset
+	// header when set. Routes keep being served after their sunset.
+	Deprecated time.Time
+	Sunset time.Time
+	// Successors are the routes replacing the deprecated ones, keyed by
+	// unversioned path, linked as their successor version.
+	Successors map[string]string
+	// Docs is linked as the documentation of the deprecation.
+	Docs string
+}
+
+// Deprecate announces on every response of the routes it is mounted on that
+// they are deprecated, rejections included. Their requests are counted by
+// route and logged with their caller, so that the clients left to migrate
+// are known.
+func Deprecate(app *fiber.App, config DeprecationConfig) fiber.Handler{
+
+	routeOf := routeNamer(app);
+
+	return func(c *fiber.Ctx) error{
+
+		c.Set(`Deprecation`, `@` + strconv.FormatInt(config.Deprecated.Unix(), 10));
+		if !config.Sunset.IsZero() {
+			c.Set(`Sunset`, config.Sunset.UTC().Format(http.TimeFormat));
+		}
+
+		var links []string;
+		if successor, ok := config.Successors[UnversionedPath(c.Path())]; ok {
+			links = append(links, `<` + successor + `>; rel="successor-version"`);
+		}
+		if config.Docs != `` {
+			links = append(links, `<` + config.Docs + `>; rel="deprecation"; type="text/html"`);
+		}
+		if len(links) > 0 {
+			c.Set(fiber.HeaderLink, strings.Join(links, `, `));
+		}
+
+		logging.Annotate(c.UserContext(), slog.String(`deprecatedVersion`, config.Version));
+
+		err := c.Next();
+
+		// The caller is only known once authentication ran. Requests are
+		// counted by how callers authenticated, their key IDs, token subjects
+		// and addresses would make too many series. The access log names the
+		// caller of each request.
+		authentication := `anonymous`;
+		if principal, ok := auth.Caller(c); ok {
+			authentication = principal.Method;
+		}
+		metrics.DeprecatedRequests.WithLabelValues(config.Version, routeOf(c), authentication).Inc();
+
+		return err;
+	}
+}
@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"errors"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/problem"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+const APIKeyHeader = api.APIKeyHeader;
+
+// Authenticate accepts either an API key in the X-API-Key header or a bearer
+// token, and stores the caller for the handlers to check scopes against. A nil
+// verifier means bearer tokens are not accepted.
+func Authenticate(keys *auth.KeyStore, tokens *auth.TokenVerifier) fiber.Handler{
+
+	return func(c *fiber.Ctx) error{
+
+		var principal auth.Principal;
+		var err error;
+
+		authorization := c.Get(fiber.HeaderAuthorization);
+		plain := c.Get(APIKeyHeader);
+		switch {
+		case strings.HasPrefix(authorization, `Bearer `):
+			if tokens == nil {
+				err = auth.ErrTokensDisabled;
+				break;
+			}
+			principal, err = tokens.Verify(strings.TrimPrefix(authorization, `Bearer `));
+
+		case plain != ``:
+			var key auth.APIKey;
+			key, err = keys.Authenticate(plain);
+			principal = auth.Principal{ID: key.ID, Name: key.Name, Method: auth.MethodAPIKey, Scopes: key.Scopes, Quota: key.Quota, CostBudget: key.CostBudget};
+
+		default:
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer, ApiKey header="` + APIKeyHeader + `"`);
+			return problem.Send(c, problem.Unauthenticated, `Missing API key in ` + APIKeyHeader + ` header or bearer token.`);
+		}
+
+		if errors.Is(err, auth.ErrTokenNoScopes) {
+			return problem.Send(c, problem.Forbidden, err.Error());
+		}
+		if err != nil {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`);
+			return problem.Send(c, problem.InvalidCredentials, err.Error());
+		}
+
+		c.Locals(auth.LocalsKey, principal);
+
+		return c.Next();
+	}
+}
@@ -0,0 +1,109 @@
+package middlewares
+
+import (
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/tracing"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+
+type BodyLimitConfig struct {
+	// Limit in bytes of routes without their own.
+	Limit int64
+	// Routes have their own limit, keyed by unversioned path.
+	Routes map[string]int64
+}
+
+// LimitBody reads the request body, which the server streams instead of
+// buffering, and rejects it as soon as it grows past the limit of its route.
+// Bodies within the limit are read into memory whole, register it after
+// Admit, which charges them against the admission budget before they are
+// read. Once it returns the body is available to handlers as usual.
+func LimitBody(config BodyLimitConfig) fiber.Handler{
+
+	return func(c *fiber.Ctx) error{
+
+		limit := config.limitOf(c);
+
+		if int64(c.Request().Header.ContentLength()) > limit {
+			return bodyTooLarge(c);
+		}
+
+		stream := c.Context().RequestBodyStream();
+		if stream == nil {
+			return c.Next();
+		}
+
+		_, span := tracing.Start(c.UserContext(), `read body`);
+		body, err := io.ReadAll(io.LimitReader(stream, limit + 1));
+		span.SetAttributes(attribute.Int(`http.request.body.size`, len(body)));
+		tracing.End(span, &err);
+		if err != nil {
+			c.Context().SetConnectionClose();
+			return problem.Send(c, problem.InvalidRequest, `Could not read request body.`);
+		}
+		if int64(len(body)) > limit {
+			return bodyTooLarge(c);
+		}
+
+		c.Request().SetBodyRaw(body);
+
+		// Uploads are parsed here, for their parsing to have its own span. A
+		// malformed form is reported by whoever needs it.
+		if len(c.Request().Header.MultipartFormBoundary()) > 0 {
+			_, span = tracing.Start(c.UserContext(), `parse upload`);
+			_, err = c.MultipartForm();
+			tracing.End(span, &err);
+		}
+
+		return c.Next();
+	}
+}
+
+// maxUnreadBody is how much of a body left unread is still read to keep its
+// connection, like net/http does.
+const maxUnreadBody = 256 * 1024;
+
+// CloseUnreadBody finishes reading streamed bodies left unread, like uploads
+// of callers rejected before LimitBody, since the server would otherwise read
+// their rest as the next request. Connections of larger leftovers are closed
+// instead.
+func CloseUnreadBody(c *fiber.Ctx) error{
+
+	err := c.Next();
+
+	stream := c.Context().RequestBodyStream();
+	if stream == nil || c.Response().ConnectionClose() {
+		return err;
+	}
+
+	read, readErr := io.Copy(io.Discard, io.LimitReader(stream, maxUnreadBody + 1));
+	if readErr != nil || read > maxUnreadBody {
+		c.Context().SetConnectionClose();
+	}
+
+	return err;
+}
+
+// limitOf is the limit of the route of c.
+func (config BodyLimitConfig) limitOf(c *fiber.Ctx) int64{
+
+	if routeLimit, ok := config.Routes[UnversionedPath(c.Path())]; ok {
+		return routeLimit;
+	}
+
+	return config.Limit;
+}
+
+func bodyTooLarge(c *fiber.Ctx) error{
+
+	metrics.Reject(metrics.LimiterBodySize);
+
+	// The rest of the body is left unread, so the connection cannot be reused.
+	c.Context().SetConnectionClose();
+	return problem.Send(c, problem.RequestTooLarge, `Request body too large.`);
+}
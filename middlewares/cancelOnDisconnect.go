@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"context"
+	"imageProcessorAPI/utilities"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+const disconnectPollInterval = time.Millisecond * 100
+
+// DisconnectHoldLocal holds a func() func() for handlers that keep working
+// after they returned, like streamed responses. Calling it keeps watching the
+// connection until the returned function is called, which then cancels the
+// context.
+const DisconnectHoldLocal = `middlewares.disconnectHold`;
+
+// CancelOnDisconnect cancels the request's user context once the client
+// closes its connection, so handlers stop working for nobody. fasthttp does
+// not notice disconnects while a handler runs, so the connection is polled.
+func CancelOnDisconnect(c *fiber.Ctx) error{
+
+	ctx, cancel := context.WithCancel(c.UserContext());
+	c.SetUserContext(ctx);
+
+	conn := c.Context().Conn();
+	done := make(chan struct{});
+	stopped := make(chan struct{});
+	go func(){
+		defer close(stopped);
+
+		ticker := time.NewTicker(disconnectPollInterval);
+		defer ticker.Stop();
+
+		for {
+			select {
+			case <-done:
+				return;
+			case <-ticker.C:
+				if utilities.ConnClosed(conn) {
+					cancel();
+					return;
+				}
+			}
+		}
+	}();
+
+	// The watcher must be gone before fasthttp reuses the connection.
+	release := sync.OnceFunc(func(){
+		close(done);
+		<-stopped;
+		cancel();
+	});
+
+	held := false;
+	c.Locals(DisconnectHoldLocal, func() func(){
+		held = true;
+		return release;
+	});
+
+	err := c.Next();
+
+	if !held {
+		release();
+	}
+
+	return err;
+}
@@ -0,0 +1,138 @@
+//go:build unix
+
+package middlewares
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+// serve runs app on a local port until the test is done.
+func serve(t *testing.T, app *fiber.App) string{
+
+	listener, err := net.Listen(`tcp`, `127.0.0.1:0`);
+	if err != nil {
+		t.Fatal(err);
+	}
+	go app.Listener(listener);
+	t.Cleanup(func(){
+		app.Shutdown();
+	});
+
+	return listener.Addr().String();
+}
+
+// requestThenHangUp sends a request and closes the connection once the
+// response started, or at once when wait is nil.
+func requestThenHangUp(t *testing.T, address string, path string, wait func(*bufio.Reader)){
+
+	conn, err := net.Dial(`tcp`, address);
+	if err != nil {
+		t.Fatal(err);
+	}
+	defer conn.Close();
+
+	_, err = conn.Write([]byte(`GET ` + path + " HTTP/1.1\r\nHost: test\r\n\r\n"));
+	if err != nil {
+		t.Fatal(err);
+	}
+	if wait != nil {
+		wait(bufio.NewReader(conn));
+	}
+}
+
+// waitCancelled fails t unless ctx arrives and is cancelled in time.
+func waitCancelled(t *testing.T, contexts chan context.Context){
+
+	timeout := time.After(5 * time.Second);
+	select {
+	case ctx := <-contexts:
+		select {
+		case <-ctx.Done():
+		case <-timeout:
+			t.Fatal(`got the context still running, want it cancelled once the client left`);
+		}
+	case <-timeout:
+		t.Fatal(`got no request handled`);
+	}
+}
+
+func TestCancelOnDisconnect(t *testing.T){
+
+	contexts := make(chan context.Context, 1);
+	app := fiber.New(fiber.Config{DisableStartupMessage: true});
+	app.Use(CancelOnDisconnect);
+	app.Get(`/`, func(c *fiber.Ctx) error{
+		contexts <- c.UserContext();
+		select {
+		case <-c.UserContext().Done():
+		case <-time.After(5 * time.Second):
+		}
+		return c.SendStatus(fiber.StatusNoContent);
+	});
+
+	requestThenHangUp(t, serve(t, app), `/`, nil);
+
+	waitCancelled(t, contexts);
+}
+
+func TestCancelOnDisconnectWhileStreaming(t *testing.T){
+
+	contexts := make(chan context.Context, 1);
+	app := fiber.New(fiber.Config{DisableStartupMessage: true});
+	app.Use(CancelOnDisconnect);
+	app.Get(`/`, func(c *fiber.Ctx) error{
+		stopWatching := c.Locals(DisconnectHoldLocal).(func() func())();
+		ctx := c.UserContext();
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer){
+			defer stopWatching();
+
+			if ctx.Err() != nil {
+				t.Error(`got the context cancelled once the handler returned, want it kept for the stream`);
+			}
+			w.WriteString(`started`);
+			w.Flush();
+			contexts <- ctx;
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		});
+		return nil;
+	});
+
+	// The context outlives the handler, the streamed body is still watched.
+	requestThenHangUp(t, serve(t, app), `/`, func(response *bufio.Reader){
+		response.ReadString('\n');
+	});
+
+	waitCancelled(t, contexts);
+}
+
+func TestCancelOnDisconnectEndsWithTheRequest(t *testing.T){
+
+	contexts := make(chan context.Context, 1);
+	app := fiber.New();
+	app.Use(CancelOnDisconnect);
+	app.Get(`/`, func(c *fiber.Ctx) error{
+		contexts <- c.UserContext();
+		return c.SendStatus(fiber.StatusNoContent);
+	});
+
+	_, err := app.Test(httptest.NewRequest(`GET`, `/`, nil));
+	if err != nil {
+		t.Fatal(err);
+	}
+
+	if ctx := <-contexts; ctx.Err() == nil {
+		t.Fatal(`got the context of a finished request still running, want it cancelled`);
+	}
+}
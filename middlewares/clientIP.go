@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"imageProcessorAPI/clientip"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+type ClientIPConfig struct {
+	Resolver *clientip.Resolver
+	// Blocklist rejects clients before they are limited or authenticated,
+	// and before their uploads are read.
+	Blocklist clientip.PrefixList
+}
+
+// ResolveClientIP stores the client address behind trusted proxies for the
+// limiters and quotas, see clientip.FromContext.
+func ResolveClientIP(config ClientIPConfig) fiber.Handler{
+
+	return func(c *fiber.Ctx) error{
+
+		addr := config.Resolver.Resolve(c);
+		c.Locals(clientip.LocalsKey, addr);
+
+		if config.Blocklist.Contains(addr) {
+			metrics.Reject(metrics.LimiterBlocklist);
+			return problem.Send(c, problem.ClientBlocked, `Client address is blocked.`);
+		}
+
+		return c.Next();
+	}
+}
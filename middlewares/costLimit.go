@@ -0,0 +1,78 @@
+package middlewares
+
+import (
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/ratelimit"
+	"math"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+type CostLimitConfig struct {
+	Store ratelimit.Store
+	// Budget applies to callers without a budget of their own.
+	Budget ratelimit.Budget
+}
+
+// CostLimit charges each caller's budget for the pixel work of its requests.
+// An estimate from the upload headers is taken up front, the difference to
+// the work the handlers reported is charged or refunded once they are done.
+func CostLimit(config CostLimitConfig) fiber.Handler{
+
+	return func(c *fiber.Ctx) error{
+
+		key := `cost:` + auth.ClientID(c);
+		principal, _ := auth.Caller(c);
+		policy := principal.CostPolicy(config.Budget);
+
+		estimate := ratelimit.Cost(nil, uploadedMegapixels(c), 0);
+		result, err := config.Store.Take(c.UserContext(), key, policy, estimate);
+		if err != nil {
+			// Failing open, the store already falls back to local limiting.
+			logging.FromContext(c.UserContext()).Error(`Could not take cost budget. Error: ` + err.Error());
+			return c.Next();
+		}
+
+		c.Set(`X-Cost-Budget-Limit`, strconv.Itoa(result.Limit));
+
+		if !result.Allowed {
+			c.Set(`X-Cost-Budget-Remaining`, strconv.Itoa(result.Remaining));
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))));
+			metrics.Reject(metrics.LimiterCost);
+			return problem.Send(c, problem.CostBudgetExhausted, `Pixel processing budget exhausted.`);
+		}
+
+		// Streamed responses charge after the handler returned and the fiber
+		// context was released, so the closure must not touch c.
+		ctx := c.UserContext();
+		charge := func(cost float64){
+			_, err := config.Store.Charge(ctx, key, policy, cost);
+			if err != nil {
+				logging.FromContext(ctx).Error(`Could not charge cost budget. Error: ` + err.Error());
+			}
+		};
+
+		var cost float64;
+		c.Locals(ratelimit.CostLocal, &cost);
+		c.Locals(ratelimit.ChargeLocal, charge);
+
+		err = c.Next();
+
+		// The remaining budget is reported once the work was charged. Streamed
+		// responses charge the rest of their work after their headers were
+		// sent, the header only covers what the handler did before.
+		charged, chargeErr := config.Store.Charge(ctx, key, policy, cost - estimate);
+		if chargeErr != nil {
+			logging.FromContext(ctx).Error(`Could not charge cost budget. Error: ` + chargeErr.Error());
+			charged = result;
+		}
+		c.Set(`X-Cost-Budget-Remaining`, strconv.Itoa(charged.Remaining));
+
+		return err;
+	}
+}
@@ -0,0 +1,100 @@
+package middlewares
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/ratelimit"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+// newUploadRequest posts a multipart form to path with a PNG of each size as
+// its `image` files.
+func newUploadRequest(t *testing.T, path string, sizes ...image.Point) *http.Request{
+
+	body := &bytes.Buffer{};
+	form := multipart.NewWriter(body);
+	for _, size := range sizes {
+		part, err := form.CreateFormFile(`image`, `upload.png`);
+		if err == nil {
+			err = png.Encode(part, image.NewGray(image.Rect(0, 0, size.X, size.Y)));
+		}
+		if err != nil {
+			t.Fatal(err);
+		}
+	}
+	err := form.Close();
+	if err != nil {
+		t.Fatal(err);
+	}
+
+	request := httptest.NewRequest(`POST`, path, body);
+	request.Header.Set(fiber.HeaderContentType, form.FormDataContentType());
+
+	return request;
+}
+
+func TestCostLimitReportsBudgetAfterCharge(t *testing.T){
+
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler});
+	app.Use(CostLimit(CostLimitConfig{
+		Store: ratelimit.NewLocalStore(),
+		Budget: ratelimit.Budget{Units: 100, WindowSeconds: 3600},
+	}));
+	app.Post(`/*`, func(c *fiber.Ctx) error{
+		cost := c.Locals(ratelimit.CostLocal).(*float64);
+		*cost += 10;
+		return c.SendString(`ok`);
+	});
+
+	// The 1 megapixel upload is estimated at 1 unit, the handler reports 10.
+	for i, remaining := range []string{`90`, `80`} {
+		response, err := app.Test(newUploadRequest(t, `/v2/resize`, image.Pt(1000, 1000)));
+		if err != nil {
+			t.Fatal(err);
+		}
+		if response.StatusCode != fiber.StatusOK {
+			t.Fatalf(`request %d: got status %d, want %d`, i + 1, response.StatusCode, fiber.StatusOK);
+		}
+		if got := response.Header.Get(`X-Cost-Budget-Remaining`); got != remaining {
+			t.Fatalf(`request %d: got remaining budget %q, want %q`, i + 1, got, remaining);
+		}
+	}
+}
+
+func TestUploadedImageConfigsAreReadOnce(t *testing.T){
+
+	app := fiber.New();
+	app.Post(`/*`, func(c *fiber.Ctx) error{
+		first := uploadedImageConfigs(c);
+
+		// Later reads must not go back to the upload.
+		form, err := c.MultipartForm();
+		if err != nil {
+			return err;
+		}
+		delete(form.File, `image`);
+
+		second := uploadedImageConfigs(c);
+		if len(first) != 1 || len(second) != 1 || second[0].Width != 30 || second[0].Height != 20 {
+			t.Errorf(`got configs %+v then %+v, want the 30x20 upload both times`, first, second);
+		}
+
+		return c.SendStatus(fiber.StatusNoContent);
+	});
+
+	response, err := app.Test(newUploadRequest(t, `/v2/resize`, image.Pt(30, 20)));
+	if err != nil {
+		t.Fatal(err);
+	}
+	if response.StatusCode != fiber.StatusNoContent {
+		t.Fatalf(`got status %d, want %d`, response.StatusCode, fiber.StatusNoContent);
+	}
+}
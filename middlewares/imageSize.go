@@ -1,29 +1,26 @@
 package middlewares
 
 import (
-	"log/slog"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/utilities"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 
+// CheckImageSize is the upload check of the routes that process one `image`
+// upload, register it on those routes only.
 func CheckImageSize(c *fiber.Ctx) error{
-	
-	const MaxAllowedFileSize = 30 * 1024 * 1024;
 
 	fileheader,err  := c.FormFile(`image`);
 
 	if err != nil {
-		
-		slog.Error(`Could not get image file in check image size middleware. Error: ` + err.Error())
-		return c.Status(500).JSON(fiber.Map{`message`:`Something went wrong.`})
+		return problem.Send(c, problem.MissingImage, `Must upload an image.`);
 	}
 
-	if fileheader.Size > MaxAllowedFileSize{
-		return c.Status(400).JSON(fiber.Map{`message`:`File size too big.`})
+	if fileheader.Size > utilities.MaxAllowedFileSize(){
+		return problem.Send(c, problem.FileTooLarge, `File size too big.`);
 	}
 
 	return c.Next();
 }
-
-
@@ -0,0 +1,94 @@
+package middlewares
+
+import (
+	"errors"
+	"imageProcessorAPI/metrics"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+
+// Instrument counts and times every request. Requests are labelled with the
+// registered path of their route, or `other` for paths no route was
+// registered for, so clients cannot grow the label set.
+func Instrument(app *fiber.App) fiber.Handler{
+
+	routeOf := routeNamer(app);
+
+	return func(c *fiber.Ctx) error{
+
+		metrics.InFlight.Inc();
+		defer metrics.InFlight.Dec();
+		start := time.Now();
+
+		err := c.Next();
+
+		// Fiber reuses the buffer of the method, labels keep a copy.
+		labels := []string{routeOf(c), utils.CopyString(c.Method()), strconv.Itoa(responseStatus(c, err))};
+		metrics.Requests.WithLabelValues(labels...).Inc();
+		metrics.RequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds());
+
+		// Bodies still streaming were not read, streamed responses count
+		// their own bytes.
+		if c.Context().RequestBodyStream() == nil {
+			metrics.InputBytes.Add(float64(len(c.Request().Body())));
+		}
+		if !c.Response().IsBodyStream() {
+			metrics.OutputBytes.Add(float64(len(c.Response().Body())));
+		}
+
+		return err;
+	}
+}
+
+// routeNamer names requests after the registered path of their route, or
+// `other` for paths no route was registered for. A request rejected by a
+// middleware never reached its route, so its path is used when a route was
+// registered for it.
+func routeNamer(app *fiber.App) func(c *fiber.Ctx) string{
+
+	// Routes are all registered once requests come in.
+	var once sync.Once;
+	var routePaths map[string]bool;
+
+	return func(c *fiber.Ctx) string{
+
+		once.Do(func(){
+			routePaths = map[string]bool{};
+			for _, route := range app.GetRoutes(true) {
+				routePaths[route.Path] = true;
+			}
+		});
+
+		if routePaths[c.Route().Path] {
+			return c.Route().Path;
+		}
+		if routePaths[c.Path()] {
+			// Fiber reuses the buffer of the path.
+			return utils.CopyString(c.Path());
+		}
+
+		return `other`;
+	}
+}
+
+// responseStatus is the status the request is answered with. Errors are only
+// turned into a response once every middleware returned, so it is worked out
+// the way the error handler does.
+func responseStatus(c *fiber.Ctx, err error) int{
+
+	if err == nil {
+		return c.Response().StatusCode();
+	}
+
+	var fiberErr *fiber.Error;
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code;
+	}
+
+	return fiber.StatusInternalServerError;
+}
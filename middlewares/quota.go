@@ -0,0 +1,122 @@
+package middlewares
+
+import (
+	"image"
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/quota"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+// EnforceQuota rejects callers that used up their daily or monthly quota and
+// records the usage of every request it lets through. The request is counted
+// before it runs, and refunded if it fails.
+func EnforceQuota(tracker *quota.Tracker, defaults quota.Policy) fiber.Handler{
+
+	return func(c *fiber.Ctx) error{
+
+		client := auth.ClientID(c);
+		principal, _ := auth.Caller(c);
+		policy := principal.QuotaPolicy(defaults);
+
+		reservation, err := tracker.Reserve(client, policy);
+		if exceeded, ok := err.(*quota.ExceededError); ok {
+			retryAfter := int(math.Ceil(time.Until(exceeded.ResetAt).Seconds()));
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter));
+			metrics.Reject(metrics.LimiterQuota);
+			return problem.Send(c, problem.QuotaExceeded, exceeded.Error());
+		}
+
+		c.Locals(quota.RecordStreamLocal, func(bytes int64){
+			tracker.Record(client, quota.Usage{OutputBytes: bytes});
+		});
+
+		err = c.Next();
+
+		// Errors returned by handlers are answered by the error handler
+		// after this, with a failure status.
+		if err != nil || c.Response().StatusCode() >= 400 {
+			reservation.Refund();
+			return err;
+		}
+
+		usage := quota.Usage{Megapixels: requestMegapixels(c)};
+		// Streamed responses record their size once written, reading their
+		// body here would buffer it whole.
+		if !c.Response().IsBodyStream() {
+			usage.OutputBytes = int64(len(c.Response().Body()));
+		}
+		tracker.Record(client, usage);
+
+		return err;
+	}
+}
+
+// requestMegapixels takes what the handler reported, or reads the headers of
+// the uploaded `image` files.
+func requestMegapixels(c *fiber.Ctx) float64{
+
+	if megapixels, ok := c.Locals(quota.MegapixelsLocal).(float64); ok {
+		return megapixels;
+	}
+
+	return uploadedMegapixels(c);
+}
+
+// uploadedMegapixels reads the dimensions of the uploaded `image` files from
+// their headers, without decoding them.
+func uploadedMegapixels(c *fiber.Ctx) float64{
+
+	var megapixels float64;
+	for _, config := range uploadedImageConfigs(c) {
+		megapixels += float64(config.Width) * float64(config.Height) / 1e6;
+	}
+
+	return megapixels;
+}
+
+// uploadedImageConfigsLocal keeps the headers read by uploadedImageConfigs,
+// so the cost, quota and admission middlewares read them once.
+const uploadedImageConfigsLocal = `middlewares.uploadedImageConfigs`;
+
+func uploadedImageConfigs(c *fiber.Ctx) []image.Config{
+
+	if configs, ok := c.Locals(uploadedImageConfigsLocal).([]image.Config); ok {
+		return configs;
+	}
+
+	configs := decodeUploadedImageConfigs(c);
+	c.Locals(uploadedImageConfigsLocal, configs);
+
+	return configs;
+}
+
+func decodeUploadedImageConfigs(c *fiber.Ctx) []image.Config{
+
+	form, err := c.MultipartForm();
+	if err != nil {
+		return nil;
+	}
+
+	var configs []image.Config;
+	for _, fileHeader := range form.File[`image`] {
+		file, err := fileHeader.Open();
+		if err != nil {
+			continue;
+		}
+
+		config, _, err := image.DecodeConfig(file);
+		file.Close();
+		if err == nil {
+			configs = append(configs, config);
+		}
+	}
+
+	return configs;
+}
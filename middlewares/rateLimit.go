@@ -0,0 +1,76 @@
+package middlewares
+
+import (
+	"imageProcessorAPI/clientip"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/ratelimit"
+	"net/netip"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+type RateLimitConfig struct {
+	Store ratelimit.Store
+	Policy ratelimit.Policy
+	// Routes have their own policy and bucket instead of the global one,
+	// keyed by unversioned path. Every version of a route shares its bucket.
+	Routes map[string]ratelimit.Policy
+
+	// Allowlist exempts clients from the limiter.
+	Allowlist clientip.PrefixList
+	// Next skips the limiter when it returns true.
+	Next func(c *fiber.Ctx) bool
+	// KeyGenerator defaults to the client address.
+	KeyGenerator func(c *fiber.Ctx) string
+}
+
+// RateLimit takes one token per request from the caller's bucket and sets the
+// RateLimit-* headers of the IETF ratelimit headers draft.
+func RateLimit(config RateLimitConfig) fiber.Handler{
+
+	if config.KeyGenerator == nil {
+		config.KeyGenerator = clientip.FromContext;
+	}
+
+	return func(c *fiber.Ctx) error{
+
+		if config.Next != nil && config.Next(c) {
+			return c.Next();
+		}
+
+		addr, err := netip.ParseAddr(clientip.FromContext(c));
+		if err == nil && config.Allowlist.Contains(addr) {
+			return c.Next();
+		}
+
+		key, policy := config.KeyGenerator(c), config.Policy;
+		path := UnversionedPath(c.Path());
+		if routePolicy, ok := config.Routes[path]; ok {
+			key, policy = path + `:` + key, routePolicy;
+		}
+
+		result, err := config.Store.Take(c.UserContext(), key, policy, 1);
+		if err != nil {
+			// Failing open, the store already falls back to local limiting.
+			logging.FromContext(c.UserContext()).Error(`Could not take rate limit token. Error: ` + err.Error());
+			return c.Next();
+		}
+
+		c.Set(`RateLimit-Policy`, strconv.Itoa(policy.Limit) + `;w=` + strconv.Itoa(int(policy.Window.Seconds())));
+		c.Set(`RateLimit-Limit`, strconv.Itoa(result.Limit));
+		c.Set(`RateLimit-Remaining`, strconv.Itoa(result.Remaining));
+		c.Set(`RateLimit-Reset`, strconv.Itoa(int(result.Reset.Seconds())));
+
+		if !result.Allowed {
+			metrics.Reject(metrics.LimiterRate);
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(result.RetryAfter.Seconds())));
+			return problem.Send(c, problem.RateLimited, `Limit reached.`);
+		}
+
+		return c.Next();
+	}
+}
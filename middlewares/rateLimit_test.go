@@ -0,0 +1,130 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"imageProcessorAPI/clientip"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/ratelimit"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+func newRateLimitedApp(config RateLimitConfig) *fiber.App{
+
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler});
+	app.Use(RateLimit(config));
+	app.Get(`/*`, func(c *fiber.Ctx) error{
+		return c.SendString(`ok`);
+	});
+
+	return app;
+}
+
+func TestRateLimitHeaders(t *testing.T){
+
+	app := newRateLimitedApp(RateLimitConfig{
+		Store: ratelimit.NewLocalStore(),
+		Policy: ratelimit.Policy{Limit: 2, Window: time.Minute},
+	});
+
+	// Tokens come back every 30 seconds, the bucket is full again once every
+	// taken one is back.
+	expected := []struct{
+		status int;
+		remaining string;
+		reset string;
+		retryAfter string;
+	}{
+		{fiber.StatusOK, `1`, `30`, ``},
+		{fiber.StatusOK, `0`, `60`, ``},
+		{fiber.StatusTooManyRequests, `0`, `60`, `30`},
+	};
+	for i, want := range expected {
+		response, err := app.Test(httptest.NewRequest(`GET`, `/v2/usage`, nil));
+		if err != nil {
+			t.Fatal(err);
+		}
+
+		if response.StatusCode != want.status {
+			t.Fatalf(`request %d: got status %d, want %d`, i + 1, response.StatusCode, want.status);
+		}
+		headers := map[string]string{
+			`RateLimit-Policy`: `2;w=60`,
+			`RateLimit-Limit`: `2`,
+			`RateLimit-Remaining`: want.remaining,
+			`RateLimit-Reset`: want.reset,
+			`Retry-After`: want.retryAfter,
+		};
+		for name, value := range headers {
+			if got := response.Header.Get(name); got != value {
+				t.Fatalf(`request %d: got %s %q, want %q`, i + 1, name, got, value);
+			}
+		}
+
+		if response.StatusCode == fiber.StatusTooManyRequests {
+			body := problem.Problem{};
+			err = json.NewDecoder(response.Body).Decode(&body);
+			if err != nil || body.Code != problem.RateLimited {
+				t.Fatalf(`got problem %+v, want code %s`, body, problem.RateLimited);
+			}
+		}
+	}
+}
+
+func TestRateLimitRoutesHaveTheirOwnBucket(t *testing.T){
+
+	app := newRateLimitedApp(RateLimitConfig{
+		Store: ratelimit.NewLocalStore(),
+		Policy: ratelimit.Policy{Limit: 5, Window: time.Minute},
+		Routes: map[string]ratelimit.Policy{`/batch`: {Limit: 1, Window: time.Minute}},
+	});
+
+	// Both versions of the route share its bucket, other routes are left
+	// the global one.
+	requests := []struct{
+		path string;
+		status int;
+		limit string;
+	}{
+		{`/v2/batch`, fiber.StatusOK, `1`},
+		{`/v1/batch`, fiber.StatusTooManyRequests, `1`},
+		{`/batch`, fiber.StatusTooManyRequests, `1`},
+		{`/v2/usage`, fiber.StatusOK, `5`},
+	};
+	for _, request := range requests {
+		response, err := app.Test(httptest.NewRequest(`GET`, request.path, nil));
+		if err != nil {
+			t.Fatal(err);
+		}
+		if response.StatusCode != request.status || response.Header.Get(`RateLimit-Limit`) != request.limit {
+			t.Fatalf(`%s: got status %d with limit %s, want %d with limit %s`, request.path, response.StatusCode, response.Header.Get(`RateLimit-Limit`), request.status, request.limit);
+		}
+	}
+}
+
+func TestRateLimitAllowlist(t *testing.T){
+
+	allowlist, err := clientip.ParsePrefixes([]string{`0.0.0.0/0`});
+	if err != nil {
+		t.Fatal(err);
+	}
+	app := newRateLimitedApp(RateLimitConfig{
+		Store: ratelimit.NewLocalStore(),
+		Policy: ratelimit.Policy{Limit: 1, Window: time.Minute},
+		Allowlist: allowlist,
+	});
+
+	for range 3 {
+		response, err := app.Test(httptest.NewRequest(`GET`, `/`, nil));
+		if err != nil {
+			t.Fatal(err);
+		}
+		if response.StatusCode != fiber.StatusOK || response.Header.Get(`RateLimit-Limit`) != `` {
+			t.Fatalf(`got status %d with limit %q, want allowlisted clients left alone`, response.StatusCode, response.Header.Get(`RateLimit-Limit`));
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package middlewares
+
+import (
+	"imageProcessorAPI/logging"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+
+const maxRequestIDLength = 128
+
+// RequestID tags the request with the X-Request-ID its client or a proxy
+// sent, or with a new one, and answers with it. The request's logger, see
+// logging.FromContext, carries it along with the trace ID. IDs that are too
+// long or not printable ASCII are replaced, they end up in log lines.
+func RequestID(c *fiber.Ctx) error{
+
+	id := c.Get(fiber.HeaderXRequestID);
+	if validRequestID(id) {
+		// Fiber reuses the buffer of the header.
+		id = utils.CopyString(id);
+	} else {
+		id = utils.UUIDv4();
+	}
+
+	c.Set(fiber.HeaderXRequestID, id);
+	c.Locals(logging.RequestIDLocal, id);
+
+	attributes := []any{slog.String(`requestID`, id)};
+	span := trace.SpanFromContext(c.UserContext());
+	if span.SpanContext().IsValid() {
+		span.SetAttributes(attribute.String(`http.request.id`, id));
+		attributes = append(attributes, slog.String(`traceID`, span.SpanContext().TraceID().String()));
+	}
+	c.SetUserContext(logging.NewContext(c.UserContext(), slog.Default().With(attributes...), nil));
+
+	return c.Next();
+}
+
+func validRequestID(id string) bool{
+
+	if id == `` || len(id) > maxRequestIDLength {
+		return false;
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false;
+		}
+	}
+
+	return true;
+}
@@ -0,0 +1,56 @@
+package middlewares
+
+import (
+	"imageProcessorAPI/logging"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+func TestRequestIDIsEchoedOrGenerated(t *testing.T){
+
+	app := fiber.New();
+	app.Use(RequestID);
+	app.Get(`/`, func(c *fiber.Ctx) error{
+		// Handlers see the ID the client is answered with.
+		return c.SendString(c.Locals(logging.RequestIDLocal).(string));
+	});
+
+	send := func(id string) (string, string){
+		t.Helper();
+
+		request := httptest.NewRequest(`GET`, `/`, nil);
+		if id != `` {
+			request.Header.Set(fiber.HeaderXRequestID, id);
+		}
+		response, err := app.Test(request);
+		if err != nil {
+			t.Fatal(err);
+		}
+		defer response.Body.Close();
+		body, err := io.ReadAll(response.Body);
+		if err != nil {
+			t.Fatal(err);
+		}
+
+		return response.Header.Get(fiber.HeaderXRequestID), string(body);
+	};
+
+	got, seen := send(`trace-7f3a`);
+	if got != `trace-7f3a` || seen != got {
+		t.Fatalf(`got %q answered and %q seen, want the ID of the client`, got, seen);
+	}
+
+	generated := map[string]bool{};
+	for _, id := range []string{``, `has spaces`, "tab\there", strings.Repeat(`x`, maxRequestIDLength + 1)} {
+		got, seen = send(id);
+		if got == id || len(got) != 36 || seen != got || generated[got] {
+			t.Fatalf(`got %q answered and %q seen for %q, want a new ID`, got, seen, id);
+		}
+		generated[got] = true;
+	}
+}
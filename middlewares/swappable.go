@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+
+// Swappable is a middleware that can be replaced while the server runs, so
+// settings baked into a middleware can change when the configuration is
+// reloaded. Requests already in the old middleware finish in it.
+type Swappable struct{
+	handler atomic.Pointer[fiber.Handler];
+}
+
+func NewSwappable(handler fiber.Handler) *Swappable{
+	s := &Swappable{};
+	s.Swap(handler);
+	return s;
+}
+
+func (s *Swappable) Swap(handler fiber.Handler){
+	s.handler.Store(&handler);
+}
+
+func (s *Swappable) Handler(c *fiber.Ctx) error{
+	return (*s.handler.Load())(c);
+}
+
+// Skip is the middleware of a feature that is turned off.
+func Skip(c *fiber.Ctx) error{
+	return c.Next();
+}
@@ -0,0 +1,68 @@
+package middlewares
+
+import (
+	"imageProcessorAPI/tracing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+
+// Trace continues the trace of the W3C trace context headers of the request,
+// or starts one, with a span covering the whole request. Spans of the
+// handlers are its children through the request's user context.
+func Trace(app *fiber.App) fiber.Handler{
+
+	routeOf := routeNamer(app);
+
+	return func(c *fiber.Ctx) error{
+
+		method := utils.CopyString(c.Method());
+		ctx, span := tracing.StartRequest(c.UserContext(), requestHeaderCarrier{c: c}, method,
+			attribute.String(`http.request.method`, method),
+			attribute.String(`url.path`, utils.CopyString(c.Path())),
+		);
+		defer span.End();
+		c.SetUserContext(ctx);
+
+		err := c.Next();
+
+		route := routeOf(c);
+		status := responseStatus(c, err);
+		span.SetName(method + ` ` + route);
+		span.SetAttributes(
+			attribute.String(`http.route`, route),
+			attribute.Int(`http.response.status_code`, status),
+		);
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, ``);
+		}
+
+		return err;
+	}
+}
+
+// requestHeaderCarrier gives the propagator access to the request headers.
+type requestHeaderCarrier struct{
+	c *fiber.Ctx;
+}
+
+func (h requestHeaderCarrier) Get(key string) string{
+	return h.c.Get(key);
+}
+
+func (h requestHeaderCarrier) Set(key string, value string){
+	h.c.Request().Header.Set(key, value);
+}
+
+func (h requestHeaderCarrier) Keys() []string{
+
+	var keys []string;
+	h.c.Request().Header.VisitAll(func(key []byte, value []byte){
+		keys = append(keys, string(key));
+	});
+
+	return keys;
+}
@@ -0,0 +1,121 @@
+package openapi
+
+// Version is the version of the OpenAPI specification documents follow.
+const Version = `3.1.0`
+
+// Document is an OpenAPI document, with the parts of the specification the
+// API needs.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations of a path by lowercase method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string       `json:"operationId,omitempty"`
+	Summary     string       `json:"summary,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	Parameters  []Parameter  `json:"parameters,omitempty"`
+	RequestBody *RequestBody `json:"requestBody,omitempty"`
+	// Responses are keyed by status, or default for every other status.
+	Responses map[string]*Response `json:"responses"`
+	// Security lists the schemes any of which authenticates the operation.
+	Security   []SecurityRequirement `json:"security,omitempty"`
+	Deprecated bool                  `json:"deprecated,omitempty"`
+}
+
+// SecurityRequirement maps the name of a security scheme to its scopes.
+type SecurityRequirement map[string][]string
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+	// Encoding tells the content type of multipart fields.
+	Encoding map[string]Encoding `json:"encoding,omitempty"`
+}
+
+type Encoding struct {
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// Schema is a JSON Schema, as OpenAPI 3.1 uses them.
+type Schema struct {
+	Ref         string    `json:"$ref,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	Format      string    `json:"format,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Const       string    `json:"const,omitempty"`
+	Enum        []string  `json:"enum,omitempty"`
+	Minimum     *float64  `json:"minimum,omitempty"`
+	Maximum     *float64  `json:"maximum,omitempty"`
+	MinLength   *int      `json:"minLength,omitempty"`
+	MaxLength   *int      `json:"maxLength,omitempty"`
+	MinItems    *int      `json:"minItems,omitempty"`
+	MaxItems    *int      `json:"maxItems,omitempty"`
+	Items       *Schema   `json:"items,omitempty"`
+	OneOf       []*Schema `json:"oneOf,omitempty"`
+
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	// AdditionalProperties is the schema of the values of maps.
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+
+	// ContentMediaType and ContentSchema describe strings that hold a
+	// document of their own, like JSON in a form field.
+	ContentMediaType string  `json:"contentMediaType,omitempty"`
+	ContentSchema    *Schema `json:"contentSchema,omitempty"`
+}
+
+// Ref is a schema referring to the component schema of name.
+func Ref(name string) *Schema {
+	return &Schema{Ref: `#/components/schemas/` + name}
+}
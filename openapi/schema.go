@@ -0,0 +1,241 @@
+package openapi
+
+import (
+	"encoding/json"
+	"imageProcessorAPI/validation"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// Generator builds the schemas of Go types from their json tags and the rules
+// of their validate tags. Named structs become component schemas referred to
+// by name.
+type Generator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func NewGenerator() *Generator {
+	return &Generator{
+		schemas: map[string]*Schema{},
+		names:   map[reflect.Type]string{},
+	}
+}
+
+// Define makes schema the component schema of t under name, for types whose
+// JSON their Go type does not tell, like operations whose metadata is kept raw
+// until the operation is known.
+func (g *Generator) Define(t reflect.Type, name string, schema *Schema) {
+	g.names[t] = name
+	g.schemas[name] = schema
+}
+
+// Components are the schemas of the named structs met so far.
+func (g *Generator) Components() map[string]*Schema {
+	return g.schemas
+}
+
+// Schema is the schema of values of t.
+func (g *Generator) Schema(t reflect.Type) *Schema {
+
+	if name, ok := g.names[t]; ok {
+		return Ref(name)
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: `string`, Format: `date-time`}
+	case rawMessageType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.Schema(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: `boolean`}
+	case reflect.String:
+		return &Schema{Type: `string`}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: `integer`}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: `number`}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: `string`, Format: `byte`}
+		}
+		return &Schema{Type: `array`, Items: g.Schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: `object`, AdditionalProperties: g.Schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == `` {
+			return g.structSchema(t)
+		}
+		return Ref(g.component(t))
+	}
+
+	// Interfaces may hold anything.
+	return &Schema{}
+}
+
+// component names the component schema of the named struct t, building it
+// the first time. Names taken by a type of another package are prefixed with
+// the package name.
+func (g *Generator) component(t reflect.Type) string {
+
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if _, taken := g.schemas[name]; taken {
+		name = path.Base(t.PkgPath()) + name
+	}
+
+	// The name is known before the fields are, for types that hold
+	// themselves.
+	g.names[t] = name
+	g.schemas[name] = &Schema{}
+	*g.schemas[name] = *g.structSchema(t)
+
+	return name
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+
+	schema := &Schema{Type: `object`, Properties: map[string]*Schema{}}
+	g.addFields(schema, t)
+	if len(schema.Properties) == 0 {
+		schema.Properties = nil
+	}
+
+	return schema
+}
+
+// addFields adds the fields of t to schema, those of embedded structs as if
+// they were fields of t. Fields of t replace embedded ones of the same name.
+func (g *Generator) addFields(schema *Schema, t reflect.Type) {
+
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get(`json`), `,`)
+		if name == `-` {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == `` && fieldType.Kind() == reflect.Struct {
+			embedded = append(embedded, fieldType)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == `` {
+			name = field.Name
+		}
+
+		property := g.Schema(field.Type)
+		required := applyRules(property, t, field.Tag.Get(`validate`))
+		schema.Properties[name] = property
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	for _, embeddedType := range embedded {
+		inner := g.structSchema(embeddedType)
+		for name, property := range inner.Properties {
+			if _, ok := schema.Properties[name]; !ok {
+				schema.Properties[name] = property
+			}
+		}
+		schema.Required = append(schema.Required, inner.Required...)
+	}
+}
+
+// applyRules adds the rules of a validate tag to schema, the schema of a field
+// of parent, and reports whether the field is required. Rules JSON Schema has
+// no keyword for are described.
+func applyRules(schema *Schema, parent reflect.Type, tag string) bool {
+
+	if tag == `` {
+		return false
+	}
+
+	required := false
+	var descriptions []string
+	rules := strings.Split(tag, `,`)
+	for i, rule := range rules {
+		name, argument, _ := strings.Cut(rule, `=`)
+
+		switch name {
+		case `dive`:
+			if schema.Items != nil {
+				applyRules(schema.Items, parent, strings.Join(rules[i+1:], `,`))
+			}
+			schema.Description = strings.Join(descriptions, ` `)
+			return required
+		case `required`:
+			required = true
+		case `required_without`:
+			descriptions = append(descriptions, `Required when `+siblingName(parent, argument)+` is not set.`)
+		case `min`, `max`:
+			applyBound(schema, name, validation.Limit(argument))
+		case `oneof`:
+			schema.Enum = strings.Fields(argument)
+		case `gtfield`:
+			descriptions = append(descriptions, `Must be greater than `+siblingName(parent, argument)+`.`)
+		case `gtefield`:
+			descriptions = append(descriptions, `Must not be less than `+siblingName(parent, argument)+`.`)
+		}
+	}
+
+	schema.Description = strings.Join(descriptions, ` `)
+	return required
+}
+
+func applyBound(schema *Schema, name string, limit float64) {
+
+	count := int(limit)
+	switch {
+	case schema.Type == `string` && name == `min`:
+		schema.MinLength = &count
+	case schema.Type == `string`:
+		schema.MaxLength = &count
+	case schema.Type == `array` && name == `min`:
+		schema.MinItems = &count
+	case schema.Type == `array`:
+		schema.MaxItems = &count
+	case name == `min`:
+		schema.Minimum = &limit
+	default:
+		schema.Maximum = &limit
+	}
+}
+
+func siblingName(parent reflect.Type, name string) string {
+	field, ok := parent.FieldByName(name)
+	if !ok {
+		return name
+	}
+
+	jsonName, _, _ := strings.Cut(field.Tag.Get(`json`), `,`)
+	if jsonName == `` {
+		return field.Name
+	}
+
+	return jsonName
+}
@@ -0,0 +1,67 @@
+package problem
+
+import "imageProcessorAPI/api"
+
+// Code is the machine-readable kind of a problem. Codes are stable, clients
+// switch on them, while titles and details may be reworded.
+type Code = api.Code
+
+const (
+	// Requests the server could not make sense of.
+	InvalidRequest       = api.InvalidRequest
+	InvalidBody          = api.InvalidBody
+	InvalidMetadata      = api.InvalidMetadata
+	RequestTooLarge      = api.RequestTooLarge
+	UnsupportedMediaType = api.UnsupportedMediaType
+	NotFound             = api.NotFound
+	MethodNotAllowed     = api.MethodNotAllowed
+
+	// Uploads and the parameters of their processing.
+	MissingImage      = api.MissingImage
+	FileTooLarge      = api.FileTooLarge
+	UnsupportedFormat = api.UnsupportedFormat
+	InvalidImage      = api.InvalidImage
+	ImageTooLarge     = api.ImageTooLarge
+	UnknownOperation  = api.UnknownOperation
+	InvalidParameters = api.InvalidParameters
+	InvalidBounds     = api.InvalidBounds
+	InvalidArchive    = api.InvalidArchive
+	ArchiveTooLarge   = api.ArchiveTooLarge
+	TooManyImages     = api.TooManyImages
+
+	// Presets, keys and stored sets.
+	InvalidPreset    = api.InvalidPreset
+	UnknownPreset    = api.UnknownPreset
+	PresetNotFound   = api.PresetNotFound
+	APIKeyNotFound   = api.APIKeyNotFound
+	StorageDisabled  = api.StorageDisabled
+	AdminAPIDisabled = api.AdminAPIDisabled
+
+	// Who the caller is and what it may do.
+	Unauthenticated    = api.Unauthenticated
+	InvalidCredentials = api.InvalidCredentials
+	Forbidden          = api.Forbidden
+	ClientBlocked      = api.ClientBlocked
+
+	// Limits, the response tells when to come back in Retry-After.
+	RateLimited         = api.RateLimited
+	QuotaExceeded       = api.QuotaExceeded
+	CostBudgetExhausted = api.CostBudgetExhausted
+	ServerBusy          = api.ServerBusy
+
+	// Failures on the server's side.
+	ProcessingTimeout = api.ProcessingTimeout
+	InternalError     = api.InternalError
+)
+
+// Definition is the catalog entry of a code.
+type Definition = api.Definition
+
+// Catalog lists every code, grouped like the constants.
+func Catalog() []Definition {
+	return api.Catalog()
+}
+
+func Lookup(code Code) (Definition, bool) {
+	return api.Lookup(code)
+}
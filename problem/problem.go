@@ -0,0 +1,92 @@
+package problem
+
+import (
+	"errors"
+	"imageProcessorAPI/api"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/validation"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ContentType is the media type of RFC 7807 problem details.
+const ContentType = api.ProblemContentType
+
+// TypePrefix is followed by the code in the type of a problem, the catalog
+// entry of the code is served there.
+const TypePrefix = api.ProblemTypePrefix
+
+// Problem is the body of every error response, RFC 7807 problem details
+// extended with the code of the error and the ID of the request.
+type Problem = api.Problem
+
+// Send answers with the problem of code, detail explains what went wrong.
+func Send(c *fiber.Ctx, code Code, detail string) error {
+	return SendFields(c, code, detail, nil)
+}
+
+// SendFields answers with the problem of code, listing the fields of the
+// request that broke a rule.
+func SendFields(c *fiber.Ctx, code Code, detail string, fields validation.Errors) error {
+
+	// Clients only ever see codes of the catalog.
+	definition, ok := Lookup(code)
+	if !ok {
+		code = InternalError
+		definition, _ = Lookup(code)
+	}
+	requestID, _ := c.Locals(logging.RequestIDLocal).(string)
+
+	return c.Status(definition.Status).JSON(Problem{
+		Type:      TypePrefix + string(code),
+		Title:     definition.Title,
+		Status:    definition.Status,
+		Detail:    detail,
+		Instance:  c.OriginalURL(),
+		Code:      code,
+		RequestID: requestID,
+		Errors:    fields,
+	}, ContentType)
+}
+
+// ErrorHandler answers for errors returned to Fiber instead of a response,
+// like unknown routes, with the problem of their status. Details of server
+// errors are logged instead of sent.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+
+	status := fiber.StatusInternalServerError
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	}
+
+	code := codeOfStatus(status)
+	if status >= fiber.StatusInternalServerError {
+		logging.FromContext(c.UserContext()).Error(`Could not handle request. Error: ` + err.Error())
+		return Send(c, code, ``)
+	}
+
+	return Send(c, code, err.Error())
+}
+
+func codeOfStatus(status int) Code {
+	switch status {
+	case fiber.StatusNotFound:
+		return NotFound
+	case fiber.StatusMethodNotAllowed:
+		return MethodNotAllowed
+	case fiber.StatusRequestEntityTooLarge:
+		return RequestTooLarge
+	case fiber.StatusUnsupportedMediaType:
+		return UnsupportedMediaType
+	case fiber.StatusServiceUnavailable:
+		return ServerBusy
+	}
+
+	if status >= http.StatusBadRequest && status < http.StatusInternalServerError {
+		return InvalidRequest
+	}
+
+	return InternalError
+}
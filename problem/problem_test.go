@@ -0,0 +1,123 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/validation"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCatalogDefinesEveryCodeOnce(t *testing.T) {
+
+	seen := map[Code]bool{}
+	for _, definition := range Catalog() {
+		if seen[definition.Code] {
+			t.Fatalf(`got %s defined twice, want it once`, definition.Code)
+		}
+		seen[definition.Code] = true
+
+		if definition.Status < 400 || definition.Status > 599 || definition.Title == `` || definition.Description == `` {
+			t.Fatalf(`got %+v, want an error status, a title and a description`, definition)
+		}
+		if looked, ok := Lookup(definition.Code); !ok || looked != definition {
+			t.Fatalf(`got %+v looking up %s, want %+v`, looked, definition.Code, definition)
+		}
+	}
+
+	// Codes the error handler answers with are all in the catalog.
+	for _, status := range []int{400, 404, 405, 413, 415, 418, 500, 503} {
+		if _, ok := Lookup(codeOfStatus(status)); !ok {
+			t.Fatalf(`got code %s for status %d, want one of the catalog`, codeOfStatus(status), status)
+		}
+	}
+}
+
+func TestProblemsAreProblemDetails(t *testing.T) {
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals(logging.RequestIDLocal, `request-1`)
+		return c.Next()
+	})
+	app.Get(`/fields`, func(c *fiber.Ctx) error {
+		return SendFields(c, InvalidBody, `Invalid body.`, validation.Errors{{Field: `width`, Reason: `must be at least 1`}})
+	})
+	app.Get(`/uncatalogued`, func(c *fiber.Ctx) error {
+		return Send(c, Code(`no_such_code`), `Something.`)
+	})
+	app.Get(`/conflict`, func(c *fiber.Ctx) error {
+		return fiber.NewError(fiber.StatusConflict, `Already there.`)
+	})
+	app.Get(`/failure`, func(c *fiber.Ctx) error {
+		return errors.New(`Could not reach the database at 10.0.0.1.`)
+	})
+
+	requests := []struct {
+		path   string
+		status int
+		code   Code
+		detail string
+	}{
+		{`/fields?width=0`, fiber.StatusBadRequest, InvalidBody, `Invalid body.`},
+		{`/uncatalogued`, fiber.StatusInternalServerError, InternalError, `Something.`},
+		// The status is the one of the code, clients look up either.
+		{`/conflict`, fiber.StatusBadRequest, InvalidRequest, `Already there.`},
+		{`/missing`, fiber.StatusNotFound, NotFound, `Cannot GET /missing`},
+		// Details of server errors stay in the log.
+		{`/failure`, fiber.StatusInternalServerError, InternalError, ``},
+	}
+	for _, request := range requests {
+		response, err := app.Test(httptest.NewRequest(`GET`, request.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if contentType := response.Header.Get(fiber.HeaderContentType); !strings.HasPrefix(contentType, ContentType) {
+			t.Fatalf(`%s: got content type %s, want %s`, request.path, contentType, ContentType)
+		}
+		var fields map[string]json.RawMessage
+		problem := Problem{}
+		err = json.Unmarshal(data, &fields)
+		if err == nil {
+			err = json.Unmarshal(data, &problem)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{`type`, `title`, `status`, `instance`, `code`, `requestId`} {
+			if _, ok := fields[field]; !ok {
+				t.Fatalf(`%s: got %s, want the %s field`, request.path, data, field)
+			}
+		}
+
+		definition, _ := Lookup(request.code)
+		want := Problem{
+			Type:      TypePrefix + string(request.code),
+			Title:     definition.Title,
+			Status:    request.status,
+			Detail:    request.detail,
+			Instance:  request.path,
+			Code:      request.code,
+			RequestID: `request-1`,
+		}
+		if request.code == InvalidBody {
+			want.Errors = validation.Errors{{Field: `width`, Reason: `must be at least 1`}}
+		}
+		if response.StatusCode != request.status || problem.Type != want.Type || problem.Title != want.Title || problem.Status != want.Status ||
+			problem.Detail != want.Detail || problem.Instance != want.Instance || problem.Code != want.Code || problem.RequestID != want.RequestID ||
+			len(problem.Errors) != len(want.Errors) {
+			t.Fatalf(`%s: got status %d and %+v, want %+v`, request.path, response.StatusCode, problem, want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"imageProcessorAPI/api"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Limits caps usage within one period. Zero fields are unlimited.
+type Limits = api.QuotaLimits
+
+type Policy = api.QuotaPolicy
+
+type Usage = api.QuotaUsage
+
+// Counter is the usage of one period, named like 2006-01-02 or 2006-01.
+type Counter struct {
+	Period string `json:"period"`
+	Usage
+}
+
+type ClientUsage struct {
+	Daily   Counter `json:"daily"`
+	Monthly Counter `json:"monthly"`
+}
+
+// ExceededError tells which limit was hit and when it resets.
+type ExceededError struct {
+	Window  string
+	Measure string
+	ResetAt time.Time
+}
+
+func (e *ExceededError) Error() string {
+	return `The ` + e.Window + ` ` + e.Measure + ` quota is exhausted.`
+}
+
+const flushInterval = time.Second * 5
+
+const (
+	// MegapixelsLocal lets a handler report the megapixels it processed when
+	// they cannot be read from the `image` form files.
+	MegapixelsLocal = `quota.megapixels`
+	// RecordStreamLocal holds a func(int64) for handlers that stream their
+	// response, as the output size is only known after the handler returned.
+	RecordStreamLocal = `quota.recordStream`
+)
+
+// Tracker counts usage per client and persists it to a file, so restarts do
+// not reset quotas.
+type Tracker struct {
+	mu      sync.Mutex
+	clients map[string]*ClientUsage
+	path    string
+	dirty   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTracker loads the counters persisted at path and starts flushing them
+// back periodically. Nothing is persisted when path is empty.
+func NewTracker(path string) (*Tracker, error) {
+
+	tracker := &Tracker{
+		clients: map[string]*ClientUsage{},
+		path:    path,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if path != `` {
+		content, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			err = json.Unmarshal(content, &tracker.clients)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	tracker.prune(time.Now().UTC())
+
+	go tracker.flushLoop()
+
+	return tracker, nil
+}
+
+// Reservation is a request counted against a client's quota before it is
+// served.
+type Reservation struct {
+	tracker *Tracker
+	client  string
+	// Periods the request was counted in, a refund after they rolled over
+	// has nothing to take back.
+	day   string
+	month string
+	once  sync.Once
+}
+
+// Reserve counts a request for the client, or returns an ExceededError if it
+// already used up a limit. Checking and counting at once, concurrent requests
+// cannot all be let through on the last request of a quota.
+func (t *Tracker) Reserve(client string, policy Policy) (*Reservation, error) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().UTC()
+	usage := t.current(client, now)
+
+	err := exceeded(`daily`, usage.Daily.Usage, policy.Daily, nextDay(now))
+	if err != nil {
+		return nil, err
+	}
+	err = exceeded(`monthly`, usage.Monthly.Usage, policy.Monthly, nextMonth(now))
+	if err != nil {
+		return nil, err
+	}
+
+	usage.Daily.Requests++
+	usage.Monthly.Requests++
+	t.dirty = true
+
+	return &Reservation{tracker: t, client: client, day: usage.Daily.Period, month: usage.Monthly.Period}, nil
+}
+
+// Refund takes the request back, for requests that failed. Refunding more
+// than once has no effect.
+func (r *Reservation) Refund() {
+	r.once.Do(func() {
+		t := r.tracker
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		usage := t.current(r.client, time.Now().UTC())
+		if usage.Daily.Period == r.day && usage.Daily.Requests > 0 {
+			usage.Daily.Requests--
+		}
+		if usage.Monthly.Period == r.month && usage.Monthly.Requests > 0 {
+			usage.Monthly.Requests--
+		}
+		t.dirty = true
+	})
+}
+
+// Record adds usage to both of the client's current periods. Requests are
+// counted by Reserve.
+func (t *Tracker) Record(client string, usage Usage) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := t.current(client, time.Now().UTC())
+	for _, counter := range []*Counter{&current.Daily, &current.Monthly} {
+		counter.Requests += usage.Requests
+		counter.Megapixels += usage.Megapixels
+		counter.OutputBytes += usage.OutputBytes
+	}
+	t.dirty = true
+}
+
+// Usage returns the client's counters for the current periods.
+func (t *Tracker) Usage(client string) ClientUsage {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return *t.current(client, time.Now().UTC())
+}
+
+// ResetTimes returns when the current daily and monthly periods end.
+func (t *Tracker) ResetTimes() (time.Time, time.Time) {
+	now := time.Now().UTC()
+	return nextDay(now), nextMonth(now)
+}
+
+// Close stops the periodic flush and writes the counters one last time.
+func (t *Tracker) Close() error {
+	close(t.stop)
+	<-t.done
+
+	return t.Flush()
+}
+
+func (t *Tracker) Flush() error {
+
+	t.mu.Lock()
+	if !t.dirty || t.path == `` {
+		t.mu.Unlock()
+		return nil
+	}
+	content, err := json.Marshal(t.clients)
+	t.dirty = false
+	t.mu.Unlock()
+
+	if err == nil {
+		temporaryPath := t.path + `.tmp`
+		err = os.WriteFile(temporaryPath, content, 0o600)
+		if err == nil {
+			err = os.Rename(temporaryPath, t.path)
+		}
+	}
+
+	if err != nil {
+		t.mu.Lock()
+		t.dirty = true
+		t.mu.Unlock()
+	}
+
+	return err
+}
+
+func (t *Tracker) flushLoop() {
+
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.prune(time.Now().UTC())
+			err := t.Flush()
+			if err != nil {
+				slog.Error(`Could not persist quota usage. Error: ` + err.Error())
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// prune drops clients whose monthly period ended, so clients that stopped
+// sending requests do not stay in memory and in the file forever. Their
+// counters would be started anew by current anyway.
+func (t *Tracker) prune(now time.Time) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	month := now.Format(`2006-01`)
+	for client, usage := range t.clients {
+		if usage.Monthly.Period != month {
+			delete(t.clients, client)
+			t.dirty = true
+		}
+	}
+}
+
+// current returns the client's counters, starting new ones when a period
+// rolled over. Must be called with mu held.
+func (t *Tracker) current(client string, now time.Time) *ClientUsage {
+
+	usage, ok := t.clients[client]
+	if !ok {
+		usage = &ClientUsage{}
+		t.clients[client] = usage
+	}
+
+	day, month := now.Format(time.DateOnly), now.Format(`2006-01`)
+	if usage.Daily.Period != day {
+		usage.Daily = Counter{Period: day}
+	}
+	if usage.Monthly.Period != month {
+		usage.Monthly = Counter{Period: month}
+	}
+
+	return usage
+}
+
+func exceeded(window string, usage Usage, limits Limits, resetAt time.Time) error {
+
+	switch {
+	case limits.Requests > 0 && usage.Requests >= limits.Requests:
+		return &ExceededError{Window: window, Measure: `request`, ResetAt: resetAt}
+	case limits.Megapixels > 0 && usage.Megapixels >= limits.Megapixels:
+		return &ExceededError{Window: window, Measure: `megapixel`, ResetAt: resetAt}
+	case limits.OutputBytes > 0 && usage.OutputBytes >= limits.OutputBytes:
+		return &ExceededError{Window: window, Measure: `output bytes`, ResetAt: resetAt}
+	}
+
+	return nil
+}
+
+func nextDay(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextMonth(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
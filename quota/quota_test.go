@@ -0,0 +1,188 @@
+package quota
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestTracker(t *testing.T, path string) *Tracker {
+	t.Helper()
+
+	tracker, err := NewTracker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tracker.Close() })
+
+	return tracker
+}
+
+func TestReserveStopsAtTheLimit(t *testing.T) {
+
+	tracker := newTestTracker(t, ``)
+	policy := Policy{Daily: Limits{Requests: 2}, Monthly: Limits{Requests: 10}}
+
+	first, err := tracker.Reserve(`client`, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tracker.Reserve(`client`, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tracker.Reserve(`client`, policy)
+	exceededError := &ExceededError{}
+	if !errors.As(err, &exceededError) || exceededError.Window != `daily` || exceededError.Measure != `request` {
+		t.Fatalf(`got %v, want the daily request quota exhausted`, err)
+	}
+	if resetAt, _ := tracker.ResetTimes(); !exceededError.ResetAt.Equal(resetAt) {
+		t.Fatalf(`got a reset at %v, want %v`, exceededError.ResetAt, resetAt)
+	}
+
+	// Other clients have their own quota.
+	_, err = tracker.Reserve(`other`, policy)
+	if err != nil {
+		t.Fatalf(`got %v, want the quota of another client untouched`, err)
+	}
+
+	// A refund makes room for one more request, however often it is called.
+	first.Refund()
+	first.Refund()
+	if usage := tracker.Usage(`client`); usage.Daily.Requests != 1 || usage.Monthly.Requests != 1 {
+		t.Fatalf(`got %+v, want one request refunded`, usage)
+	}
+	_, err = tracker.Reserve(`client`, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tracker.Reserve(`client`, policy)
+	if err == nil {
+		t.Fatal(`got no error, want the quota exhausted again`)
+	}
+}
+
+func TestReserveChecksRecordedUsage(t *testing.T) {
+
+	tracker := newTestTracker(t, ``)
+	policy := Policy{Monthly: Limits{Megapixels: 5}}
+
+	_, err := tracker.Reserve(`client`, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Record(`client`, Usage{Megapixels: 5, OutputBytes: 100})
+
+	_, err = tracker.Reserve(`client`, policy)
+	exceededError := &ExceededError{}
+	if !errors.As(err, &exceededError) || exceededError.Window != `monthly` || exceededError.Measure != `megapixel` {
+		t.Fatalf(`got %v, want the monthly megapixel quota exhausted`, err)
+	}
+	if _, resetAt := tracker.ResetTimes(); !exceededError.ResetAt.Equal(resetAt) || resetAt.Day() != 1 {
+		t.Fatalf(`got a reset at %v, want the start of next month %v`, exceededError.ResetAt, resetAt)
+	}
+}
+
+func TestPeriodsRollOver(t *testing.T) {
+
+	tracker := newTestTracker(t, ``)
+	policy := Policy{Daily: Limits{Requests: 1}}
+
+	reservation, err := tracker.Reserve(`client`, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A day later, the daily counter starts over and the monthly one goes on.
+	tracker.mu.Lock()
+	tracker.clients[`client`].Daily.Period = `2000-01-01`
+	tracker.mu.Unlock()
+	usage := tracker.Usage(`client`)
+	if usage.Daily.Requests != 0 || usage.Daily.Period != time.Now().UTC().Format(time.DateOnly) || usage.Monthly.Requests != 1 {
+		t.Fatalf(`got %+v, want a new day of the same month`, usage)
+	}
+	_, err = tracker.Reserve(`client`, policy)
+	if err != nil {
+		t.Fatalf(`got %v, want the daily quota reset`, err)
+	}
+
+	// A refund of the day before takes nothing from the new day.
+	reservation.day = `2000-01-01`
+	reservation.Refund()
+	usage = tracker.Usage(`client`)
+	if usage.Daily.Requests != 1 || usage.Monthly.Requests != 1 {
+		t.Fatalf(`got %+v, want only the monthly request refunded`, usage)
+	}
+
+	// A month later, both start over.
+	tracker.mu.Lock()
+	tracker.clients[`client`].Daily.Period = `2000-01-31`
+	tracker.clients[`client`].Monthly.Period = `2000-01`
+	tracker.mu.Unlock()
+	usage = tracker.Usage(`client`)
+	if usage.Daily.Requests != 0 || usage.Monthly.Requests != 0 || usage.Monthly.Period != time.Now().UTC().Format(`2006-01`) {
+		t.Fatalf(`got %+v, want new periods`, usage)
+	}
+}
+
+func TestUsageIsPersistedAcrossRestarts(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `quota_usage.json`)
+	tracker, err := NewTracker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tracker.Reserve(`client`, Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Record(`client`, Usage{Megapixels: 2.5, OutputBytes: 300})
+	_, err = tracker.Reserve(`stale`, Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.mu.Lock()
+	tracker.clients[`stale`].Monthly.Period = `2000-01`
+	tracker.mu.Unlock()
+	err = tracker.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestTracker(t, path)
+	usage := restarted.Usage(`client`)
+	if usage.Daily.Requests != 1 || usage.Monthly.Megapixels != 2.5 || usage.Monthly.OutputBytes != 300 {
+		t.Fatalf(`got %+v after a restart, want the usage before it`, usage)
+	}
+
+	// Clients of past months are not loaded again.
+	if _, ok := restarted.clients[`stale`]; ok {
+		t.Fatal(`got the client of a past month loaded, want it pruned`)
+	}
+}
+
+func TestPruneDropsClientsOfPastMonths(t *testing.T) {
+
+	tracker := newTestTracker(t, ``)
+	for _, client := range []string{`current`, `yesterday`, `stale`} {
+		_, err := tracker.Reserve(client, Policy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tracker.mu.Lock()
+	tracker.clients[`yesterday`].Daily.Period = `2000-01-01`
+	tracker.clients[`stale`].Monthly.Period = `2000-01`
+	tracker.mu.Unlock()
+
+	tracker.prune(time.Now().UTC())
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if _, ok := tracker.clients[`stale`]; ok || len(tracker.clients) != 2 {
+		t.Fatalf(`got %d clients, want only the one of a past month dropped`, len(tracker.clients))
+	}
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"imageProcessorAPI/api"
+	"strings"
+	"time"
+)
+
+// Budget is a cost Policy as it is configured, with the window in seconds.
+type Budget = api.CostBudget
+
+// BudgetPolicy returns the Policy budget configures.
+func BudgetPolicy(budget Budget) Policy {
+	return Policy{Limit: budget.Units, Window: time.Duration(budget.WindowSeconds) * time.Second}
+}
+
+const (
+	// CostLocal holds a *float64 handlers add the cost of their work to.
+	CostLocal = `ratelimit.cost`
+	// ChargeLocal holds a func(float64) for work done after the handler
+	// returned, like streamed responses.
+	ChargeLocal = `ratelimit.charge`
+
+	// MinimumCost keeps tiny images from being free.
+	MinimumCost = 0.1
+)
+
+// Relative cost of one megapixel through each operation, decoding and
+// encoding included. Arbitrary angle rotation interpolates every pixel and
+// Lanczos resampling reads a wide window, while crops and flips only copy.
+var operationWeights = map[string]float64{
+	`rotate`:       3,
+	`resize`:       2,
+	`grayscale`:    1,
+	`changeformat`: 1,
+	`flip`:         0.5,
+	`crop`:         0.5,
+}
+
+// Cost of running operations on inputMegapixels producing outputMegapixels.
+// Without operations the work is weighted like a format change.
+func Cost(operations []string, inputMegapixels float64, outputMegapixels float64) float64 {
+
+	weight := 0.0
+	for _, operation := range operations {
+		operationWeight, ok := operationWeights[strings.ToLower(operation)]
+		if !ok {
+			operationWeight = 1
+		}
+		weight += operationWeight
+	}
+	if weight == 0 {
+		weight = 1
+	}
+
+	return max(MinimumCost, weight*(inputMegapixels+outputMegapixels))
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	fallbackLogInterval = time.Minute
+
+	// After the primary failed, requests go straight to the fallback for a
+	// backoff doubling with every failed retry, so an unreachable primary
+	// does not cost every request the timeout.
+	minPrimaryBackoff = time.Second
+	maxPrimaryBackoff = time.Second * 30
+)
+
+// FallbackStore uses the shared primary store and falls back to local buckets
+// while the primary is unreachable, so an outage of the store neither blocks
+// nor unthrottles every request.
+type FallbackStore struct {
+	primary  Store
+	fallback Store
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	loggedAt time.Time
+	// failures of the primary in a row. Once it failed, it is only tried
+	// again after retryAt, by one request at a time.
+	failures int
+	retryAt  time.Time
+	probing  bool
+}
+
+func NewFallbackStore(primary Store, fallback Store, timeout time.Duration) *FallbackStore {
+	return &FallbackStore{primary: primary, fallback: fallback, timeout: timeout}
+}
+
+func (s *FallbackStore) Take(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	return s.take(ctx, key, policy, cost, Store.Take)
+}
+
+func (s *FallbackStore) Charge(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	return s.take(ctx, key, policy, cost, Store.Charge)
+}
+
+type takeFunc func(store Store, ctx context.Context, key string, policy Policy, cost float64) (Result, error)
+
+func (s *FallbackStore) take(ctx context.Context, key string, policy Policy, cost float64, take takeFunc) (Result, error) {
+
+	if !s.tryPrimary() {
+		return take(s.fallback, ctx, key, policy, cost)
+	}
+
+	primaryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	result, err := take(s.primary, primaryCtx, key, policy, cost)
+	// A caller that gave up says nothing about the primary.
+	if ctx.Err() == nil {
+		s.report(err)
+	} else {
+		s.mu.Lock()
+		s.probing = false
+		s.mu.Unlock()
+	}
+	if err == nil {
+		return result, nil
+	}
+
+	return take(s.fallback, ctx, key, policy, cost)
+}
+
+// tryPrimary tells whether the primary should be used, it is not while it
+// backs off after a failure.
+func (s *FallbackStore) tryPrimary() bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures == 0 {
+		return true
+	}
+	if s.probing || time.Now().Before(s.retryAt) {
+		return false
+	}
+
+	s.probing = true
+	return true
+}
+
+func (s *FallbackStore) report(err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.probing = false
+	if err == nil {
+		if s.failures > 0 {
+			slog.Info(`Rate limit store reachable again.`)
+		}
+		s.failures = 0
+		return
+	}
+
+	s.failures++
+	backoff := min(minPrimaryBackoff<<min(s.failures-1, 16), maxPrimaryBackoff)
+	s.retryAt = time.Now().Add(backoff)
+
+	if time.Since(s.loggedAt) > fallbackLogInterval {
+		s.loggedAt = time.Now()
+		slog.Error(`Rate limit store unreachable, limiting locally. Error: ` + err.Error())
+	}
+}
@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// stubStore fails while err is set and counts the calls it gets.
+type stubStore struct {
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (s *stubStore) Take(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.err != nil {
+		return Result{}, s.err
+	}
+
+	return Result{Allowed: true, Limit: policy.Limit, Remaining: -1}, nil
+}
+
+func (s *stubStore) Charge(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	return s.Take(ctx, key, policy, cost)
+}
+
+func (s *stubStore) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls
+}
+
+// blackhole accepts connections and never answers, like a Redis behind a
+// dropped route.
+func blackhole(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	return listener.Addr().String()
+}
+
+func TestFallbackStoreSkipsBlackholedRedis(t *testing.T) {
+
+	client := redis.NewClient(&redis.Options{Addr: blackhole(t), MaxRetries: -1, ContextTimeoutEnabled: true})
+	t.Cleanup(func() { client.Close() })
+
+	timeout := time.Millisecond * 100
+	store := NewFallbackStore(NewRedisStore(client, `test:`), NewLocalStore(), timeout)
+	policy := Policy{Limit: 3, Window: time.Minute}
+
+	started := time.Now()
+	result, err := store.Take(context.Background(), `client`, policy, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed < timeout || elapsed > timeout*5 {
+		t.Fatalf(`answered after %s, want about the %s timeout of the primary`, elapsed, timeout)
+	}
+	if !result.Allowed || result.Remaining != 2 {
+		t.Fatalf(`got %+v, want a token of the local bucket`, result)
+	}
+
+	// Later requests do not wait for the primary again.
+	started = time.Now()
+	for i := range 3 {
+		result, err = store.Take(context.Background(), `client`, policy, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed != (i < 2) {
+			t.Fatalf(`take %d: got %+v from the local bucket`, i+2, result)
+		}
+	}
+	if elapsed := time.Since(started); elapsed >= timeout {
+		t.Fatalf(`three requests took %s while the primary was backing off`, elapsed)
+	}
+}
+
+func TestFallbackStoreRetriesPrimaryAfterBackoff(t *testing.T) {
+
+	primary := &stubStore{err: errors.New(`unreachable`)}
+	store := NewFallbackStore(primary, NewLocalStore(), time.Second)
+	policy := Policy{Limit: 10, Window: time.Minute}
+
+	for range 5 {
+		_, err := store.Take(context.Background(), `client`, policy, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if primary.callCount() != 1 {
+		t.Fatalf(`primary called %d times, want once until its backoff ends`, primary.callCount())
+	}
+
+	// The backoff ends while the primary is still down, it doubles.
+	store.mu.Lock()
+	store.retryAt = time.Now()
+	store.mu.Unlock()
+	_, _ = store.Take(context.Background(), `client`, policy, 1)
+	store.mu.Lock()
+	backoff := time.Until(store.retryAt)
+	store.mu.Unlock()
+	if primary.callCount() != 2 || backoff <= minPrimaryBackoff || backoff > minPrimaryBackoff*2 {
+		t.Fatalf(`primary called %d times backing off %s, want a second call and a doubled backoff`, primary.callCount(), backoff)
+	}
+
+	// Once the primary answers again, every request uses it.
+	primary.mu.Lock()
+	primary.err = nil
+	primary.mu.Unlock()
+	store.mu.Lock()
+	store.retryAt = time.Now()
+	store.mu.Unlock()
+	for range 3 {
+		result, err := store.Take(context.Background(), `client`, policy, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Remaining != -1 {
+			t.Fatalf(`got %+v, want the result of the primary`, result)
+		}
+	}
+	if primary.callCount() != 5 {
+		t.Fatalf(`primary called %d times, want 5`, primary.callCount())
+	}
+}
+
+func TestFallbackStoreProbesPrimaryOnce(t *testing.T) {
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	primary := storeFunc(func(ctx context.Context) (Result, error) {
+		calls.Add(1)
+		<-release
+		return Result{}, errors.New(`unreachable`)
+	})
+	store := NewFallbackStore(primary, NewLocalStore(), time.Second)
+	store.failures = 1
+	policy := Policy{Limit: 10, Window: time.Minute}
+
+	// One request probes the primary, the others meanwhile use the fallback.
+	probed := make(chan struct{})
+	go func() {
+		defer close(probed)
+		store.Take(context.Background(), `client`, policy, 1)
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for range 3 {
+		_, err := store.Take(context.Background(), `client`, policy, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	<-probed
+
+	if calls.Load() != 1 {
+		t.Fatalf(`primary called %d times, want a single probe`, calls.Load())
+	}
+}
+
+func TestFallbackStoreIgnoresCallersGivingUp(t *testing.T) {
+
+	primary := &stubStore{err: context.Canceled}
+	store := NewFallbackStore(primary, NewLocalStore(), time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := store.Take(ctx, `client`, Policy{Limit: 1, Window: time.Minute}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store.failures != 0 {
+		t.Fatal(`a cancelled caller was counted as a failure of the primary`)
+	}
+}
+
+type storeFunc func(ctx context.Context) (Result, error)
+
+func (f storeFunc) Take(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	return f(ctx)
+}
+
+func (f storeFunc) Charge(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	return f(ctx)
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LocalStore keeps buckets in process memory. Behind a load balancer every
+// instance has its own, so it is only exact for a single instance.
+type LocalStore struct {
+	mu      sync.Mutex
+	buckets map[string]*localBucket
+	swept   time.Time
+}
+
+// localBucket is a bucket with when it is full again under the policy it was
+// last used with, from then on it is the same as no bucket.
+type localBucket struct {
+	bucket
+	full time.Time
+}
+
+// sweepInterval is how often full buckets are dropped.
+const sweepInterval = time.Minute
+
+func NewLocalStore() *LocalStore {
+	return &LocalStore{buckets: map[string]*localBucket{}, swept: time.Now()}
+}
+
+func (s *LocalStore) Take(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	return s.take(key, policy, cost, false), nil
+}
+
+func (s *LocalStore) Charge(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	return s.take(key, policy, cost, true), nil
+}
+
+func (s *LocalStore) take(key string, policy Policy, cost float64, force bool) Result {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &localBucket{bucket: bucket{tokens: float64(policy.Limit), at: now}}
+		s.buckets[key] = b
+	}
+
+	result := b.take(now, policy, cost, force)
+	rate := float64(policy.Limit) / policy.Window.Seconds()
+	b.full = now.Add(time.Duration((float64(policy.Limit) - b.tokens) / rate * float64(time.Second)))
+
+	return result
+}
+
+// sweep drops buckets that are full again. Must be called with mu held.
+func (s *LocalStore) sweep(now time.Time) {
+
+	if now.Sub(s.swept) < sweepInterval {
+		return
+	}
+	s.swept = now
+
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
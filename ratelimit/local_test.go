@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreSweepsBucketsByTheirOwnPolicy(t *testing.T) {
+
+	store := NewLocalStore()
+	hourly := Policy{Limit: 1, Window: time.Hour}
+	brief := Policy{Limit: 1, Window: time.Millisecond}
+
+	_, err := store.Take(context.Background(), `hourly`, hourly, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Take(context.Background(), `brief`, brief, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A sweep run by a caller of the brief policy only drops its own full
+	// bucket, the hourly one is still empty.
+	time.Sleep(10 * time.Millisecond)
+	store.swept = time.Now().Add(-sweepInterval)
+	_, err = store.Take(context.Background(), `other`, brief, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.buckets[`brief`]; ok {
+		t.Fatal(`got the full brief bucket kept, want it swept`)
+	}
+	result, err := store.Take(context.Background(), `hourly`, hourly, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatalf(`got %+v, want the hourly bucket still empty`, result)
+	}
+}
+
+func TestLocalStoreKeepsBucketsInDebt(t *testing.T) {
+
+	store := NewLocalStore()
+	policy := Policy{Limit: 10, Window: time.Second}
+
+	// Three windows of debt take three windows to pay back.
+	_, err := store.Charge(context.Background(), `client`, policy, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.swept = time.Now().Add(-sweepInterval)
+	store.buckets[`client`].at = time.Now().Add(-1500 * time.Millisecond)
+	store.buckets[`client`].full = store.buckets[`client`].full.Add(-1500 * time.Millisecond)
+
+	result, err := store.Take(context.Background(), `client`, policy, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatalf(`got %+v, want the debt still owed after a window and a half`, result)
+	}
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy allows Limit units of cost per Window. Limits are token buckets: a
+// caller can burst up to Limit at once and regains Limit/Window per second.
+type Policy struct {
+	Limit  int
+	Window time.Duration
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the bucket would be full again.
+	Reset time.Duration
+	// RetryAfter is when enough tokens for the rejected cost are back.
+	RetryAfter time.Duration
+}
+
+// Store takes cost tokens from the bucket at key. Charge takes them even when
+// there are not enough, leaving the bucket in debt, for costs only known
+// after the work was done.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy, cost float64) (Result, error)
+	Charge(ctx context.Context, key string, policy Policy, cost float64) (Result, error)
+}
+
+// bucket is the state of one token bucket.
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// take refills the bucket for the time passed since it was last used and
+// takes cost from it if there is enough, or anyway when forced.
+func (b *bucket) take(now time.Time, policy Policy, cost float64, force bool) Result {
+
+	rate := float64(policy.Limit) / policy.Window.Seconds()
+	if now.After(b.at) {
+		b.tokens = math.Min(float64(policy.Limit), b.tokens+now.Sub(b.at).Seconds()*rate)
+		b.at = now
+	}
+
+	allowed := b.tokens >= cost
+	if allowed || force {
+		// A negative charge refunds an estimate that was too high.
+		b.tokens = math.Min(float64(policy.Limit), b.tokens-cost)
+	}
+
+	return newResult(allowed, policy, b.tokens, cost)
+}
+
+func newResult(allowed bool, policy Policy, tokens float64, cost float64) Result {
+
+	rate := float64(policy.Limit) / policy.Window.Seconds()
+	result := Result{
+		Allowed:   allowed,
+		Limit:     policy.Limit,
+		Remaining: max(0, int(math.Floor(tokens))),
+		Reset:     seconds((float64(policy.Limit) - tokens) / rate),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((cost - tokens) / rate)
+	}
+
+	return result
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(math.Ceil(max(0, value))) * time.Second
+}
+
+// ParsePolicy reads a policy written like 10/30s, a limit per window.
+func ParsePolicy(policy string) (Policy, error) {
+
+	limit, window, ok := strings.Cut(strings.TrimSpace(policy), `/`)
+	if !ok {
+		return Policy{}, errors.New(`Rate limit policy must look like 10/30s.`)
+	}
+
+	parsedLimit, err := strconv.Atoi(limit)
+	if err != nil || parsedLimit <= 0 {
+		return Policy{}, errors.New(`Rate limit must be a positive number: ` + limit)
+	}
+	parsedWindow, err := time.ParseDuration(window)
+	if err != nil || parsedWindow <= 0 {
+		return Policy{}, errors.New(`Rate limit window must be a positive duration: ` + window)
+	}
+
+	return Policy{Limit: parsedLimit, Window: parsedWindow}, nil
+}
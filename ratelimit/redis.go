@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"context"
+	"imageProcessorAPI/tracing"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// takeScript is the bucket take of bucket.take, run atomically in the store
+// so every instance sees the same bucket.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local force = ARGV[6] == '1'
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(state[1])
+local at = tonumber(state[2])
+if tokens == nil or at == nil then
+	tokens = capacity
+	at = now
+end
+
+if now > at then
+	tokens = math.min(capacity, tokens + (now - at) * rate)
+	at = now
+end
+
+local allowed = 0
+if tokens >= cost then
+	allowed = 1
+end
+if allowed == 1 or force then
+	tokens = math.min(capacity, tokens - cost)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', tostring(at))
+redis.call('PEXPIRE', KEYS[1], ttl)
+
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore keeps buckets in a Redis compatible server shared by every
+// instance. Instances are expected to have synchronised clocks.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	return s.take(ctx, key, policy, cost, false)
+}
+
+func (s *RedisStore) Charge(ctx context.Context, key string, policy Policy, cost float64) (Result, error) {
+	return s.take(ctx, key, policy, cost, true)
+}
+
+func (s *RedisStore) take(ctx context.Context, key string, policy Policy, cost float64, force bool) (_ Result, err error) {
+	ctx, span := tracing.Start(ctx, `redis ratelimit take`, attribute.String(`db.system`, `redis`))
+	defer tracing.End(span, &err)
+
+	forceFlag := 0
+	if force {
+		forceFlag = 1
+	}
+
+	now := time.Now()
+	rate := float64(policy.Limit) / float64(policy.Window.Milliseconds())
+	// A bucket unused for a window is full, so it can expire after that. One
+	// in debt may be forgiven early, which only errs on the lenient side.
+	ttl := policy.Window.Milliseconds() + 1000
+
+	reply, err := takeScript.Run(ctx, s.client, []string{s.prefix + key},
+		policy.Limit, rate, now.UnixMilli(), cost, ttl, forceFlag).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	allowed, _ := reply[0].(int64)
+	tokensText, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(tokensText, 64)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(allowed == 1, policy, tokens, cost), nil
+}
@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedisStore(client, `test:`), server
+}
+
+func TestRedisStoreTakesUntilEmpty(t *testing.T) {
+
+	store, _ := newTestRedisStore(t)
+	policy := Policy{Limit: 3, Window: time.Minute}
+
+	for i := range 3 {
+		result, err := store.Take(context.Background(), `client`, policy, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != 2-i || result.Limit != 3 {
+			t.Fatalf(`take %d: got %+v, want allowed with %d remaining`, i+1, result, 2-i)
+		}
+	}
+
+	result, err := store.Take(context.Background(), `client`, policy, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One token comes back every 20 seconds.
+	if result.Allowed || result.Remaining != 0 || result.RetryAfter != time.Second*20 || result.Reset != time.Minute {
+		t.Fatalf(`got %+v, want a rejection retrying after 20s and resetting after 1m`, result)
+	}
+
+	other, err := store.Take(context.Background(), `other`, policy, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !other.Allowed || other.Remaining != 2 {
+		t.Fatalf(`got %+v for another key, want a bucket of its own`, other)
+	}
+}
+
+func TestRedisStoreRefills(t *testing.T) {
+
+	store, _ := newTestRedisStore(t)
+	policy := Policy{Limit: 2, Window: time.Millisecond * 200}
+
+	for range 2 {
+		_, err := store.Take(context.Background(), `client`, policy, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, _ := store.Take(context.Background(), `client`, policy, 1)
+	if result.Allowed {
+		t.Fatal(`took a token from an empty bucket`)
+	}
+
+	time.Sleep(time.Millisecond * 120)
+
+	result, err := store.Take(context.Background(), `client`, policy, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed {
+		t.Fatalf(`got %+v, want the token refilled after 100ms`, result)
+	}
+}
+
+func TestRedisStoreChargeLeavesDebt(t *testing.T) {
+
+	store, _ := newTestRedisStore(t)
+	policy := Policy{Limit: 3, Window: time.Minute}
+
+	result, err := store.Charge(context.Background(), `client`, policy, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf(`got %+v, want the charge reported as over the budget`, result)
+	}
+
+	// Two tokens of debt and the one asked for take a minute to come back.
+	result, err = store.Take(context.Background(), `client`, policy, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.RetryAfter != time.Minute {
+		t.Fatalf(`got %+v, want a rejection retrying after 1m`, result)
+	}
+
+	// A negative charge refunds, but never past a full bucket.
+	result, err = store.Charge(context.Background(), `client`, policy, -10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Remaining != 3 {
+		t.Fatalf(`got %+v, want a full bucket after the refund`, result)
+	}
+}
+
+func TestRedisStoreExpiresIdleBuckets(t *testing.T) {
+
+	store, server := newTestRedisStore(t)
+	policy := Policy{Limit: 2, Window: time.Minute}
+
+	_, err := store.Take(context.Background(), `client`, policy, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ttl := server.TTL(`test:client`)
+	if ttl <= policy.Window || ttl > policy.Window+time.Second {
+		t.Fatalf(`bucket expires after %s, want a little over its window`, ttl)
+	}
+
+	server.FastForward(ttl)
+	if server.Exists(`test:client`) {
+		t.Fatal(`idle bucket was not expired`)
+	}
+}
+
+// The script must take from buckets exactly like the local store does, as the
+// local store stands in for it while Redis is unreachable.
+func TestRedisStoreMatchesLocalStore(t *testing.T) {
+
+	redisStore, _ := newTestRedisStore(t)
+	localStore := NewLocalStore()
+	policy := Policy{Limit: 5, Window: time.Hour}
+
+	steps := []struct {
+		charge bool
+		cost   float64
+	}{
+		{false, 1}, {false, 2.5}, {true, 0.25}, {false, 2}, {true, 3}, {false, 1}, {true, -4}, {false, 1},
+	}
+	for i, step := range steps {
+		take := Store.Take
+		if step.charge {
+			take = Store.Charge
+		}
+
+		want, _ := take(localStore, context.Background(), `client`, policy, step.cost)
+		got, err := take(redisStore, context.Background(), `client`, policy, step.cost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Allowed != want.Allowed || got.Remaining != want.Remaining || got.RetryAfter != want.RetryAfter {
+			t.Fatalf(`step %d: got %+v from redis, want %+v like the local store`, i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bytes"
+	"imageProcessorAPI/config"
+	"imageProcessorAPI/handlers"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+
+// TestOpenAPIDocumentIsUpToDate builds the document of the routes of the
+// default configuration, like go generate does, and compares it with the one
+// kept in the repository.
+func TestOpenAPIDocumentIsUpToDate(t *testing.T){
+
+	dir := t.TempDir();
+	cfg := config.Default();
+	cfg.Auth.APIKeysFile = filepath.Join(dir, `api_keys.json`);
+	cfg.PresetStoreFile = filepath.Join(dir, `preset_store.json`);
+	cfg.Quota.File = filepath.Join(dir, `quota_usage.json`);
+
+	srv, err := New(cfg);
+	if err != nil {
+		t.Fatal(err);
+	}
+	defer srv.Close();
+
+	document, err := handlers.OpenAPIJSON(srv.App.GetRoutes(true));
+	if err != nil {
+		t.Fatal(err);
+	}
+	kept, err := os.ReadFile(filepath.Join(`..`, `openapi.json`));
+	if err != nil {
+		t.Fatal(err);
+	}
+
+	if !bytes.Equal(document, kept) {
+		documentLines := bytes.Split(document, []byte("\n"));
+		keptLines := bytes.Split(kept, []byte("\n"));
+		for i := 0; i < len(documentLines) && i < len(keptLines); i++ {
+			if !bytes.Equal(documentLines[i], keptLines[i]) {
+				t.Fatalf(`got %q on line %d of openapi.json, want %q, regenerate it with go generate`, keptLines[i], i + 1, documentLines[i]);
+			}
+		}
+		t.Fatalf(`got %d lines in openapi.json, want %d, regenerate it with go generate`, len(keptLines), len(documentLines));
+	}
+}
@@ -0,0 +1,594 @@
+// Package server builds the HTTP API from its configuration and serves it,
+// for the server binary and the serve command of imgproc alike.
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"imageProcessorAPI/admission"
+	"imageProcessorAPI/auth"
+	"imageProcessorAPI/clientip"
+	"imageProcessorAPI/config"
+	"imageProcessorAPI/handlers"
+	"imageProcessorAPI/health"
+	"imageProcessorAPI/logging"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/middlewares"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/quota"
+	"imageProcessorAPI/ratelimit"
+	"imageProcessorAPI/tracing"
+	"imageProcessorAPI/utilities"
+	"imageProcessorAPI/watch"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Run configures the server from args, the flags of the command line, and
+// serves until SIGINT or SIGTERM. Invalid configurations and failures to
+// start exit the process.
+func Run(args []string){
+
+	cfg, options, err := config.Load(args);
+	if errors.Is(err, flag.ErrHelp) {
+		return;
+	}
+	if err != nil {
+		log.Fatal(`Invalid configuration. Error: ` + err.Error());
+	}
+
+	if options.PrintConfig {
+		err = cfg.Print(os.Stdout);
+		if err != nil {
+			log.Fatal(err.Error());
+		}
+		return;
+	}
+
+	logging.Setup(cfg.Log.Format);
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter: cfg.Tracing.Exporter,
+		Endpoint: cfg.Tracing.Endpoint,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: cfg.Tracing.ServiceName,
+	});
+	if err != nil {
+		log.Fatal(`Could not set up tracing. Error: ` + err.Error());
+	}
+	defer func(){
+		// Spans still buffered are exported before exiting.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second * 5);
+		defer cancel();
+
+		err := shutdownTracing(ctx);
+		if err != nil {
+			slog.Error(`Could not export the remaining spans. Error: ` + err.Error());
+		}
+	}();
+
+	srv, err := New(cfg);
+	if err != nil {
+		log.Fatal(err.Error());
+	}
+	defer srv.Close();
+
+	if options.PrintOpenAPI || options.CheckOpenAPI != `` {
+		err = writeOpenAPI(srv.App, options);
+		if err != nil {
+			log.Fatal(err.Error());
+		}
+		return;
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM);
+	defer stop();
+
+	reloader := config.NewReloader(args, cfg, options, srv.Apply);
+	// Keys created or revoked with the admin API are no reason to reload.
+	reloader.Own(cfg.Auth.APIKeysFile, srv.apiKeys.Written);
+	metrics.WatchReloads(func() (int64, int64){
+		stats := reloader.Stats();
+		return stats.Succeeded, stats.Failed;
+	});
+	go reloader.Run(ctx);
+
+	// The watcher stops scanning on shutdown, the images it is processing are
+	// finished before the server exits.
+	watchDone := make(chan struct{});
+	go func(){
+		defer close(watchDone);
+
+		srv.Watch(ctx);
+	}();
+
+	// On SIGINT or SIGTERM the server turns unready, stops accepting requests
+	// and waits for the ones in flight, streamed batches included. Usage is
+	// persisted by the deferred closes once they are done.
+	shutdownDone := make(chan struct{});
+	go func(){
+		defer close(shutdownDone);
+
+		<-ctx.Done();
+		// A second signal kills the server without waiting.
+		stop();
+
+		timeouts := reloader.Current().Timeouts;
+		slog.Info(`Shutting down, draining requests.`);
+		srv.health.Drain();
+		time.Sleep(timeouts.DrainDelay);
+
+		err := srv.App.ShutdownWithTimeout(timeouts.Shutdown);
+		if err != nil {
+			slog.Error(`Could not finish in-flight requests before shutting down. Error: ` + err.Error());
+		}
+	}();
+
+	err = srv.App.Listen(cfg.Listen);
+	if err != nil {
+		log.Fatal(err.Error());
+	}
+
+	<-shutdownDone;
+	<-watchDone;
+	slog.Info(`Server stopped.`);
+}
+
+// Server is the HTTP API of a configuration, with the watched directory it
+// processes alongside.
+type Server struct {
+	App *fiber.App;
+
+	watcher *watch.Watcher;
+	apiKeys *auth.KeyStore;
+	// health is what readiness reports on, Run drains it on shutdown.
+	health *health.Checker;
+	// apply makes a configuration take effect, see Apply.
+	apply func(config.Config) error;
+	// closers release what New opened.
+	closers []func();
+}
+
+// New builds the routes and middlewares of the server from cfg, ready to
+// listen. Close releases the stores and connections it opened.
+func New(cfg config.Config) (*Server, error){
+
+	// Bodies are streamed so LimitBody can stop reading them at the limit of
+	// their route, instead of the server buffering them whole first. Uploads
+	// of known length would otherwise still be read whole into temporary files.
+	app := fiber.New(fiber.Config{
+		StreamRequestBody: true,
+		DisablePreParseMultipartForm: true,
+		ErrorHandler: problem.ErrorHandler,
+	});
+	s := &Server{App: app, health: health.NewChecker()};
+
+	app.Use(middlewares.Instrument(app));
+
+	// Probes and scrapes are answered ahead of every other middleware, they
+	// must not be limited, authenticated or held up by admission.
+	app.Get(`/healthz`, handlers.Liveness);
+	app.Get(`/readyz`, handlers.Readiness(s.health));
+	app.Get(`/metrics`, metrics.Handler());
+
+	app.Use(middlewares.Trace(app));
+	app.Use(middlewares.RequestID);
+	app.Use(middlewares.AccessLog(app));
+
+	// v1 is served under /v1 and, for clients from before versioning, at the
+	// root. Both are deprecated in favor of v2, which applyConfig announces
+	// once a deprecation date is configured. Rejections are announced too.
+	imageRoutes := []struct{
+		path string;
+		handler fiber.Handler;
+	}{
+		{`/resize`, handlers.Resize},
+		{`/crop`, handlers.Crop},
+		{`/flip`, handlers.Flip},
+		{`/grayscale`, handlers.GrayScale},
+		{`/changeformat`, handlers.ChangeFormat},
+		{`/rotate`, handlers.Rotate},
+	};
+	v1Successors := map[string]string{
+		`/usage`: middlewares.APIv2 + `/usage`,
+		`/batch`: middlewares.APIv2 + `/batch`,
+		`/responsive`: middlewares.APIv2 + `/responsive`,
+	};
+	for _, route := range imageRoutes {
+		v1Successors[route.path] = middlewares.APIv2 + `/process`;
+	}
+	v1Paths := []string{middlewares.APIv1};
+	for path := range v1Successors {
+		v1Paths = append(v1Paths, path);
+	}
+	deprecateV1 := middlewares.NewSwappable(middlewares.Skip);
+	app.Use(v1Paths, deprecateV1.Handler);
+
+	app.Use(middlewares.CancelOnDisconnect);
+	app.Use(middlewares.CloseUnreadBody);
+
+	// Bodies are only read once the caller was let through and admitted, see
+	// below.
+	limitBody := middlewares.NewSwappable(middlewares.Skip);
+	admit := middlewares.NewSwappable(middlewares.Skip);
+
+	var rateLimitStore ratelimit.Store = ratelimit.NewLocalStore();
+	if cfg.RateLimit.RedisAddr != `` {
+		// The fallback store bounds every call with its timeout, which the
+		// client only respects with context timeouts enabled.
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: cfg.RateLimit.RedisAddr,
+			Password: cfg.RateLimit.RedisPassword,
+			ContextTimeoutEnabled: true,
+		});
+		s.closers = append(s.closers, func(){
+			redisClient.Close();
+		});
+
+		s.health.Add(`redis`, func(ctx context.Context) error{
+			return redisClient.Ping(ctx).Err();
+		});
+
+		rateLimitStore = ratelimit.NewFallbackStore(
+			ratelimit.NewRedisStore(redisClient, `imageProcessor:ratelimit:`),
+			rateLimitStore,
+			time.Millisecond * 200,
+		);
+	}
+
+	apiKeys, err := auth.NewKeyStore(cfg.Auth.APIKeysFile);
+	if err != nil {
+		return s.fail(errors.New(`Could not load API keys. Error: ` + err.Error()));
+	}
+	s.apiKeys = apiKeys;
+
+	presetStore, err := handlers.NewPresetStore(cfg.PresetStoreFile);
+	if err != nil {
+		return s.fail(errors.New(`Could not load stored presets. Error: ` + err.Error()));
+	}
+
+	quotas, err := quota.NewTracker(cfg.Quota.File);
+	if err != nil {
+		return s.fail(errors.New(`Could not load quota usage. Error: ` + err.Error()));
+	}
+	s.closers = append(s.closers, func(){
+		quotas.Close();
+	});
+
+	if cfg.Quota.File != `` {
+		s.health.Add(`quotaStorage`, health.WritableDir(filepath.Dir(cfg.Quota.File)));
+	}
+
+	admissions := admission.NewController(admissionConfig(cfg));
+
+	// The watched directory is processed alongside the API, with the presets
+	// and limits of the server. applyConfig sets what it applies.
+	var watcher *watch.Watcher;
+	if cfg.Watch.Input != `` {
+		ledger, err := watch.OpenLedger(cfg.Watch.Ledger);
+		if err != nil {
+			return s.fail(errors.New(`Could not open watch ledger. Error: ` + err.Error()));
+		}
+		s.closers = append(s.closers, func(){
+			ledger.Close();
+		});
+
+		watcher, err = watch.New(watch.Config{
+			Input: cfg.Watch.Input,
+			Output: cfg.Watch.Output,
+			Errors: cfg.Watch.Errors,
+			Interval: cfg.Watch.Interval,
+			Workers: cfg.Watch.Workers,
+			Presets: presetStore,
+		}, ledger);
+		if err != nil {
+			return s.fail(errors.New(`Could not set up watched directory. Error: ` + err.Error()));
+		}
+		s.watcher = watcher;
+
+		s.health.Add(`watchOutput`, health.WritableDir(cfg.Watch.Output));
+		s.health.Add(`watchErrors`, health.WritableDir(cfg.Watch.Errors));
+	}
+
+	// The middlewares built from reloadable settings are swapped for new ones
+	// on every reload, applyConfig installs the first ones.
+	resolveClientIP := middlewares.NewSwappable(middlewares.Skip);
+	rateLimit := middlewares.NewSwappable(middlewares.Skip);
+	requireAdminToken := middlewares.NewSwappable(middlewares.Skip);
+	authenticate := middlewares.NewSwappable(middlewares.Skip);
+	enforceQuota := middlewares.NewSwappable(middlewares.Skip);
+	costLimit := middlewares.NewSwappable(middlewares.Skip);
+
+	var applied config.Config;
+	var tokens *auth.TokenVerifier;
+
+	// applyConfig makes cfg take effect. Everything that can fail is read and
+	// checked before anything is replaced, so a failure keeps the previous
+	// settings.
+	applyConfig := func(cfg config.Config) error{
+
+		var err error;
+
+		// All were checked when the configuration was validated.
+		rateLimitPolicy, routePolicies, _ := cfg.RateLimit.Policies();
+		prefixLists, _ := cfg.RateLimit.PrefixLists();
+		logLevel, _ := cfg.Log.ParseLevel();
+		v1Deprecated, v1Sunset, _ := cfg.API.V1Dates();
+
+		var watchSettings watch.Settings;
+		if watcher != nil {
+			watchSettings, err = watch.NewSettings(cfg.Watch.Preset, cfg.Watch.Pipeline, cfg.Watch.NameTemplate);
+			if err != nil {
+				return errors.New(`Invalid watch settings. Error: ` + err.Error());
+			}
+		}
+
+		var presets []handlers.Preset;
+		if cfg.PresetsFile != `` {
+			presets, err = handlers.LoadPresetsFile(cfg.PresetsFile);
+			if err != nil {
+				return errors.New(`Could not load presets. Error: ` + err.Error());
+			}
+		}
+
+		nextTokens := tokens;
+		if cfg.Auth.JWT != applied.Auth.JWT {
+			nextTokens = nil;
+			if cfg.Auth.JWT.JWKS != `` {
+				nextTokens, err = auth.NewTokenVerifier(auth.TokenConfig{
+					JWKS: cfg.Auth.JWT.JWKS,
+					Issuer: cfg.Auth.JWT.Issuer,
+					Audience: cfg.Auth.JWT.Audience,
+					Leeway: cfg.Auth.JWT.Leeway,
+				});
+				if err != nil {
+					return errors.New(`Could not load JWKS. Error: ` + err.Error());
+				}
+			}
+		}
+
+		keyFile, err := apiKeys.Read();
+		if err != nil {
+			return errors.New(`Could not load API keys. Error: ` + err.Error());
+		}
+
+		// Presets that changed in the file become the latest version of their
+		// name, the others keep the versions stored since with the admin API.
+		// They are stored first, all or none, as the only step that changes
+		// anything and can still fail.
+		if cfg.PresetsFile != `` {
+			err = presetStore.PutFile(presets);
+			if err != nil {
+				return errors.New(`Could not store presets. Error: ` + err.Error());
+			}
+		}
+
+		apiKeys.Replace(keyFile);
+		logging.Level.Set(logLevel);
+		utilities.SetMaxAllowedFileSize(cfg.Limits.MaxFileSize);
+		utilities.SetMaxAllowedDimension(cfg.Limits.MaxDimension);
+		handlers.SetSettings(handlers.Settings{
+			JPEGQuality: cfg.Limits.JPEGQuality,
+			ProcessingTimeout: cfg.Timeouts.Processing,
+			ResponsiveTimeout: cfg.Timeouts.Responsive,
+			BatchTimeout: cfg.Timeouts.Batch,
+			DefaultQuota: cfg.Quota.DefaultPolicy,
+		});
+
+		uploadLimit := cfg.Limits.MaxFileSize + cfg.Limits.MaxRequestSize;
+		bodyLimits := map[string]int64{
+			`/batch`: handlers.MaxBatchArchiveSize + cfg.Limits.MaxRequestSize,
+			`/responsive`: uploadLimit,
+			`/process`: uploadLimit,
+		};
+		for _, route := range imageRoutes {
+			bodyLimits[route.path] = uploadLimit;
+		}
+		bodyLimitConfig := middlewares.BodyLimitConfig{
+			Limit: cfg.Limits.MaxRequestSize,
+			Routes: bodyLimits,
+		};
+		limitBody.Swap(middlewares.LimitBody(bodyLimitConfig));
+		admit.Swap(middlewares.Admit(middlewares.AdmitConfig{
+			Controller: admissions,
+			BatchWorkers: handlers.BatchWorkers(),
+			BodyLimits: bodyLimitConfig,
+		}));
+
+		resolveClientIP.Swap(middlewares.ResolveClientIP(middlewares.ClientIPConfig{
+			Resolver: clientip.NewResolver(prefixLists.TrustedProxies),
+			Blocklist: prefixLists.Blocklist,
+		}));
+
+		rateLimit.Swap(middlewares.RateLimit(middlewares.RateLimitConfig{
+			Store: rateLimitStore,
+			Policy: rateLimitPolicy,
+			Routes: routePolicies,
+			Allowlist: prefixLists.Allowlist,
+		}));
+
+		requireAdminToken.Swap(middlewares.RequireAdminToken(cfg.Auth.AdminToken));
+
+		tokens = nextTokens;
+		if cfg.Auth.Disabled {
+			authenticate.Swap(middlewares.Skip);
+		} else {
+			authenticate.Swap(middlewares.Authenticate(apiKeys, tokens));
+		}
+
+		enforceQuota.Swap(middlewares.EnforceQuota(quotas, cfg.Quota.DefaultPolicy));
+
+		costLimit.Swap(middlewares.CostLimit(middlewares.CostLimitConfig{
+			Store: rateLimitStore,
+			Budget: cfg.RateLimit.CostBudget,
+		}));
+
+		admissions.Update(admissionConfig(cfg));
+
+		if watcher != nil {
+			watcher.Update(watchSettings);
+		}
+
+		if v1Deprecated.IsZero() {
+			deprecateV1.Swap(middlewares.Skip);
+		} else {
+			deprecateV1.Swap(middlewares.Deprecate(app, middlewares.DeprecationConfig{
+				Version: `v1`,
+				Deprecated: v1Deprecated,
+				Sunset: v1Sunset,
+				Successors: v1Successors,
+				Docs: `/docs`,
+			}));
+		}
+
+		applied = cfg;
+
+		return nil;
+	};
+
+	err = applyConfig(cfg);
+	if err != nil {
+		return s.fail(err);
+	}
+	s.apply = applyConfig;
+
+	app.Use(resolveClientIP.Handler);
+	app.Use(rateLimit.Handler);
+
+	if cfg.ResponsiveStorageDir != `` {
+		s.health.Add(`responsiveStorage`, health.WritableDir(cfg.ResponsiveStorageDir));
+		app.Static(`/generated`, cfg.ResponsiveStorageDir);
+	}
+
+	app.Get(`/problems`, handlers.Problems);
+	app.Get(`/problems/:code`, handlers.Problem);
+	app.Get(`/openapi.json`, handlers.OpenAPI(app));
+	app.Get(`/docs`, handlers.Docs);
+
+	admin := app.Group(`/admin`, requireAdminToken.Handler, limitBody.Handler);
+	admin.Get(`/presets`, handlers.ListPresets(presetStore));
+	admin.Get(`/presets/:name`, handlers.GetPreset(presetStore));
+	admin.Put(`/presets/:name`, handlers.PutPreset(presetStore));
+	admin.Delete(`/presets/:name`, handlers.DeletePreset(presetStore));
+	admin.Get(`/keys`, handlers.ListAPIKeys(apiKeys));
+	admin.Post(`/keys`, handlers.CreateAPIKey(apiKeys));
+	admin.Delete(`/keys/:id`, handlers.RevokeAPIKey(apiKeys));
+
+	v1Routers := []fiber.Router{app.Group(middlewares.APIv1), app};
+	v2 := app.Group(middlewares.APIv2);
+
+	app.Use(authenticate.Handler);
+
+	// Reading usage does not count against the quota, so exhausted callers
+	// can still see when it resets. It takes no body and no admission.
+	for _, v1 := range v1Routers {
+		v1.Get(`/usage`, handlers.Usage(quotas));
+	}
+	v2.Get(`/usage`, handlers.Usage(quotas));
+
+	// Blocked, limited and anonymous callers are rejected before their
+	// uploads are read, and the others are admitted for the memory their
+	// upload may take before it is read.
+	app.Use(admit.Handler);
+	app.Use(limitBody.Handler);
+	app.Use(middlewares.LowerAdmission(handlers.BatchWorkers()));
+
+	app.Use(enforceQuota.Handler);
+
+	app.Use(costLimit.Handler);
+
+	for _, v1 := range v1Routers {
+		// Batch uploads may carry an archive instead of an image field, so
+		// batch checks its uploads itself.
+		v1.Post(`/batch`, handlers.Batch(presetStore));
+
+		// Responsive sets apply a preset to the source themselves.
+		v1.Post(`/responsive`, middlewares.CheckImageSize, handlers.Responsive(presetStore, cfg.ResponsiveStorageDir));
+
+		for _, route := range imageRoutes {
+			v1.Post(route.path, middlewares.CheckImageSize, handlers.ApplyPreset(presetStore), route.handler);
+		}
+	}
+
+	// v2 processes single images with an operation chain like batches, instead
+	// of a route per operation.
+	v2.Post(`/process`, middlewares.CheckImageSize, handlers.Process(presetStore));
+	v2.Post(`/batch`, handlers.Batch(presetStore));
+	v2.Post(`/responsive`, middlewares.CheckImageSize, handlers.Responsive(presetStore, cfg.ResponsiveStorageDir));
+
+	return s, nil;
+}
+
+// Apply makes cfg take effect, for reloads. A failure keeps the previous
+// settings.
+func (s *Server) Apply(cfg config.Config) error{
+	return s.apply(cfg);
+}
+
+// Watch processes the watched directory until ctx is done, and returns at
+// once when none is configured.
+func (s *Server) Watch(ctx context.Context){
+	if s.watcher != nil {
+		s.watcher.Run(ctx);
+	}
+}
+
+// Close persists quota usage and closes the stores and connections of the
+// server, once it stopped serving.
+func (s *Server) Close(){
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]();
+	}
+	s.closers = nil;
+}
+
+// fail closes what New opened before it failed with err.
+func (s *Server) fail(err error) (*Server, error){
+	s.Close();
+	return nil, err;
+}
+
+func admissionConfig(cfg config.Config) admission.Config{
+	return admission.Config{
+		MemoryBudget: int64(cfg.Admission.MemoryMB) << 20,
+		Slots: cfg.Admission.Slots,
+		MaxQueue: cfg.Admission.Queue,
+		MaxWait: cfg.Admission.MaxWait,
+	};
+}
+
+// writeOpenAPI prints the OpenAPI document of the routes of app, or compares
+// it with the file that is kept in the repository, so that routes and
+// metadata do not change without the document changing along.
+func writeOpenAPI(app *fiber.App, options config.Options) error{
+
+	document, err := handlers.OpenAPIJSON(app.GetRoutes(true));
+	if err != nil {
+		return errors.New(`Could not build the OpenAPI document. Error: ` + err.Error());
+	}
+
+	if options.PrintOpenAPI {
+		_, err = os.Stdout.Write(document);
+		return err;
+	}
+
+	kept, err := os.ReadFile(options.CheckOpenAPI);
+	if err != nil {
+		return errors.New(`Could not read the OpenAPI document. Error: ` + err.Error());
+	}
+	if !bytes.Equal(kept, document) {
+		return errors.New(`The OpenAPI document in ` + options.CheckOpenAPI + ` is out of date, regenerate it with -print-openapi.`);
+	}
+
+	return nil;
+}
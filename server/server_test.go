@@ -0,0 +1,58 @@
+package server
+
+import (
+	"imageProcessorAPI/config"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+
+// TestServersShareNothing builds two servers of their own files, like tests
+// and tools building more than one do, and checks one does not take over
+// what the other reports on.
+func TestServersShareNothing(t *testing.T){
+
+	newServer := func() *Server{
+		t.Helper();
+
+		dir := t.TempDir();
+		cfg := config.Default();
+		cfg.Auth.APIKeysFile = filepath.Join(dir, `api_keys.json`);
+		cfg.PresetStoreFile = filepath.Join(dir, `preset_store.json`);
+		cfg.Quota.File = filepath.Join(dir, `quota_usage.json`);
+
+		srv, err := New(cfg);
+		if err != nil {
+			t.Fatal(err);
+		}
+		t.Cleanup(srv.Close);
+
+		return srv;
+	};
+	first := newServer();
+	second := newServer();
+
+	// Each checks its own quota storage.
+	if first.health == second.health || len(first.health.Ready(t.Context())) != 0 || len(second.health.Ready(t.Context())) != 0 {
+		t.Fatal(`got the servers sharing readiness, want their own`);
+	}
+
+	second.health.Drain();
+	for _, want := range []struct{
+		srv *Server;
+		status int;
+	}{
+		{first, 200},
+		{second, 503},
+	} {
+		response, err := want.srv.App.Test(httptest.NewRequest(`GET`, `/readyz`, nil));
+		if err != nil {
+			t.Fatal(err);
+		}
+		response.Body.Close();
+		if response.StatusCode != want.status {
+			t.Fatalf(`got status %d, want %d`, response.StatusCode, want.status);
+		}
+	}
+}
@@ -2,16 +2,34 @@ package utilities
 
 import (
 	"image"
+	"sync/atomic"
 )
 
-func CheckImageBounds(image *image.Image) bool{
+var maxAllowedDimension atomic.Int64;
 
-	const MaxAllowedDimension = 2000;
+func init(){
+	maxAllowedDimension.Store(2000);
+}
+
+// MaxAllowedDimension is the largest accepted image width or height.
+func MaxAllowedDimension() int{
+	return int(maxAllowedDimension.Load());
+}
 
-	if (*image).Bounds().Dx() > MaxAllowedDimension || (*image).Bounds().Dy() > MaxAllowedDimension{
+// SetMaxAllowedDimension is called when the configuration is loaded.
+func SetMaxAllowedDimension(dimension int){
+	maxAllowedDimension.Store(int64(dimension));
+}
+
+// CheckImageConfigBounds checks the dimensions of a decoded header against
+// MaxAllowedDimension, so callers can reject an image before decoding its
+// pixels.
+func CheckImageConfigBounds(config image.Config) bool{
+
+	maxDimension := MaxAllowedDimension();
+	if config.Width > maxDimension || config.Height > maxDimension{
 		return false;
 	}
 
 	return true;
 }
-
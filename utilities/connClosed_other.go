@@ -0,0 +1,11 @@
+//go:build !unix
+
+package utilities
+
+import "net"
+
+// ConnClosed cannot peek at connections here, so disconnects go unnoticed
+// until the response is written.
+func ConnClosed(conn net.Conn) bool{
+	return false;
+}
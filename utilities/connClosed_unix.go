@@ -0,0 +1,34 @@
+//go:build unix
+
+package utilities
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ConnClosed reports whether the peer closed conn, by peeking at it without
+// blocking or consuming anything. A client that only shut down its sending
+// side looks closed too, which clients of this API do not do.
+func ConnClosed(conn net.Conn) bool{
+
+	syscallConn, ok := conn.(syscall.Conn);
+	if !ok {
+		return false;
+	}
+	rawConn, err := syscallConn.SyscallConn();
+	if err != nil {
+		return false;
+	}
+
+	closed := false;
+	buffer := make([]byte, 1);
+	err = rawConn.Read(func(fd uintptr) bool{
+		n, _, err := syscall.Recvfrom(int(fd), buffer, syscall.MSG_PEEK | syscall.MSG_DONTWAIT);
+		closed = n == 0 && err == nil || errors.Is(err, syscall.ECONNRESET);
+		return true;
+	});
+
+	return err == nil && closed;
+}
@@ -0,0 +1,21 @@
+package utilities
+
+import (
+	"sync/atomic"
+)
+
+var maxAllowedFileSize atomic.Int64;
+
+func init(){
+	maxAllowedFileSize.Store(30 * 1024 * 1024);
+}
+
+// MaxAllowedFileSize is the largest accepted upload in bytes.
+func MaxAllowedFileSize() int64{
+	return maxAllowedFileSize.Load();
+}
+
+// SetMaxAllowedFileSize is called when the configuration is loaded.
+func SetMaxAllowedFileSize(size int64){
+	maxAllowedFileSize.Store(size);
+}
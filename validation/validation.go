@@ -0,0 +1,331 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"imageProcessorAPI/api"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a field that broke a rule. Field is the path of its JSON
+// name, like output.quality or widths[2].
+type FieldError = api.FieldError
+
+// Errors lists every field that broke a rule.
+type Errors = api.FieldErrors
+
+var ErrTrailingData = errors.New(`Unexpected data after the JSON value.`)
+
+var limits = map[string]func() float64{}
+
+// SetLimit names a limit that rules can use instead of a number, like
+// max=maxDimension, for limits that are configured. Limits are set up
+// before anything is validated, from init functions.
+func SetLimit(name string, limit func() float64) {
+	limits[name] = limit
+}
+
+// Decode reads the JSON data into v, a pointer to a struct, and checks the
+// rules of its fields. Unknown fields and values of the wrong type are
+// reported as Errors like broken rules, the decoder stops at the first of
+// those. Empty data is an empty object. Other errors mean data is not JSON,
+// or not a single JSON value.
+func Decode(data []byte, v any) error {
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte(`{}`)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		// Anything but whitespace after the value, even a stray } that
+		// decoder.More would not report, is not part of it.
+		_, trailing := decoder.Token()
+		if !errors.Is(trailing, io.EOF) {
+			err = ErrTrailingData
+		}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		return Errors{{Field: typeErr.Field, Reason: `must be ` + jsonType(typeErr.Type)}}
+	case err != nil && strings.HasPrefix(err.Error(), `json: unknown field `):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), `json: unknown field `))
+		return Errors{{Field: field, Reason: `unknown field`}}
+	case err != nil:
+		return err
+	}
+
+	errs := Struct(v)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Struct checks v, a struct or a pointer to one, against the rules in the
+// validate tags of its fields, and the fields of the structs it holds:
+//
+//   - required: the field must be set.
+//   - required_without=Field: the field must be set when Field is not.
+//   - min=N, max=N: bounds of numbers, and of the length of strings and
+//     slices. N may be the name of a limit, see SetLimit.
+//   - oneof=a b c: the string must be one of the words, in any case.
+//   - gtfield=Field, gtefield=Field: the number must be greater than, or
+//     equal to, the one in Field when both are set.
+//   - dive: the rules that follow apply to every element of the slice.
+//
+// Fields that are not set, nil pointers and zero values, are only checked by
+// the required rules.
+func Struct(v any) Errors {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	return checkStruct(value, ``)
+}
+
+func checkStruct(value reflect.Value, path string) Errors {
+
+	var errs Errors
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		fieldPath := path + jsonName(field)
+		fieldValue := value.Field(i)
+		errs = append(errs, checkRules(value, fieldValue, fieldPath, field.Tag.Get(`validate`), false)...)
+		errs = append(errs, checkNested(fieldValue, fieldPath)...)
+	}
+
+	return errs
+}
+
+// checkNested checks the structs held by value, directly or in a slice.
+func checkNested(value reflect.Value, path string) Errors {
+
+	value = reflect.Indirect(value)
+	switch {
+	case value.Kind() == reflect.Struct:
+		return checkStruct(value, path+`.`)
+	case value.Kind() == reflect.Slice && elementType(value.Type()).Kind() == reflect.Struct:
+		var errs Errors
+		for i := 0; i < value.Len(); i++ {
+			errs = append(errs, checkNested(value.Index(i), path+`[`+strconv.Itoa(i)+`]`)...)
+		}
+		return errs
+	}
+
+	return nil
+}
+
+// checkRules checks value against the rules of tag. Elements of slices are
+// always set, even when they are zero.
+func checkRules(parent reflect.Value, value reflect.Value, path string, tag string, element bool) Errors {
+
+	if tag == `` {
+		return nil
+	}
+
+	rules := strings.Split(tag, `,`)
+	for i, rule := range rules {
+		name, argument, _ := strings.Cut(rule, `=`)
+
+		if name == `dive` {
+			var errs Errors
+			value = reflect.Indirect(value)
+			for j := 0; value.Kind() == reflect.Slice && j < value.Len(); j++ {
+				errs = append(errs, checkRules(parent, value.Index(j), path+`[`+strconv.Itoa(j)+`]`, strings.Join(rules[i+1:], `,`), true)...)
+			}
+			return errs
+		}
+
+		reason := checkRule(parent, value, name, argument, element || !value.IsZero())
+		if reason != `` {
+			// The first broken rule of a field explains enough.
+			return Errors{{Field: path, Reason: reason}}
+		}
+	}
+
+	return nil
+}
+
+// checkRule returns why value breaks the rule, or nothing when it does not.
+func checkRule(parent reflect.Value, value reflect.Value, name string, argument string, set bool) string {
+
+	switch name {
+	case `required`:
+		if !set || isEmpty(value) {
+			return `required`
+		}
+		return ``
+	case `required_without`:
+		other, otherName := siblingField(parent, argument)
+		if !set && other.IsZero() {
+			return `required when ` + otherName + ` is not set`
+		}
+		return ``
+	}
+
+	if !set {
+		return ``
+	}
+	value = reflect.Indirect(value)
+
+	switch name {
+	case `min`, `max`:
+		limit := Limit(argument)
+		number, isLength := numberOf(value)
+		if name == `min` && number < limit {
+			if isLength {
+				return `must have at least ` + formatNumber(limit) + ` ` + lengthUnit(value)
+			}
+			return `must be at least ` + formatNumber(limit)
+		}
+		if name == `max` && number > limit {
+			if isLength {
+				return `must have at most ` + formatNumber(limit) + ` ` + lengthUnit(value)
+			}
+			return `must be at most ` + formatNumber(limit)
+		}
+	case `oneof`:
+		words := strings.Fields(argument)
+		for _, word := range words {
+			if strings.EqualFold(value.String(), word) {
+				return ``
+			}
+		}
+		return `must be one of ` + strings.Join(words, `, `)
+	case `gtfield`, `gtefield`:
+		other, otherName := siblingField(parent, argument)
+		if other.IsZero() {
+			return ``
+		}
+		number, _ := numberOf(value)
+		otherNumber, _ := numberOf(reflect.Indirect(other))
+		if name == `gtfield` && number <= otherNumber {
+			return `must be greater than ` + otherName
+		}
+		if name == `gtefield` && number < otherNumber {
+			return `must not be less than ` + otherName
+		}
+	default:
+		panic(`Unknown validation rule ` + name + `.`)
+	}
+
+	return ``
+}
+
+func siblingField(parent reflect.Value, name string) (reflect.Value, string) {
+	field, ok := parent.Type().FieldByName(name)
+	if !ok {
+		panic(`Unknown field ` + name + ` in validation rule.`)
+	}
+
+	return parent.FieldByIndex(field.Index), jsonName(field)
+}
+
+// Limit is the value of the limit or number argument of a min or max rule.
+func Limit(argument string) float64 {
+	if limit, ok := limits[argument]; ok {
+		return limit()
+	}
+
+	number, err := strconv.ParseFloat(argument, 64)
+	if err != nil {
+		panic(`Unknown validation limit ` + argument + `.`)
+	}
+
+	return number
+}
+
+// numberOf is the number rules compare value by, the length of strings and
+// slices.
+func numberOf(value reflect.Value) (float64, bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), false
+	case reflect.String, reflect.Slice, reflect.Map:
+		return float64(value.Len()), true
+	}
+
+	return 0, false
+}
+
+func isEmpty(value reflect.Value) bool {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+
+	return false
+}
+
+func lengthUnit(value reflect.Value) string {
+	if value.Kind() == reflect.String {
+		return `characters`
+	}
+
+	return `items`
+}
+
+func formatNumber(number float64) string {
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
+
+func elementType(t reflect.Type) reflect.Type {
+	t = t.Elem()
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get(`json`), `,`)
+	if name == `` {
+		return field.Name
+	}
+
+	return name
+}
+
+// jsonType names the JSON type Go values of t are decoded from.
+func jsonType(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return `a boolean`
+	case reflect.String:
+		return `a string`
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return `an integer`
+	case reflect.Float32, reflect.Float64:
+		return `a number`
+	case reflect.Slice, reflect.Array:
+		return `an array`
+	}
+
+	return `an object`
+}
@@ -0,0 +1,132 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testOutput struct {
+	Format  string `json:"format" validate:"oneof=png jpeg"`
+	Quality *int   `json:"quality" validate:"min=1,max=100"`
+}
+
+type testBody struct {
+	Name    string      `json:"name" validate:"required,max=8"`
+	Preset  string      `json:"preset"`
+	Steps   []string    `json:"steps" validate:"required_without=Preset,max=2,dive,min=2"`
+	Width   int         `json:"width" validate:"min=1,max=testMaxWidth"`
+	MinSize int         `json:"minSize"`
+	MaxSize int         `json:"maxSize" validate:"gtfield=MinSize"`
+	Output  testOutput  `json:"output"`
+	Crops   []testCrop  `json:"crops"`
+	Resize  *testResize `json:"resize"`
+}
+
+type testCrop struct {
+	X int `json:"x" validate:"min=0"`
+}
+
+type testResize struct {
+	Width int `json:"width" validate:"required"`
+}
+
+func init() {
+	SetLimit(`testMaxWidth`, func() float64 { return 500 })
+}
+
+func TestDecodeReportsEveryBrokenRule(t *testing.T) {
+
+	body := testBody{}
+	err := Decode([]byte(`{
+		"name": "much too long",
+		"steps": ["ok", "x", "ok"],
+		"width": 501,
+		"minSize": 10,
+		"maxSize": 10,
+		"output": {"format": "gif", "quality": 0},
+		"crops": [{"x": 0}, {"x": -1}],
+		"resize": {}
+	}`), &body)
+
+	want := Errors{
+		{Field: `name`, Reason: `must have at most 8 characters`},
+		{Field: `steps`, Reason: `must have at most 2 items`},
+		{Field: `width`, Reason: `must be at most 500`},
+		{Field: `maxSize`, Reason: `must be greater than minSize`},
+		{Field: `output.format`, Reason: `must be one of png, jpeg`},
+		{Field: `output.quality`, Reason: `must be at least 1`},
+		{Field: `crops[1].x`, Reason: `must be at least 0`},
+		{Field: `resize.width`, Reason: `required`},
+	}
+	var got Errors
+	if !errors.As(err, &got) || !reflect.DeepEqual(got, want) {
+		t.Fatalf(`got %v, want %v`, err, want)
+	}
+
+	// Rules after dive apply to each element.
+	err = Decode([]byte(`{"name": "ok", "steps": ["ok", "x"]}`), &testBody{})
+	want = Errors{{Field: `steps[1]`, Reason: `must have at least 2 characters`}}
+	if !errors.As(err, &got) || !reflect.DeepEqual(got, want) {
+		t.Fatalf(`got %v, want %v`, err, want)
+	}
+}
+
+func TestDecodeChecksRequiredFields(t *testing.T) {
+
+	bodies := []struct {
+		data string
+		want Errors
+	}{
+		{``, Errors{{Field: `name`, Reason: `required`}, {Field: `steps`, Reason: `required when preset is not set`}}},
+		// Set but empty breaks required, required_without only needs it set.
+		{`{"name": "", "steps": []}`, Errors{{Field: `name`, Reason: `required`}}},
+		{`{"name": "ok", "preset": "thumb"}`, nil},
+		{`{"name": "ok", "steps": ["ok"], "output": {"format": "PNG", "quality": 100}}`, nil},
+	}
+	for _, body := range bodies {
+		err := Decode([]byte(body.data), &testBody{})
+		var got Errors
+		errors.As(err, &got)
+		if (err == nil) != (body.want == nil) || !reflect.DeepEqual(got, body.want) {
+			t.Fatalf(`%s: got %v, want %v`, body.data, err, body.want)
+		}
+	}
+}
+
+func TestDecodeRejectsWhatIsNotOneObject(t *testing.T) {
+
+	bodies := []struct {
+		data string
+		want Errors
+	}{
+		{`{"name": "ok", "preset": "thumb", "colour": "red"}`, Errors{{Field: `colour`, Reason: `unknown field`}}},
+		{`{"name": "ok", "preset": "thumb", "width": "wide"}`, Errors{{Field: `width`, Reason: `must be an integer`}}},
+		{`{"name": "ok", "preset": "thumb", "steps": "ok"}`, Errors{{Field: `steps`, Reason: `must be an array`}}},
+	}
+	for _, body := range bodies {
+		var got Errors
+		err := Decode([]byte(body.data), &testBody{})
+		if !errors.As(err, &got) || !reflect.DeepEqual(got, body.want) {
+			t.Fatalf(`%s: got %v, want %v`, body.data, err, body.want)
+		}
+	}
+
+	for _, data := range []string{
+		`{"name": "ok"`,
+		`{"name": "ok", "preset": "thumb"}garbage`,
+		`{"name": "ok", "preset": "thumb"}}`,
+		`{"name": "ok", "preset": "thumb"} {}`,
+	} {
+		err := Decode([]byte(data), &testBody{})
+		var fields Errors
+		if err == nil || errors.As(err, &fields) {
+			t.Fatalf(`%s: got %v, want it rejected as not JSON`, data, err)
+		}
+	}
+
+	err := Decode([]byte(" {\"name\": \"ok\", \"preset\": \"thumb\"}\n"), &testBody{})
+	if err != nil {
+		t.Fatalf(`got %v, want whitespace around the object accepted`, err)
+	}
+}
@@ -0,0 +1,105 @@
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// LedgerEntry records one processed image of the watched directory.
+type LedgerEntry struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+	// Output is the path of the result, relative to the output directory.
+	Output      string    `json:"output"`
+	Preset      string    `json:"preset,omitempty"`
+	ProcessedAt time.Time `json:"processedAt"`
+}
+
+// Ledger is the file processed images are recorded in, one JSON line each.
+// Images are known by name and content, so an image dropped again with
+// other content is processed again.
+type Ledger struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[ledgerKey]bool
+}
+
+type ledgerKey struct {
+	file   string
+	sha256 string
+}
+
+// OpenLedger reads the entries of the ledger at path, creating it when it
+// does not exist, and appends to it from then on. A last line cut short by a
+// crash is ignored, the image it was about is processed again.
+func OpenLedger(path string) (*Ledger, error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	done := map[ledgerKey]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		entry := LedgerEntry{}
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			done[ledgerKey{file: entry.File, sha256: entry.SHA256}] = true
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	// The next entry starts on a line of its own.
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		_, err = file.WriteString("\n")
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return &Ledger{file: file, done: done}, nil
+}
+
+func (l *Ledger) Done(file string, sha256 string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.done[ledgerKey{file: file, sha256: sha256}]
+}
+
+// Record appends entry and syncs it to disk before returning.
+func (l *Ledger) Record(entry LedgerEntry) error {
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	err = l.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	l.done[ledgerKey{file: entry.File, sha256: entry.SHA256}] = true
+	return nil
+}
+
+func (l *Ledger) Close() error {
+	return l.file.Close()
+}
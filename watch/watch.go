@@ -0,0 +1,492 @@
+// Package watch processes the images dropped into a directory with a preset
+// or an operation chain, for integrations that exchange files instead of
+// calling the API.
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"imageProcessorAPI/handlers"
+	"imageProcessorAPI/metrics"
+	"imageProcessorAPI/problem"
+	"imageProcessorAPI/utilities"
+	"imageProcessorAPI/validation"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrorSuffix is appended to the name of failed images for the file that
+// tells why they failed.
+const ErrorSuffix = `.error.json`
+
+// Config are the directories and the pace of a watcher, fixed once it runs.
+type Config struct {
+	Input  string
+	Output string
+	Errors string
+	// Interval is the time between scans of Input.
+	Interval time.Duration
+	// Workers is how many images are processed at once.
+	Workers int
+	// Presets is the store the preset of the settings is looked up in.
+	Presets *handlers.PresetStore
+}
+
+// Settings are replaced as a whole by Update, images being processed keep
+// the settings they started with.
+type Settings struct {
+	// Preset is a `name` or `name@version` reference, resolved on every scan
+	// so new versions of the preset apply to the next images. Pipeline is
+	// applied when Preset is empty.
+	Preset   string
+	Pipeline handlers.PipelineMetadata
+	// NameTemplate is the path of results in the output directory, see
+	// NewSettings.
+	NameTemplate string
+}
+
+// NewSettings checks the settings of a watcher. pipeline is JSON like the
+// metadata of POST /v2/process, nameTemplate may use {name}, the name of the
+// image without its extension, {ext}, the extension of the result's format,
+// {preset}, the preset reference or `pipeline`, and {date}, like 2026-10-18.
+func NewSettings(preset string, pipeline string, nameTemplate string) (Settings, error) {
+
+	settings := Settings{Preset: preset, NameTemplate: nameTemplate}
+	if (preset == ``) == (pipeline == ``) {
+		return Settings{}, errors.New(`Must set a preset or a pipeline, not both.`)
+	}
+
+	if pipeline != `` {
+		err := validation.Decode([]byte(pipeline), &settings.Pipeline)
+		if err != nil {
+			return Settings{}, errors.New(`Invalid pipeline: ` + err.Error())
+		}
+
+		fields := handlers.ValidatePipeline(settings.Pipeline)
+		if len(fields) > 0 {
+			return Settings{}, errors.New(`Invalid pipeline: ` + fields.Error())
+		}
+	}
+
+	if !strings.Contains(nameTemplate, `{name}`) {
+		return Settings{}, errors.New(`Name template must contain {name}, so results do not overwrite each other.`)
+	}
+	sample := outputName(nameTemplate, `image.png`, imaging.PNG, `preset@1`, time.Now())
+	if strings.ContainsAny(sample, `{}`) {
+		return Settings{}, errors.New(`Name template may only contain {name}, {ext}, {preset} and {date}.`)
+	}
+	if !filepath.IsLocal(sample) {
+		return Settings{}, errors.New(`Name template must stay within the output directory.`)
+	}
+
+	return settings, nil
+}
+
+// outputName expands template for the image name processed to format.
+func outputName(template string, name string, format imaging.Format, preset string, now time.Time) string {
+
+	if preset == `` {
+		preset = `pipeline`
+	}
+
+	return strings.NewReplacer(
+		`{name}`, strings.TrimSuffix(name, filepath.Ext(name)),
+		`{ext}`, strings.TrimPrefix(handlers.FormatExtension(format), `.`),
+		`{preset}`, preset,
+		`{date}`, now.Format(time.DateOnly),
+	).Replace(template)
+}
+
+// Watcher scans the input directory and processes the images that stopped
+// changing since the previous scan, so images still being copied in are
+// left alone. Results go to the output directory and the ledger, images that
+// cannot be processed are moved to the errors directory. Images stay in the
+// input directory once processed, the ledger tells they were.
+type Watcher struct {
+	config   Config
+	ledger   *Ledger
+	settings atomic.Pointer[Settings]
+
+	// pending holds the files seen changing at the last scan and settled the
+	// ones processed or skipped, until they change again. Only scans touch
+	// them.
+	pending map[string]fileState
+	settled map[string]fileState
+
+	// placeMu keeps two images from picking the same free name.
+	placeMu sync.Mutex
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// New creates the output and errors directories, settings are set with
+// Update before Run.
+func New(config Config, ledger *Ledger) (*Watcher, error) {
+
+	for _, dir := range []string{config.Output, config.Errors} {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Watcher{
+		config:  config,
+		ledger:  ledger,
+		pending: map[string]fileState{},
+		settled: map[string]fileState{},
+	}, nil
+}
+
+func (w *Watcher) Update(settings Settings) {
+	w.settings.Store(&settings)
+}
+
+// Run scans until ctx is done, then waits for the images being processed.
+func (w *Watcher) Run(ctx context.Context) {
+
+	slog.Info(`Watching ` + w.config.Input + ` for images.`)
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.scan(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Watcher) scan(ctx context.Context) {
+
+	entries, err := os.ReadDir(w.config.Input)
+	if err != nil {
+		slog.Error(`Could not read watched directory. Error: ` + err.Error())
+		return
+	}
+
+	settings := *w.settings.Load()
+	pipeline := settings.Pipeline
+	if settings.Preset != `` {
+		preset, err := w.config.Presets.Resolve(settings.Preset)
+		if err != nil {
+			// Moving every image to the errors directory would not fix the
+			// configuration.
+			slog.Error(`Could not resolve watch preset. Error: ` + err.Error())
+			return
+		}
+		pipeline = handlers.PipelineMetadata{Preset: preset.Reference(), Operations: preset.Operations, Output: preset.Output}
+	}
+
+	present := map[string]bool{}
+	var ready []string
+	for _, entry := range entries {
+		// Hidden files are the temporary files of copies in progress, or of
+		// this watcher.
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), `.`) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		name := entry.Name()
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		present[name] = true
+
+		if w.settled[name] == state {
+			continue
+		}
+		delete(w.settled, name)
+		if w.pending[name] != state {
+			w.pending[name] = state
+			continue
+		}
+
+		delete(w.pending, name)
+		ready = append(ready, name)
+	}
+
+	for name := range w.pending {
+		if !present[name] {
+			delete(w.pending, name)
+		}
+	}
+	for name := range w.settled {
+		if !present[name] {
+			delete(w.settled, name)
+		}
+	}
+
+	settled := w.processAll(ctx, ready, pipeline, settings.NameTemplate)
+	for name, state := range settled {
+		w.settled[name] = state
+	}
+}
+
+// processAll processes names with the workers of the watcher and returns the
+// states of those that are settled. Images being processed are finished when
+// ctx is done, the others are left for the next run.
+func (w *Watcher) processAll(ctx context.Context, names []string, pipeline handlers.PipelineMetadata, nameTemplate string) map[string]fileState {
+
+	jobs := make(chan string)
+	var mu sync.Mutex
+	settled := map[string]fileState{}
+
+	var wg sync.WaitGroup
+	for range min(w.config.Workers, len(names)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				state, ok := w.processFile(context.WithoutCancel(ctx), name, pipeline, nameTemplate)
+				if ok {
+					mu.Lock()
+					settled[name] = state
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+
+	return settled
+}
+
+// processFile processes the image name and reports whether it is settled,
+// processed now or before. Images that fail are moved to the errors
+// directory, those that hit a failure of the server, like a full disk, are
+// tried again.
+func (w *Watcher) processFile(ctx context.Context, name string, pipeline handlers.PipelineMetadata, nameTemplate string) (fileState, bool) {
+
+	path := filepath.Join(w.config.Input, name)
+	logger := slog.With(slog.String(`file`, name))
+
+	state, hash, output, err := w.process(ctx, path, name, pipeline, nameTemplate)
+
+	var operationErr *handlers.OperationError
+	switch {
+	case err == nil && output == ``:
+		metrics.WatchedFiles.WithLabelValues(`skipped`).Inc()
+		return state, true
+	case err == nil:
+		err = w.ledger.Record(LedgerEntry{File: name, SHA256: hash, Output: output, Preset: pipeline.Preset, ProcessedAt: time.Now().UTC()})
+		if err != nil {
+			// The result is kept, the image is processed again on restart.
+			logger.Error(`Could not record processed image. Error: ` + err.Error())
+		}
+		metrics.WatchedFiles.WithLabelValues(`processed`).Inc()
+		logger.Info(`Processed ` + name + ` to ` + output + `.`)
+		return state, true
+	case errors.As(err, &operationErr), errors.Is(err, context.DeadlineExceeded):
+		metrics.WatchedFiles.WithLabelValues(`failed`).Inc()
+		logger.Warn(`Could not process image. Error: ` + err.Error())
+		err = w.moveToErrors(path, name, pipeline.Preset, err)
+		if err != nil {
+			logger.Error(`Could not move image to the errors directory. Error: ` + err.Error())
+		}
+		return fileState{}, false
+	default:
+		logger.Error(`Could not process image, it is tried again. Error: ` + err.Error())
+		return fileState{}, false
+	}
+}
+
+// process writes the result of the image at path and returns its state, its
+// hash and the name of the result. The name is empty for images the ledger
+// tells were processed already.
+func (w *Watcher) process(ctx context.Context, path string, name string, pipeline handlers.PipelineMetadata, nameTemplate string) (fileState, string, string, error) {
+
+	format, err := handlers.ImageFormat(name)
+	if err != nil {
+		return fileState{}, ``, ``, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fileState{}, ``, ``, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fileState{}, ``, ``, err
+	}
+	state := fileState{size: info.Size(), modTime: info.ModTime()}
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return state, ``, ``, err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if w.ledger.Done(name, hash) {
+		return state, hash, ``, nil
+	}
+
+	if info.Size() > utilities.MaxAllowedFileSize() {
+		return state, hash, ``, &handlers.OperationError{Code: problem.FileTooLarge, Message: `File size too big.`}
+	}
+
+	ctx, cancelCtx := context.WithTimeout(ctx, handlers.CurrentSettings().ProcessingTimeout)
+	defer cancelCtx()
+
+	decoded, err := handlers.DecodeImage(ctx, file)
+	if err != nil {
+		return state, hash, ``, err
+	}
+	processed, format, err := handlers.ApplyOperations(ctx, decoded, format, pipeline.Operations)
+	if err != nil {
+		return state, hash, ``, err
+	}
+	format = handlers.ResolveFormat(pipeline.Output, format)
+
+	temporary, err := os.CreateTemp(w.config.Output, `.watch-*`)
+	if err != nil {
+		return state, hash, ``, err
+	}
+	defer os.Remove(temporary.Name())
+
+	err = temporary.Chmod(0o644)
+	if err == nil {
+		err = handlers.EncodeImage(ctx, temporary, processed, format, pipeline.Output.Quality)
+	}
+	if err == nil {
+		err = temporary.Close()
+	} else {
+		temporary.Close()
+	}
+	if err != nil {
+		return state, hash, ``, err
+	}
+
+	output, err := w.place(temporary.Name(), w.config.Output, outputName(nameTemplate, name, format, pipeline.Preset, time.Now()))
+	return state, hash, output, err
+}
+
+// failure is written next to images moved to the errors directory.
+type failure struct {
+	File     string            `json:"file"`
+	Error    string            `json:"error"`
+	Code     problem.Code      `json:"code"`
+	Fields   validation.Errors `json:"fields,omitempty"`
+	Preset   string            `json:"preset,omitempty"`
+	FailedAt time.Time         `json:"failedAt"`
+}
+
+func (w *Watcher) moveToErrors(path string, name string, preset string, cause error) error {
+
+	details := failure{File: name, Error: cause.Error(), Code: problem.ProcessingTimeout, Preset: preset, FailedAt: time.Now().UTC()}
+	var operationErr *handlers.OperationError
+	if errors.As(cause, &operationErr) {
+		details.Code = operationErr.Code
+		details.Fields = operationErr.Fields
+	}
+
+	moved, err := w.place(path, w.config.Errors, name)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(details, ``, `  `)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(w.config.Errors, moved+ErrorSuffix), content, 0o644)
+}
+
+// place moves the file at source to name in dir, or to the first free name
+// numbered like name-2.ext when name is taken, and returns the name used.
+func (w *Watcher) place(source string, dir string, name string) (string, error) {
+
+	w.placeMu.Lock()
+	defer w.placeMu.Unlock()
+
+	extension := filepath.Ext(name)
+	base := strings.TrimSuffix(name, extension)
+	placed := name
+	for i := 2; ; i++ {
+		_, err := os.Lstat(filepath.Join(dir, placed))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return ``, err
+		}
+		placed = base + `-` + strconv.Itoa(i) + extension
+	}
+
+	target := filepath.Join(dir, placed)
+	err := os.MkdirAll(filepath.Dir(target), 0o755)
+	if err != nil {
+		return ``, err
+	}
+
+	return placed, moveFile(source, target)
+}
+
+// moveFile renames source to target, or copies it when they are on different
+// file systems.
+func moveFile(source string, target string) error {
+
+	err := os.Rename(source, target)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+
+	return os.Remove(source)
+}
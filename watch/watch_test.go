@@ -0,0 +1,266 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"image/color"
+	"image/png"
+	"imageProcessorAPI/problem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+type testDirs struct {
+	input  string
+	output string
+	errors string
+	ledger string
+}
+
+func newTestDirs(t *testing.T) testDirs {
+	t.Helper()
+
+	root := t.TempDir()
+	dirs := testDirs{
+		input:  filepath.Join(root, `in`),
+		output: filepath.Join(root, `out`),
+		errors: filepath.Join(root, `errors`),
+		ledger: filepath.Join(root, `ledger.jsonl`),
+	}
+	err := os.MkdirAll(dirs.input, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dirs
+}
+
+// startWatcher opens the ledger and creates a watcher of dirs, like the
+// server does on start.
+func startWatcher(t *testing.T, dirs testDirs, pipeline string, nameTemplate string) *Watcher {
+	t.Helper()
+
+	settings, err := NewSettings(``, pipeline, nameTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := OpenLedger(dirs.ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger.Close() })
+
+	watcher, err := New(Config{Input: dirs.input, Output: dirs.output, Errors: dirs.errors, Interval: time.Second, Workers: 2}, ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher.Update(settings)
+
+	return watcher
+}
+
+// settle scans twice, images are processed once they did not change between
+// two scans.
+func settle(w *Watcher) {
+	w.scan(context.Background())
+	w.scan(context.Background())
+}
+
+func writePNG(t *testing.T, path string, width int, height int, fill color.Color) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err == nil {
+		err = png.Encode(file, imaging.New(width, height, fill))
+		file.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func TestWatcherRecordsProcessedImagesAcrossRestarts(t *testing.T) {
+
+	dirs := newTestDirs(t)
+	pipeline := `{"operations":[{"name":"grayscale"}]}`
+	image := filepath.Join(dirs.input, `photo.png`)
+	writePNG(t, image, 20, 10, color.NRGBA{R: 255, A: 255})
+
+	// A single scan leaves the image alone, it may still be copied in.
+	watcher := startWatcher(t, dirs, pipeline, `{name}.{ext}`)
+	watcher.scan(context.Background())
+	if outputs := listDir(t, dirs.output); len(outputs) != 0 {
+		t.Fatalf(`got outputs %v after one scan, want none`, outputs)
+	}
+	watcher.scan(context.Background())
+	if outputs := listDir(t, dirs.output); len(outputs) != 1 || outputs[0] != `photo.png` {
+		t.Fatalf(`got outputs %v, want photo.png`, outputs)
+	}
+
+	result, err := imaging.Open(filepath.Join(dirs.output, `photo.png`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := result.At(0, 0).RGBA(); r != g || g != b {
+		t.Fatalf(`got pixel %v, want the pipeline applied`, result.At(0, 0))
+	}
+
+	// A restarted watcher tells from the ledger the image was processed,
+	// even with a torn last line left by a crash.
+	ledger, err := os.OpenFile(dirs.ledger, os.O_WRONLY|os.O_APPEND, 0)
+	if err == nil {
+		_, err = ledger.WriteString(`{"file":"other.png","sha`)
+		ledger.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	settle(startWatcher(t, dirs, pipeline, `{name}.{ext}`))
+	if outputs := listDir(t, dirs.output); len(outputs) != 1 {
+		t.Fatalf(`got outputs %v after a restart, want the image left processed`, outputs)
+	}
+
+	// The same name with new content is another image.
+	writePNG(t, image, 20, 10, color.NRGBA{B: 255, A: 255})
+	restarted := startWatcher(t, dirs, pipeline, `{name}.{ext}`)
+	settle(restarted)
+	if outputs := listDir(t, dirs.output); len(outputs) != 2 || outputs[0] != `photo-2.png` {
+		t.Fatalf(`got outputs %v, want the new content processed next to the old one`, outputs)
+	}
+
+	content, err := os.ReadFile(dirs.ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []LedgerEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		entry := LedgerEntry{}
+		if json.Unmarshal([]byte(line), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) != 2 || entries[0].Output != `photo.png` || entries[1].Output != `photo-2.png` || entries[0].SHA256 == entries[1].SHA256 {
+		t.Fatalf(`got ledger entries %+v, want both versions recorded`, entries)
+	}
+}
+
+func TestWatcherNamesResultsWithTemplate(t *testing.T) {
+
+	dirs := newTestDirs(t)
+	writePNG(t, filepath.Join(dirs.input, `photo.png`), 4, 4, color.White)
+
+	watcher := startWatcher(t, dirs, `{"operations":[{"name":"grayscale"}],"output":{"format":"jpeg"}}`, `{preset}/{date}/{name}-small.{ext}`)
+	settle(watcher)
+
+	want := filepath.Join(dirs.output, `pipeline`, time.Now().Format(time.DateOnly), `photo-small.jpg`)
+	_, err := os.Stat(want)
+	if err != nil {
+		t.Fatalf(`got no result at %s: %v`, want, err)
+	}
+
+	if name := outputName(`{name}@{preset}.{ext}`, `photo.jpeg`, imaging.PNG, `thumbnail@3`, time.Now()); name != `photo@thumbnail@3.png` {
+		t.Fatalf(`got name %s, want photo@thumbnail@3.png`, name)
+	}
+}
+
+func TestNewSettingsRejectsInvalidSettings(t *testing.T) {
+
+	pipeline := `{"operations":[{"name":"grayscale"}]}`
+	invalid := []struct {
+		preset       string
+		pipeline     string
+		nameTemplate string
+		want         string
+	}{
+		{``, ``, `{name}`, `Must set a preset or a pipeline`},
+		{`thumbnail`, pipeline, `{name}`, `Must set a preset or a pipeline`},
+		{``, pipeline, `result.{ext}`, `must contain {name}`},
+		{``, pipeline, `{name}-{size}.{ext}`, `may only contain`},
+		{``, pipeline, `../{name}.{ext}`, `within the output directory`},
+		{``, `{"operations":[{"name":"blur"}]}`, `{name}`, `operations: Unknown operation: blur`},
+		{``, `{"operations":[{"name":"resize","metadata":{"width":-1}}]}`, `{name}`, `operations[0].metadata.width`},
+		{``, `{"operations":[{"name":"grayscale"}],"output":{"quality":101}}`, `{name}`, `output.quality`},
+	}
+	for _, settings := range invalid {
+		_, err := NewSettings(settings.preset, settings.pipeline, settings.nameTemplate)
+		if err == nil || !strings.Contains(err.Error(), settings.want) {
+			t.Fatalf(`got error %v for %+v, want it to mention %q`, err, settings, settings.want)
+		}
+	}
+}
+
+func TestWatcherMovesFailedImagesWithErrorSidecar(t *testing.T) {
+
+	dirs := newTestDirs(t)
+	err := os.WriteFile(filepath.Join(dirs.input, `broken.png`), []byte(`not an image`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePNG(t, filepath.Join(dirs.input, `small.png`), 10, 10, color.White)
+
+	watcher := startWatcher(t, dirs, `{"operations":[{"name":"crop","metadata":{"minX":0,"minY":0,"maxX":50,"maxY":5}}]}`, `{name}.{ext}`)
+	settle(watcher)
+
+	if inputs := listDir(t, dirs.input); len(inputs) != 0 {
+		t.Fatalf(`got inputs %v left, want the failed images moved`, inputs)
+	}
+
+	expected := map[string]struct {
+		code  problem.Code
+		field string
+	}{
+		`broken.png`: {problem.InvalidImage, ``},
+		`small.png`:  {problem.InvalidBounds, `maxX`},
+	}
+	for name, want := range expected {
+		_, err := os.Stat(filepath.Join(dirs.errors, name))
+		if err != nil {
+			t.Fatalf(`got no %s in the errors directory: %v`, name, err)
+		}
+
+		content, err := os.ReadFile(filepath.Join(dirs.errors, name+ErrorSuffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		details := failure{}
+		err = json.Unmarshal(content, &details)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if details.File != name || details.Code != want.code || details.Error == `` {
+			t.Fatalf(`got sidecar %+v for %s, want code %s`, details, name, want.code)
+		}
+		if want.field != `` && (len(details.Fields) != 1 || details.Fields[0].Field != want.field) {
+			t.Fatalf(`got fields %+v for %s, want %s reported`, details.Fields, name, want.field)
+		}
+	}
+
+	// Failures are not recorded, the same image dropped again is retried.
+	content, err := os.ReadFile(dirs.ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) != 0 {
+		t.Fatalf(`got ledger %q, want failures left out`, content)
+	}
+}